@@ -7,6 +7,8 @@ package boosd
 import (
 	"fmt"
 	"go/token"
+	"strconv"
+	"strings"
 )
 
 type ObjectKind int
@@ -86,6 +88,28 @@ type CommentGroup struct {
 func (g *CommentGroup) Pos() token.Pos { return g.List[0].Pos() }
 func (g *CommentGroup) End() token.Pos { return g.List[len(g.List)-1].End() }
 
+// Text returns the text of the comments, without comment markers
+// or surrounding blank lines.  A nil group has empty text.
+func (g *CommentGroup) Text() string {
+	if g == nil {
+		return ""
+	}
+	var lines []string
+	for _, c := range g.List {
+		text := c.Text
+		switch {
+		case strings.HasPrefix(text, "//"):
+			text = text[2:]
+		case strings.HasPrefix(text, "/*"):
+			text = strings.TrimSuffix(text[2:], "*/")
+		}
+		for _, line := range strings.Split(text, "\n") {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
 // ----------------------------------------------------------------------------
 // Expressions and types
 
@@ -216,6 +240,15 @@ type (
 		Rbrack token.Pos // position of "]"
 	}
 
+	// An IndexListExpr node represents an expression followed by
+	// several indices, such as a lookup in a two-dimensional table.
+	IndexListExpr struct {
+		X       Expr      // expression
+		Lbrack  token.Pos // position of "["
+		Indices []Expr    // index expressions
+		Rbrack  token.Pos // position of "]"
+	}
+
 	// A CallExpr node represents an expression followed by an argument list.
 	CallExpr struct {
 		Fun    Expr      // function expression
@@ -273,6 +306,15 @@ type (
 		Rbrack token.Pos
 	}
 
+	// A ListExpr node represents a bracketed, comma-separated
+	// list of expressions, such as the flows in a stock
+	// initializer.
+	ListExpr struct {
+		Lbrack token.Pos
+		Elts   []Expr // list elements
+		Rbrack token.Pos
+	}
+
 	TableForwardExpr struct {
 		Ys []*BasicLit
 	}
@@ -310,11 +352,13 @@ func (x *CompositeLit) Pos() token.Pos {
 func (x *ParenExpr) Pos() token.Pos     { return x.Lparen }
 func (x *SelectorExpr) Pos() token.Pos  { return x.X.Pos() }
 func (x *IndexExpr) Pos() token.Pos     { return x.X.Pos() }
+func (x *IndexListExpr) Pos() token.Pos { return x.X.Pos() }
 func (x *CallExpr) Pos() token.Pos      { return x.Fun.Pos() }
 func (x *UnaryExpr) Pos() token.Pos     { return x.OpPos }
 func (x *BinaryExpr) Pos() token.Pos    { return x.X.Pos() }
 func (x *PairExpr) Pos() token.Pos      { return x.X.Pos() }
 func (x *TableExpr) Pos() token.Pos     { return x.Lbrack }
+func (x *ListExpr) Pos() token.Pos      { return x.Lbrack }
 func (x *UnitExpr) Pos() token.Pos      { return x.X.Pos() }
 func (x *KeyValueExpr) Pos() token.Pos  { return x.Key.Pos() }
 func (x *ModelType) Pos() token.Pos     { return x.Model }
@@ -327,10 +371,12 @@ func (x *CompositeLit) End() token.Pos  { return x.Rbrace + 1 }
 func (x *ParenExpr) End() token.Pos     { return x.Rparen + 1 }
 func (x *SelectorExpr) End() token.Pos  { return x.Sel.End() }
 func (x *IndexExpr) End() token.Pos     { return x.Rbrack + 1 }
+func (x *IndexListExpr) End() token.Pos { return x.Rbrack + 1 }
 func (x *CallExpr) End() token.Pos      { return x.Rparen + 1 }
 func (x *UnaryExpr) End() token.Pos     { return x.X.End() }
 func (x *BinaryExpr) End() token.Pos    { return x.Y.End() }
 func (x *TableExpr) End() token.Pos     { return x.Rbrack + 1 }
+func (x *ListExpr) End() token.Pos      { return x.Rbrack + 1 }
 func (x *PairExpr) End() token.Pos      { return x.Y.End() }
 func (x *UnitExpr) End() token.Pos      { return x.Unit.End() }
 func (x *KeyValueExpr) End() token.Pos  { return x.Value.End() }
@@ -340,36 +386,101 @@ func (x *InterfaceType) End() token.Pos { return x.Methods.End() }
 // exprNode() ensures that only expression/type nodes can be
 // assigned to an ExprNode.
 //
-func (*BadExpr) exprNode()      {}
-func (*Ident) exprNode()        {}
-func (*BasicLit) exprNode()     {}
-func (*CompositeLit) exprNode() {}
-func (*ParenExpr) exprNode()    {}
-func (*SelectorExpr) exprNode() {}
-func (*IndexExpr) exprNode()    {}
-func (*CallExpr) exprNode()     {}
-func (*UnaryExpr) exprNode()    {}
-func (*BinaryExpr) exprNode()   {}
-func (*TableExpr) exprNode()    {}
-func (*PairExpr) exprNode()     {}
-func (*UnitExpr) exprNode()     {}
-func (*KeyValueExpr) exprNode() {}
+func (*BadExpr) exprNode()       {}
+func (*Ident) exprNode()         {}
+func (*BasicLit) exprNode()      {}
+func (*CompositeLit) exprNode()  {}
+func (*ParenExpr) exprNode()     {}
+func (*SelectorExpr) exprNode()  {}
+func (*IndexExpr) exprNode()     {}
+func (*IndexListExpr) exprNode() {}
+func (*CallExpr) exprNode()      {}
+func (*UnaryExpr) exprNode()     {}
+func (*BinaryExpr) exprNode()    {}
+func (*TableExpr) exprNode()     {}
+func (*ListExpr) exprNode()      {}
+func (*PairExpr) exprNode()      {}
+func (*UnitExpr) exprNode()      {}
+func (*KeyValueExpr) exprNode()  {}
 
 func (*ModelType) exprNode()     {}
 func (*InterfaceType) exprNode() {}
 
+// String returns the literal as Go code.  Numbers are always written
+// as floating-point constants, so that 1/2 is a half in compiled
+// models, as it is to the interpreter, rather than Go's integer
+// division of two integer constants.
 func (bl *BasicLit) String() string {
-	return bl.Value
+	if bl.Kind != token.FLOAT {
+		return bl.Value
+	}
+	v, err := strconv.ParseFloat(bl.Value, 64)
+	if err != nil {
+		return bl.Value
+	}
+	s := strconv.FormatFloat(v, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
 }
 
 func (i Ident) String() string {
+	if code, ok := intrinsics[strings.ToLower(i.Name)]; ok {
+		return code
+	}
 	return fmt.Sprintf(`s.Curr["%s"]`, i.Name)
 }
 
+// opFuncs are the runtime functions that implement the comparison
+// and logical operators, as Go's give bools rather than numbers.
+var opFuncs = map[token.Token]string{
+	token.LSS:  "Less",
+	token.LEQ:  "LessEq",
+	token.GTR:  "Greater",
+	token.GEQ:  "GreaterEq",
+	token.EQL:  "Equal",
+	token.NEQ:  "NotEqual",
+	token.LAND: "And",
+	token.LOR:  "Or",
+}
+
 func (x *BinaryExpr) String() string {
+	if fn, ok := opFuncs[x.Op]; ok {
+		return fmt.Sprintf("runtime.%s(%s, %s)", fn, x.X, x.Y)
+	}
 	return fmt.Sprintf("((%s) %s (%s))", x.X, x.Op, x.Y)
 }
 
+func (x *UnaryExpr) String() string {
+	return fmt.Sprintf("(%s(%s))", x.Op, x.X)
+}
+
+func (x *ParenExpr) String() string {
+	return fmt.Sprintf("(%s)", x.X)
+}
+
+// String returns a call to the runtime's implementation of a
+// stateless builtin function.  Stateful builtins, like smooth and
+// delay, are rewritten by the generator before equations are
+// printed.
+func (x *CallExpr) String() string {
+	args := make([]string, len(x.Args))
+	for i, a := range x.Args {
+		args[i] = fmt.Sprintf("%s", a)
+	}
+	name := fmt.Sprintf("%s", x.Fun)
+	if id, ok := x.Fun.(*Ident); ok {
+		b := builtinFuncs[strings.ToLower(id.Name)]
+		if b.method {
+			name = "s." + b.impl
+		} else {
+			name = "runtime." + b.impl
+		}
+	}
+	return fmt.Sprintf("%s(%s)", name, strings.Join(args, ", "))
+}
+
 func (e *IndexExpr) String() string {
 	name, i := "", ""
 	switch ee := e.X.(type) {
@@ -387,6 +498,18 @@ func (e *IndexExpr) String() string {
 	return fmt.Sprintf(`s.Tables["%s"].Lookup(%s)`, name, i)
 }
 
+func (e *IndexListExpr) String() string {
+	name := fmt.Sprintf("%s", e.X)
+	if id, ok := e.X.(*Ident); ok {
+		name = id.Name
+	}
+	idx := make([]string, len(e.Indices))
+	for i, ie := range e.Indices {
+		idx[i] = fmt.Sprintf("%s", ie)
+	}
+	return fmt.Sprintf(`s.Tables2D["%s"].Lookup(%s)`, name, strings.Join(idx, ", "))
+}
+
 // FIXME: this isn't correct
 func (x *UnitExpr) String() string {
 	return fmt.Sprintf("%s", x.X)
@@ -445,6 +568,7 @@ type (
 		TokPos token.Pos   // position of Tok
 		Tok    token.Token // assignment token, DEFINE
 		Rhs    Expr
+		Eqn    string      // source text of Rhs, with whitespace collapsed
 	}
 
 	// A BlockStmt node represents a braced statement list.
@@ -657,6 +781,7 @@ func (d *ModelDecl) declNode()     {}
 // via Doc and Comment fields.
 //
 type File struct {
+	Filename   string          // name of the source file, as given to Parse
 	Doc        *CommentGroup   // associated documentation; or nil
 	Package    token.Pos       // position of "package" keyword
 	Name       *Ident          // package name
@@ -0,0 +1,298 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package boosd
+
+import (
+	"fmt"
+	"github.com/bpowers/boosd/runtime"
+	"go/token"
+	"strings"
+)
+
+// An expander rewrites a call to a stateful builtin into the
+// internal variables that carry its state, returning the expression
+// to use in place of the call.
+type expander func(g *generator, args []Expr) (Expr, error)
+
+// builtin describes a function callable from model equations.
+// Stateless builtins are implemented by the runtime function named
+// by impl, or by the BaseSim method named by impl if method is set.
+// Stateful ones are implemented by expand.
+type builtin struct {
+	impl    string
+	method  bool
+	random  bool // draws from the sim's random number generator
+	minArgs int
+	maxArgs int
+	expand  expander
+}
+
+// builtinFuncs is keyed by lowercased name, as builtins are matched
+// without regard to case.
+var builtinFuncs map[string]builtin
+
+func init() {
+	builtinFuncs = map[string]builtin{
+		"abs":  {impl: "Abs", minArgs: 1, maxArgs: 1},
+		"exp":  {impl: "Exp", minArgs: 1, maxArgs: 1},
+		"ln":   {impl: "Ln", minArgs: 1, maxArgs: 1},
+		"sqrt": {impl: "Sqrt", minArgs: 1, maxArgs: 1},
+		"min":  {impl: "Min", minArgs: 2, maxArgs: 2},
+		"max":  {impl: "Max", minArgs: 2, maxArgs: 2},
+
+		// smooth(input, delay[, initial])
+		"smooth":  {minArgs: 2, maxArgs: 3, expand: smoothN(1)},
+		"smooth1": {minArgs: 2, maxArgs: 3, expand: smoothN(1)},
+		"smooth3": {minArgs: 2, maxArgs: 3, expand: smoothN(3)},
+		// delay1(input, delay[, initial])
+		"delay1": {minArgs: 2, maxArgs: 3, expand: delayN(1)},
+		"delay3": {minArgs: 2, maxArgs: 3, expand: delayN(3)},
+		// delayn(input, delay, n[, initial])
+		"delayn": {minArgs: 3, maxArgs: 4, expand: expandDelayN},
+		// delay_fixed(input, delay[, initial])
+		"delay_fixed": {minArgs: 2, maxArgs: 3, expand: expandDelayFixed},
+
+		// random_uniform(min, max)
+		"random_uniform": {impl: "RandomUniform", method: true, random: true, minArgs: 2, maxArgs: 2},
+		// random_normal(min, max, mean, sd)
+		"random_normal": {impl: "RandomNormal", method: true, random: true, minArgs: 4, maxArgs: 4},
+		// random_poisson(mean)
+		"random_poisson": {impl: "RandomPoisson", method: true, random: true, minArgs: 1, maxArgs: 1},
+		// pink_noise(mean, sd, correlation_time)
+		"pink_noise": {random: true, minArgs: 3, maxArgs: 3, expand: expandPinkNoise},
+
+		// pulse(volume, first[, interval])
+		"pulse": {minArgs: 2, maxArgs: 3, expand: expandPulse},
+
+		// previous(x, initial)
+		"previous": {minArgs: 2, maxArgs: 2, expand: expandPrevious},
+		// initial(x)
+		"initial": {minArgs: 1, maxArgs: 1, expand: expandInitial},
+	}
+}
+
+// intrinsics maps the names of the simulation-time intrinsics,
+// lowercased, to the Go code giving their value.
+var intrinsics = map[string]string{
+	"time":         "s.Now()",
+	"dt":           "s.Time.DT",
+	"initial_time": "s.Time.Start",
+	"final_time":   "s.Time.End",
+}
+
+// goExpr is a fragment of generated Go code standing in for an
+// expression, such as the output of a stateful builtin.
+type goExpr string
+
+func (e goExpr) Pos() token.Pos { return token.NoPos }
+func (e goExpr) End() token.Pos { return token.NoPos }
+func (goExpr) exprNode()        {}
+
+func (e goExpr) String() string {
+	return string(e)
+}
+
+// rewrite checks the function calls in e and replaces calls to
+// stateful builtins with references to the internal variables
+// implementing them.  It returns the rewritten expression.
+func (g *generator) rewrite(e Expr) (Expr, error) {
+	var err error
+	switch x := e.(type) {
+	case *UnitExpr:
+		x.X, err = g.rewrite(x.X)
+	case *ParenExpr:
+		x.X, err = g.rewrite(x.X)
+	case *UnaryExpr:
+		x.X, err = g.rewrite(x.X)
+	case *BinaryExpr:
+		if x.X, err = g.rewrite(x.X); err == nil {
+			x.Y, err = g.rewrite(x.Y)
+		}
+	case *IndexExpr:
+		x.Index, err = g.rewrite(x.Index)
+	case *IndexListExpr:
+		for i, ie := range x.Indices {
+			if x.Indices[i], err = g.rewrite(ie); err != nil {
+				return nil, err
+			}
+		}
+	case *CallExpr:
+		// rewrite inside out, so that nested stateful calls
+		// become ordinary references first.
+		for i, a := range x.Args {
+			if x.Args[i], err = g.rewrite(a); err != nil {
+				return nil, err
+			}
+		}
+		id, ok := x.Fun.(*Ident)
+		if !ok {
+			return nil, fmt.Errorf("call of non-function %s", x.Fun)
+		}
+		b, ok := builtinFuncs[strings.ToLower(id.Name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown function %s", id.Name)
+		}
+		if n := len(x.Args); n < b.minArgs || n > b.maxArgs {
+			return nil, fmt.Errorf("%s: wrong number of arguments (%d)", id.Name, n)
+		}
+		if b.random {
+			g.curr.Stochastic = true
+		}
+		if b.expand != nil {
+			return b.expand(g, x.Args)
+		}
+	}
+	return e, err
+}
+
+// initially adds code run once, on the first evaluation of the
+// flows, before the equation currently being generated.
+func (g *generator) initially(code string) {
+	eqn := fmt.Sprintf(`if s.Initializing { %s }`, code)
+	g.curr.Equations = append(g.curr.Equations, eqn)
+}
+
+// hiddenStock declares an internal stock, starting at the value of
+// the Go expression init and changing at the rate net.
+func (g *generator) hiddenStock(name, init, net string) {
+	g.curr.Vars[name] = runtime.Var{Name: name, Type: runtime.TyStock, Internal: true}
+	g.initially(fmt.Sprintf(`s.Curr["%s"] = %s`, name, init))
+	eqn := fmt.Sprintf(`s.Next["%s"] = s.Curr["%s"] + (%s)*dt`, name, name, net)
+	g.curr.Stocks = append(g.curr.Stocks, eqn)
+}
+
+// initialArg returns the optional initial value argument at index
+// i, which defaults to the builtin's input.
+func initialArg(args []Expr, i int) Expr {
+	if len(args) > i {
+		return args[i]
+	}
+	return args[0]
+}
+
+// smoothN returns an expander for an order-n exponential smooth,
+// built from n first-order smooths in series each taking 1/n of
+// the smoothing time.  The order is written as a float, so that a
+// constant smoothing time isn't divided as an integer.
+func smoothN(n int) expander {
+	return func(g *generator, args []Expr) (Expr, error) {
+		init := initialArg(args, 2)
+		base := g.tmpName("smooth")
+		prev := fmt.Sprintf("%s", args[0])
+		stage := fmt.Sprintf("((%s) / %d.0)", args[1], n)
+		for i := 0; i < n; i++ {
+			name := fmt.Sprintf("%s.%d", base, i)
+			net := fmt.Sprintf(`((%s) - s.Curr["%s"]) / %s`, prev, name, stage)
+			g.hiddenStock(name, fmt.Sprintf("%s", init), net)
+			prev = fmt.Sprintf(`s.Curr["%s"]`, name)
+		}
+		return goExpr(prev), nil
+	}
+}
+
+// delayN returns an expander for an order-n material delay: n
+// stocks in series, each draining at 1/n of the delay time.  Each
+// stage starts in equilibrium with the initial value.
+func delayN(n int) expander {
+	return func(g *generator, args []Expr) (Expr, error) {
+		return g.delay(args[0], args[1], initialArg(args, 2), n), nil
+	}
+}
+
+func expandDelayN(g *generator, args []Expr) (Expr, error) {
+	n, err := constEval(args[2])
+	if err != nil || n < 1 || n != float64(int(n)) {
+		return nil, fmt.Errorf("delayn: order %s must be a positive integer constant", args[2])
+	}
+	return g.delay(args[0], args[1], initialArg(args, 3), int(n)), nil
+}
+
+func (g *generator) delay(in, delay, init Expr, n int) Expr {
+	base := g.tmpName("delay")
+	inflow := fmt.Sprintf("%s", in)
+	stage := fmt.Sprintf("((%s) / %d.0)", delay, n)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("%s.%d", base, i)
+		outflow := fmt.Sprintf(`s.Curr["%s"] / %s`, name, stage)
+		g.hiddenStock(name, fmt.Sprintf("(%s) * %s", init, stage),
+			fmt.Sprintf("(%s) - %s", inflow, outflow))
+		inflow = outflow
+	}
+	return goExpr(fmt.Sprintf("(%s)", inflow))
+}
+
+// expandDelayFixed implements a pipeline delay, whose output is
+// exactly its input delay time units ago, with an internal
+// conveyor.  The delay time must be constant.
+func expandDelayFixed(g *generator, args []Expr) (Expr, error) {
+	delay, err := constEval(args[1])
+	if err != nil || delay <= 0 {
+		return nil, fmt.Errorf("delay_fixed: delay %s must be a positive constant", args[1])
+	}
+	if err := g.discrete("delay_fixed"); err != nil {
+		return nil, err
+	}
+	init := initialArg(args, 2)
+	name := g.tmpName("delay_fixed")
+	g.curr.Vars[name] = runtime.Var{
+		Name:     name,
+		Type:     runtime.TyStock,
+		Flavor:   runtime.StockConveyor,
+		Internal: true,
+	}
+	g.initially(fmt.Sprintf(`s.Conveyors["%s"] = runtime.NewConveyor((%s)*%f, %f, dt, 0)
+		s.Curr["%s"] = s.Conveyors["%s"].Total()`, name, init, delay, delay, name, name))
+	eqn := fmt.Sprintf(`s.Next["%s"] = s.Conveyors["%s"].Advance((%s)*dt, s.Conveyors["%s"].Outflow(dt)*dt)`,
+		name, name, args[0], name)
+	g.curr.Stocks = append(g.curr.Stocks, eqn)
+	return goExpr(fmt.Sprintf(`s.Conveyors["%s"].Outflow(dt)`, name)), nil
+}
+
+// expandPinkNoise implements first-order autocorrelated noise:
+// white noise smoothed over the correlation time, starting at the
+// mean.  Correlation times shorter than dt are taken as dt, as
+// Rand.WhiteNoise does, which also keeps the smoothing stable.
+func expandPinkNoise(g *generator, args []Expr) (Expr, error) {
+	mean, sd := args[0], args[1]
+	corr := fmt.Sprintf("runtime.Max(%s, dt)", args[2])
+	name := g.tmpName("pink_noise")
+	white := fmt.Sprintf("s.WhiteNoise(%s, %s, %s, dt)", mean, sd, corr)
+	net := fmt.Sprintf(`(%s - s.Curr["%s"]) / (%s)`, white, name, corr)
+	g.hiddenStock(name, fmt.Sprintf("%s", mean), net)
+	return goExpr(fmt.Sprintf(`s.Curr["%s"]`, name)), nil
+}
+
+// expandPulse implements pulse(volume, first[, interval]), which
+// only pulses once if interval is left out.
+func expandPulse(g *generator, args []Expr) (Expr, error) {
+	interval := "0"
+	if len(args) > 2 {
+		interval = fmt.Sprintf("%s", args[2])
+	}
+	return goExpr(fmt.Sprintf("s.Pulse(%s, %s, %s)", args[0], args[1], interval)), nil
+}
+
+// expandPrevious implements previous(x, initial), the value x had at
+// the last time step, with an internal variable that is carried
+// from one step to the next along with the stocks.
+func expandPrevious(g *generator, args []Expr) (Expr, error) {
+	name := g.tmpName("previous")
+	g.curr.Vars[name] = runtime.Var{Name: name, Type: runtime.TyAux, Internal: true}
+	g.initially(fmt.Sprintf(`s.Curr["%s"] = %s`, name, args[1]))
+	eqn := fmt.Sprintf(`s.Next["%s"] = %s`, name, args[0])
+	g.curr.Stocks = append(g.curr.Stocks, eqn)
+	return goExpr(fmt.Sprintf(`s.Curr["%s"]`, name)), nil
+}
+
+// expandInitial implements initial(x), the value x had at the start
+// of the simulation.
+func expandInitial(g *generator, args []Expr) (Expr, error) {
+	name := g.tmpName("initial")
+	g.curr.Vars[name] = runtime.Var{Name: name, Type: runtime.TyAux, Internal: true}
+	g.initially(fmt.Sprintf(`s.Curr["%s"] = %s`, name, args[0]))
+	eqn := fmt.Sprintf(`s.Next["%s"] = s.Curr["%s"]`, name, name)
+	g.curr.Stocks = append(g.curr.Stocks, eqn)
+	return goExpr(fmt.Sprintf(`s.Curr["%s"]`, name)), nil
+}
@@ -0,0 +1,244 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package boosd
+
+import (
+	"fmt"
+	"github.com/bpowers/boosd/runtime"
+	"go/token"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// eqnModel returns the source of a model running from 0 to end with
+// a dt of 1, and the given equations.
+func eqnModel(end float64, eqns ...string) string {
+	return fmt.Sprintf(`
+main model {
+        timespec = {
+                start:     0
+                end:       %g
+                dt:        1
+                save_step: 1
+        }
+        %s
+}
+`, end, strings.Join(eqns, "\n        "))
+}
+
+// within reports whether got and want are the same length and equal
+// to within 1e-9.  NaN is never within anything.
+func within(got, want []float64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if !(math.Abs(got[i]-want[i]) <= 1e-9) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSmoothAndDelay(t *testing.T) {
+	for _, c := range []struct {
+		eqn  string
+		want []float64
+	}{
+		// the initial value defaults to the input
+		{"out = smooth(10, 2)", []float64{10, 10, 10, 10}},
+		{"out = smooth3(10, 3)", []float64{10, 10, 10, 10}},
+		{"out = delay3(10, 3)", []float64{10, 10, 10, 10}},
+		{"out = delay_fixed(10, 2)", []float64{10, 10, 10, 10}},
+		{"out = smooth(10, 2, 0)", []float64{0, 5, 7.5, 8.75}},
+		{"out = smooth1(10, 2, 0)", []float64{0, 5, 7.5, 8.75}},
+		{"out = delay1(10, 2, 0)", []float64{0, 5, 7.5, 8.75}},
+		{"out = delayn(10, 2, 1, 0)", []float64{0, 5, 7.5, 8.75}},
+		// each of the three stages takes a third of the time
+		{"out = smooth3(9, 3, 0)", []float64{0, 0, 0, 9}},
+		{"out = delay_fixed(time, 2, -1)", []float64{-1, -1, 0, 1}},
+	} {
+		for name, m := range backends(t, eqnModel(3, c.eqn)) {
+			got := series(t, run(t, m, nil), "out")
+			if !within(got, c.want) {
+				t.Errorf("%s: %s: out = %v, want %v", name, c.eqn, got, c.want)
+			}
+		}
+	}
+}
+
+// TestCompiledSmoothAndDelay checks that compiled models agree with
+// the other backends on smooths and delays whose delay time isn't a
+// multiple of their order.
+func TestCompiledSmoothAndDelay(t *testing.T) {
+	src := eqnModel(6, "starts = 10",
+		"s3 = smooth3(starts, 2)",
+		"d3 = delay3(starts, 2, 0)",
+		"dn = delayn(starts, 3, 4, 0)",
+		"p = previous(s3, 0)")
+	want := compiled(t, src)
+	for name, m := range backends(t, src) {
+		s := run(t, m, nil)
+		for _, v := range []string{"s3", "d3", "dn", "p"} {
+			if got := series(t, s, v); !within(got, want[v]) {
+				t.Errorf("%s: %s = %v, but compiled %v", name, v, got, want[v])
+			}
+		}
+	}
+}
+
+func TestBuiltinStocksInternal(t *testing.T) {
+	src := eqnModel(3, "a = smooth3(time, 2)", "b = delay_fixed(time, 2)")
+	for name, m := range backends(t, src) {
+		var internal int
+		for _, n := range m.VarNames() {
+			v, _ := m.Var(n)
+			if v.Internal {
+				internal++
+			} else if n != "a" && n != "b" {
+				t.Errorf("%s: %s isn't internal", name, n)
+			}
+		}
+		// three smoothing stages and a conveyor
+		if internal < 4 {
+			t.Errorf("%s: %d internal variables, want at least 4", name, internal)
+		}
+	}
+}
+
+func TestBuiltinArgs(t *testing.T) {
+	for _, eqn := range []string{
+		"out = smooth(10)",
+		"out = smooth(10, 2, 0, 1)",
+		"out = delayn(10, 2, 1.5)",
+		"out = delay_fixed(10, time)",
+	} {
+		if _, err := Load(eqnModel(3, eqn)); err == nil {
+			t.Errorf("Load(%s) succeeded", eqn)
+		}
+	}
+}
+
+func TestRandomSeed(t *testing.T) {
+	src := eqnModel(20,
+		"u = random_uniform(0, 1)",
+		"n = random_normal(-2, 2, 0, 1)",
+		"p = random_poisson(4)",
+		"pink = pink_noise(0, 1, 3)")
+	seed := func(n int64) *runtime.Options { return &runtime.Options{Seed: &n} }
+	var byBackend [][]float64
+	for name, m := range backends(t, src) {
+		for _, v := range []string{"u", "n", "p", "pink"} {
+			a := series(t, run(t, m, seed(42)), v)
+			b := series(t, run(t, m, seed(42)), v)
+			if !reflect.DeepEqual(a, b) {
+				t.Errorf("%s: %s differs between runs with seed 42", name, v)
+			}
+			if c := series(t, run(t, m, seed(43)), v); reflect.DeepEqual(a, c) {
+				t.Errorf("%s: %s is the same with seeds 42 and 43", name, v)
+			}
+		}
+		byBackend = append(byBackend, series(t, run(t, m, seed(0)), "n"))
+		if a, b := byBackend[len(byBackend)-1], series(t, run(t, m, nil), "n"); reflect.DeepEqual(a, b) {
+			t.Errorf("%s: seed 0 gives the default seed's series", name)
+		}
+	}
+	if !reflect.DeepEqual(byBackend[0], byBackend[1]) {
+		t.Errorf("backends differ with the same seed: %v, %v", byBackend[0], byBackend[1])
+	}
+}
+
+// TestNoiseMethods checks that noise is drawn once per time step
+// whatever the integration method, so that a seed gives the same
+// series under each.
+func TestNoiseMethods(t *testing.T) {
+	src := eqnModel(20,
+		"u = random_uniform(0, 1)",
+		"n = random_normal(-2, 2, 0, 1)",
+		"sum stock = {",
+		"        initial: 0",
+		"        inflow: u + n",
+		"}")
+	for name, m := range backends(t, src) {
+		want := run(t, m, nil)
+		for _, method := range []string{runtime.RK2, runtime.RK4, runtime.RK45} {
+			s := run(t, m, &runtime.Options{Method: method})
+			for _, v := range []string{"u", "n", "sum"} {
+				if got := series(t, s, v); !within(got, series(t, want, v)) {
+					t.Errorf("%s: %s: %s = %v, but with euler %v", name, method, v, got, series(t, want, v))
+				}
+			}
+		}
+	}
+}
+
+func TestIntrinsics(t *testing.T) {
+	src := eqnModel(3,
+		"now = time",
+		"twice = TIME * 2",
+		"step = dt",
+		"from = initial_time",
+		"to = final_time",
+		"last = previous(time, -1)",
+		"first = initial(time + 5)",
+		"level stock = {",
+		"        initial: 1",
+		"        inflow: growth",
+		"}",
+		"growth flow = level",
+		"growth_was = previous(growth, 0)")
+	for name, m := range backends(t, src) {
+		s := run(t, m, nil)
+		for v, want := range map[string][]float64{
+			"now":        {0, 1, 2, 3},
+			"twice":      {0, 2, 4, 6},
+			"step":       {1, 1, 1, 1},
+			"from":       {0, 0, 0, 0},
+			"to":         {3, 3, 3, 3},
+			"last":       {-1, 0, 1, 2},
+			"first":      {5, 5, 5, 5},
+			"growth":     {1, 2, 4, 8},
+			"growth_was": {0, 1, 2, 4},
+		} {
+			if got := series(t, s, v); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %s = %v, want %v", name, v, got, want)
+			}
+		}
+	}
+}
+
+func TestReservedNames(t *testing.T) {
+	for _, eqn := range []string{
+		"time = 3",
+		"DT = 1",
+		"final_time = 10",
+		"smooth = 2",
+		"Initial = 1",
+		"delay_fixed stock = {\n initial: 1\n}",
+		// declarations without equations, too
+		"previous",
+	} {
+		src := eqnModel(3, eqn)
+		fset := token.NewFileSet()
+		_, err := Parse(fset.AddFile("reserved.osm", fset.Base(), len(src)), src)
+		// the equation is on the ninth line of the model
+		if err == nil || !strings.HasPrefix(err.Error(), "reserved.osm:9:9: ") ||
+			!strings.Contains(err.Error(), "rename") {
+			t.Errorf("Parse(%s): %v, want an error at 9:9 asking to rename it", eqn, err)
+		}
+		if _, err := Load(src); err == nil {
+			t.Errorf("Load(%s) succeeded", eqn)
+		}
+	}
+
+	src := eqnModel(3, "smoothed = 2", "initial_value = smoothed * 2", "out = smooth(initial_value, 1)")
+	for name, m := range backends(t, src) {
+		if got, want := series(t, run(t, m, nil), "out"), []float64{4, 4, 4, 4}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: out = %v, want %v", name, got, want)
+		}
+	}
+}
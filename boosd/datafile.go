@@ -0,0 +1,151 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package boosd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/bpowers/boosd/runtime"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// isFileCall reports whether e is a call of file, which reads a
+// table from a CSV file.
+func isFileCall(e Expr) bool {
+	call, ok := stripUnits(e).(*CallExpr)
+	if !ok {
+		return false
+	}
+	id, ok := call.Fun.(*Ident)
+	return ok && id.Name == "file"
+}
+
+// stringArg returns the value of the string literal e.
+func stringArg(e Expr) (string, bool) {
+	lit, ok := e.(*BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	return lit.Value, true
+}
+
+// splitHeader splits a CSV column header like "demand (widget/week)"
+// into its name and units.  The units can themselves contain
+// parentheses, as in "rate (1/(person*year))".
+func splitHeader(h string) (name, units string) {
+	h = strings.TrimSpace(h)
+	if !strings.HasSuffix(h, ")") {
+		return h, ""
+	}
+	// find the parenthesis matching the last one
+	depth := 0
+	for i := len(h) - 1; i > 0; i-- {
+		switch h[i] {
+		case ')':
+			depth++
+		case '(':
+			depth--
+		}
+		if depth == 0 {
+			return strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1 : len(h)-1])
+		}
+	}
+	return h, ""
+}
+
+// sameUnits compares units, ignoring whitespace.
+func sameUnits(a, b string) bool {
+	return strings.Join(strings.Fields(a), "") == strings.Join(strings.Fields(b), "")
+}
+
+// fileTable reads the table given by a call of file(path, xcol,
+// ycol) at compile time, so that the data is embedded in the
+// generated model.  path is relative to the model's file.  The
+// header row names the columns, and can give their units in
+// parentheses; if both the data file and the model give the units
+// of the table's values, they must match.
+func (g *generator) fileTable(call *CallExpr, units Expr) (t runtime.Table, err error) {
+	if !isFileCall(call) {
+		return t, fmt.Errorf("points %s not a table or a call of file", call)
+	}
+	if len(call.Args) != 3 {
+		return t, fmt.Errorf("file takes 3 arguments (path, x column, y column), not %d",
+			len(call.Args))
+	}
+	var args [3]string
+	for i, a := range call.Args {
+		var ok bool
+		if args[i], ok = stringArg(a); !ok {
+			return t, fmt.Errorf("file argument %d (%s) not a string", i+1, a)
+		}
+	}
+	path, xCol, yCol := args[0], args[1], args[2]
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(g.dir, path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return t, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return t, fmt.Errorf("%s: %s", path, err)
+	}
+	if len(records) == 0 {
+		return t, fmt.Errorf("%s: no header row", path)
+	}
+
+	xi, yi := -1, -1
+	var names []string
+	var yUnits string
+	for i, h := range records[0] {
+		name, u := splitHeader(h)
+		names = append(names, name)
+		switch name {
+		case xCol:
+			xi = i
+		case yCol:
+			yi, yUnits = i, u
+		}
+	}
+	for _, c := range []struct {
+		name string
+		i    int
+	}{{xCol, xi}, {yCol, yi}} {
+		if c.i < 0 {
+			return t, fmt.Errorf("%s: no column '%s' (have %s)",
+				path, c.name, strings.Join(names, ", "))
+		}
+	}
+
+	if lit, ok := units.(*BasicLit); ok && yUnits != "" && !sameUnits(lit.Value, yUnits) {
+		return t, fmt.Errorf("%s: column '%s' has units %s, but the model declares %s",
+			path, yCol, yUnits, lit.Value)
+	}
+
+	for n, rec := range records[1:] {
+		x, err := strconv.ParseFloat(strings.TrimSpace(rec[xi]), 64)
+		if err != nil {
+			return t, fmt.Errorf("%s:%d: column '%s': %s", path, n+2, xCol, err)
+		}
+		y, err := strconv.ParseFloat(strings.TrimSpace(rec[yi]), 64)
+		if err != nil {
+			return t, fmt.Errorf("%s:%d: column '%s': %s", path, n+2, yCol, err)
+		}
+		t.X = append(t.X, x)
+		t.Y = append(t.Y, y)
+	}
+
+	return t, nil
+}
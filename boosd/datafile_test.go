@@ -0,0 +1,65 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package boosd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitHeader(t *testing.T) {
+	for _, c := range []struct{ h, name, units string }{
+		{"time", "time", ""},
+		{" units (widget/week) ", "units", "widget/week"},
+		{"rate (1/(person*year))", "rate", "1/(person*year)"},
+		{"(odd)", "(odd)", ""},
+	} {
+		if name, units := splitHeader(c.h); name != c.name || units != c.units {
+			t.Errorf("splitHeader(%q) = %q, %q; want %q, %q", c.h, name, units, c.name, c.units)
+		}
+	}
+}
+
+func TestFileTable(t *testing.T) {
+	src := eqnModel(20,
+		"demand = file(\"testdata/demand.csv\", \"time\", \"units\") `widget/week`",
+		"price table = file(\"testdata/demand.csv\", \"time\", \"price\")",
+		"p = price[time - 5]")
+	for name, m := range backends(t, src) {
+		s := run(t, m, nil)
+		demand, p := series(t, s, "demand"), series(t, s, "p")
+		for _, c := range []struct {
+			t         int
+			demand, p float64
+		}{
+			{0, 100, 2},
+			{5, 125, 2},
+			{10, 150, 2.5},
+			{15, 135, 3},
+			{20, 120, 3},
+		} {
+			if demand[c.t] != c.demand || p[c.t] != c.p {
+				t.Errorf("%s: at %d, demand = %g and p = %g, want %g and %g",
+					name, c.t, demand[c.t], p[c.t], c.demand, c.p)
+			}
+		}
+	}
+}
+
+func TestFileTableErrors(t *testing.T) {
+	for _, c := range []struct{ eqn, err string }{
+		{`d = file("testdata/demand.csv", "time", "sales")`, "no column 'sales'"},
+		{`d = file("testdata/demand.csv", "day", "units")`, "no column 'day'"},
+		{"d = file(\"testdata/demand.csv\", \"time\", \"units\") `widget/day`", "units"},
+		{`d = file("testdata/missing.csv", "time", "units")`, "missing.csv"},
+		{`d = file("testdata/bad.csv", "time", "units")`, "bad.csv:3"},
+		{`d = file("testdata/demand.csv", "time")`, "3 arguments"},
+	} {
+		_, err := Load(eqnModel(3, c.eqn))
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("Load(%s): %v, want an error mentioning %q", c.eqn, err, c.err)
+		}
+	}
+}
@@ -12,9 +12,11 @@ import (
 	"go/parser"
 	"go/token"
 	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
-	"sync/atomic"
 	"text/template"
 	"unicode"
 )
@@ -25,7 +27,21 @@ var m{{$.CamelName}} = mdl{{$.CamelName}}{
 	runtime.BaseModel{
 		MName: "{{$.Name}}",
 		Vars: runtime.VarMap{ {{range $.Vars}}
-			"{{.Name}}": runtime.Var{"{{.Name}}", runtime.{{.Type}}},{{end}}
+			"{{.Name}}": runtime.Var{
+				Name: "{{.Name}}",
+				Type: runtime.{{.Type}},{{if .Units}}
+				Units: {{printf "%q" .Units}},{{end}}{{if .Inflows}}
+				Inflows: {{printf "%#v" .Inflows}},{{end}}{{if .Outflows}}
+				Outflows: {{printf "%#v" .Outflows}},{{end}}{{if .NonNegative}}
+				NonNegative: true,{{end}}{{if .Flavor}}
+				Flavor: runtime.{{.Flavor}},{{end}}{{if .Capacity}}
+				Capacity: {{.Capacity}},{{end}}{{if .Uniflow}}
+				Uniflow: true,{{end}}{{if .Internal}}
+				Internal: true,{{end}}{{if .Eqn}}
+				Eqn: {{printf "%q" .Eqn}},{{end}}{{if .Doc}}
+				Doc: {{printf "%q" .Doc}},{{end}}{{if .Deps}}
+				Deps: {{printf "%#v" .Deps}},{{end}}
+			},{{end}}
 		},
 		Defaults: runtime.DefaultMap{ {{range $n, $_ := $.Initials}}
 			{{if simple . }}"{{$n}}": {{.}}, {{end}}{{end}}
@@ -33,6 +49,11 @@ var m{{$.CamelName}} = mdl{{$.CamelName}}{
 		Tables: map[string]runtime.Table{ {{range $n, $_ := $.Tables}}
 			"{{$n}}": {{printf "%#v" .}}, {{end}}
 		},
+		Slots: {{printf "%#v" $.Slots}},{{if $.Tables2D}}
+		Tables2D: map[string]runtime.Table2D{ {{range $n, $_ := $.Tables2D}}
+			"{{$n}}": {{printf "%#v" .}}, {{end}}
+		},{{end}}{{with $.Attrs}}
+		Attrs: {{printf "%#v" .}},{{end}}
 	},
 }
 
@@ -47,22 +68,26 @@ type mdl{{$.CamelName}} struct {
 func (s *sim{{$.CamelName}}) calcInitial(dt float64) { {{if $.Initials }}
 	c := s.Coord
 	{{end}} {{range $n, $_ := $.Initials}}
-	s.Curr["{{$n}}"] = {{if simple .}}c.Data(s, "{{$n}}"){{else}}{{.}}{{end}}{{end}}
+	s.Curr[{{index $.Slots $n}}] = {{if simple .}}c.Data(s, "{{$n}}"){{else}}{{$.Slotted .}}{{end}}{{end}} {{range $.InitialStates}}
+	{{$.Slotted .}}{{end}}
 }
 
 func (s *sim{{$.CamelName}}) calcFlows(dt float64) { {{if $.UseCoordFlows }}
 	c := s.Coord
-	{{end}} {{range $.Equations}}
-	{{.}}{{end}}
+	{{end}} {{range $.StateFlows}}
+	{{$.Slotted .}}{{end}} {{range $.Equations}}
+	{{$.Slotted .}}{{end}}
 }
 
 func (s *sim{{$.CamelName}}) calcStocks(dt float64) { {{if $.UseCoordStocks }}
 	c := s.Coord
-	{{end}} {{range $.Stocks}}
-	{{.}}{{end}}
+	{{end}} {{range $.StockFlows}}
+	{{$.Slotted .}}{{end}} {{range $.Limits}}
+	{{$.Slotted .}}{{end}} {{range $.Stocks}}
+	{{$.Slotted .}}{{end}}
 }
 
-func (m *mdl{{$.CamelName}}) NewSim(name string, c runtime.Coordinator) runtime.Sim {
+func (m *mdl{{$.CamelName}}) NewSim(name string, c runtime.Coordinator, opts *runtime.Options) runtime.Sim {
 	ts := runtime.Timespec{
 		Start:    {{$.Time.Start}},
 		End:      {{$.Time.End}},
@@ -75,7 +100,7 @@ func (m *mdl{{$.CamelName}}) NewSim(name string, c runtime.Coordinator) runtime.
 	s.Parent = m
 	s.Coord = c
 
-	s.Init(m, ts, m.Tables)
+	s.Init(m, &m.BaseModel, ts, opts)
 
 	s.CalcInitial = s.calcInitial
 	s.CalcFlows = s.calcFlows
@@ -103,11 +128,21 @@ type genModel struct {
 	CamelName      string // camelcased
 	Vars           map[string]runtime.Var
 	Tables         map[string]runtime.Table
+	Tables2D       map[string]runtime.Table2D
+	Slots          map[string]int // index of each variable in the sim's Data
 	Time           runtime.Timespec
 	Equations      []string
+	StateFlows     []string // flows computed from conveyor state, before Equations
+	InitialStates  []string // creation of conveyors and queues, after Initials
+	StockFlows     []string // lifted inline flows, computed before Limits
+	Limits         []string // outflow limits for non-negative stocks
 	Stocks         []string
 	Initials       map[string]string
 	Abstract       bool
+	Stochastic     bool   // uses the random builtins
+	Method         string // integration_method, if given
+	StopWhen       string // stop_when, if given
+	stop           string // stop_when's equation, run after the others
 	UseCoordFlows  bool
 	UseCoordStocks bool
 }
@@ -115,20 +150,24 @@ type genModel struct {
 type generator struct {
 	Models map[string]*genModel
 	curr   *genModel
+
+	// dir is the directory of the file being compiled, which
+	// data file paths are relative to.
+	dir string
+
+	// tmps numbers the temporary names of the file, so that
+	// they are the same every time it is compiled.
+	tmps int
 }
 
 func (g *generator) declList(list []Decl) {
 }
 
-var tmpCount uint32
-
 // tmpName returns a unique temporary name that begins with the format
 // ".${base}_"
-func tmpName(base string) string {
-	i := atomic.AddUint32(&tmpCount, 1)
-	tmpCount++
-
-	return fmt.Sprintf(".%s_%d", base, i)
+func (g *generator) tmpName(base string) string {
+	g.tmps++
+	return fmt.Sprintf(".%s_%d", base, g.tmps)
 }
 
 // stripUnits returns the child of rhs if rhs is a UnitExpr, and
@@ -184,16 +223,15 @@ func kvConvert(e Expr) (k string, v Expr, err error) {
 	return ident.Name, kv.Value, nil
 }
 
-func (g *generator) timespec(elts []Expr) {
+func (g *generator) timespec(elts []Expr) error {
 	for _, e := range elts {
 		k, val, err := kvConvert(e)
 		if err != nil {
-			panic(fmt.Sprintf("kvConvert(%v): %s", e, err))
+			return fmt.Errorf("timespec: %s", err)
 		}
 		v, err := constEval(val)
 		if err != nil {
-			panic(fmt.Sprintf("timespec constEval(%v): %s",
-				val, err))
+			return fmt.Errorf("timespec %s: %s", k, err)
 		}
 		switch k {
 		case "start":
@@ -205,14 +243,38 @@ func (g *generator) timespec(elts []Expr) {
 		case "save_step":
 			g.curr.Time.SaveStep = v
 		default:
-			panic(fmt.Sprintf("timespec unknown key %s", k))
+			return fmt.Errorf("timespec: unknown key %s", k)
 		}
 	}
+	return nil
 }
 
 func varFromDecl(d *VarDecl) (v runtime.Var, err error) {
 	//log.Printf("var '%s': %s - %s", d.Name.Name, d.Type.Name, runtime.TypeForName(d.Type.Name))
-	return runtime.Var{d.Name.Name, runtime.TypeForName(d.Type.Name)}, nil
+	switch d.Type.Name {
+	case "uniflow":
+		// a uniflow is a flow that can only move material in
+		// one direction; it is otherwise an ordinary flow.
+		v = runtime.Var{Name: d.Name.Name, Type: runtime.TyFlow, Uniflow: true}
+	case "conveyor":
+		v = runtime.Var{Name: d.Name.Name, Type: runtime.TyStock, Flavor: runtime.StockConveyor}
+	case "queue":
+		v = runtime.Var{Name: d.Name.Name, Type: runtime.TyStock, Flavor: runtime.StockQueue}
+	default:
+		v = runtime.Var{Name: d.Name.Name, Type: runtime.TypeForName(d.Type.Name)}
+	}
+	v.Units = unitsString(d.Units)
+	v.Doc = d.Doc.Text()
+	return v, nil
+}
+
+// unitsString returns the units given by the literal e, or "" if e
+// isn't a literal.
+func unitsString(e Expr) string {
+	if lit, ok := e.(*BasicLit); ok {
+		return lit.Value
+	}
+	return ""
 }
 
 func (g *generator) initial(name string, expr Expr) (err error) {
@@ -240,82 +302,449 @@ func (g *generator) initial(name string, expr Expr) (err error) {
 	return
 }
 
+// flowList returns the flows named by the value of a stock
+// initializer key, which is either a single expression or a list of
+// them.
+func flowList(e Expr) []Expr {
+	e = stripUnits(e)
+	if l, ok := e.(*ListExpr); ok {
+		return l.Elts
+	}
+	return []Expr{e}
+}
+
+// flowRef returns the name of the variable computing the flow f
+// for stock.  Flows given as expressions rather than references are
+// lifted into internal flow variables, so that every flow in and
+// out of a stock has a name and a value.
+func (g *generator) flowRef(stock, key string, f Expr) (string, error) {
+	if r, ok := stripUnits(f).(*RefExpr); ok {
+		if _, ok := g.curr.Vars[r.Name]; ok {
+			return r.Name, nil
+		}
+	}
+	v := runtime.Var{Type: runtime.TyFlow, Internal: true, Eqn: source(f), Deps: deps(f)}
+	f, err := g.rewrite(f)
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %s", stock, key, err)
+	}
+	name := g.tmpName(fmt.Sprintf("%s_%s", stock, key))
+	v.Name = name
+	g.curr.Vars[name] = v
+	// lifted flows are evaluated just before the stocks are
+	// updated, as inline flows always have been.
+	eqn := fmt.Sprintf(`s.Curr["%s"] = %s`, name, f)
+	g.curr.StockFlows = append(g.curr.StockFlows, eqn)
+	return name, nil
+}
+
+// stockOutputs returns the names of the flows a conveyor computes
+// from its own state: the material leaving the end of the belt, and
+// optionally the material leaking off of it along the way.
+func stockOutputs(cl *CompositeLit) (names []string) {
+	for _, e := range cl.Elts {
+		k, val, err := kvConvert(e)
+		if err != nil {
+			continue
+		}
+		if k != "outflow" && k != "leakage" {
+			continue
+		}
+		if r, ok := stripUnits(val).(*RefExpr); ok {
+			names = append(names, r.Name)
+		}
+	}
+	return
+}
+
 func (g *generator) stock(name string, expr Expr) error {
 	cl, ok := expr.(*CompositeLit)
 	if !ok {
-		panic(fmt.Sprintf("stock is %T, not CompositeLit", expr))
+		return fmt.Errorf("stock(%s) is %T, not CompositeLit", name, expr)
+	}
+	v := g.curr.Vars[name]
+	if v.Flavor != runtime.StockReservoir {
+		if err := g.discrete(fmt.Sprintf("%s stock %s", v.Flavor.Name(), name)); err != nil {
+			return err
+		}
 	}
-	var bi, in, out string
+	var hasInitial, nonNeg bool
+	var in, out []string
+	var transit, leak, capacity float64
+	var leakage string
 	for _, e := range cl.Elts {
 		k, val, err := kvConvert(e)
 		if err != nil {
-			panic(fmt.Sprintf("kvConvert(%s): %s", name, err))
+			return fmt.Errorf("stock(%s): %s", name, err)
 		}
 		switch k {
 		case "initial":
+			if hasInitial {
+				return fmt.Errorf("stock(%s): multiple initial values", name)
+			}
+			hasInitial = true
 			if err := g.initial(name, val); err != nil {
 				return fmt.Errorf("initial(%s, %s): %s",
 					name, val, err)
 			}
-		case "biflow":
-			bi = fmt.Sprintf("+%s", val)
-		case "inflow":
-			in = fmt.Sprintf("+%s", val)
+		case "biflow", "inflow":
+			// repeated keys accumulate rather than replace
+			for _, f := range flowList(val) {
+				ref, err := g.flowRef(name, k, f)
+				if err != nil {
+					return err
+				}
+				in = append(in, ref)
+			}
 		case "outflow":
-			out = fmt.Sprintf("-(%s)", val)
+			if v.Flavor == runtime.StockConveyor {
+				// the conveyor computes its outflow; it
+				// was declared by g.vars.
+				id, _ := stripUnits(val).(*RefExpr)
+				if id == nil || len(out) > 0 {
+					return fmt.Errorf("conveyor(%s): outflow must be a single new name", name)
+				}
+				out = append(out, id.Name)
+				continue
+			}
+			for _, f := range flowList(val) {
+				ref, err := g.flowRef(name, k, f)
+				if err != nil {
+					return err
+				}
+				out = append(out, ref)
+			}
+		case "non_negative":
+			v, err := constEval(val)
+			if err != nil {
+				return fmt.Errorf("stock(%s): non_negative: %s", name, err)
+			}
+			nonNeg = v != 0
+		case "transit_time", "leak_fraction", "capacity":
+			n, err := constEval(val)
+			if err != nil {
+				return fmt.Errorf("stock(%s): %s: %s", name, k, err)
+			}
+			switch {
+			case k == "capacity" && v.Flavor != runtime.StockReservoir:
+				capacity = n
+			case k == "transit_time" && v.Flavor == runtime.StockConveyor:
+				transit = n
+			case k == "leak_fraction" && v.Flavor == runtime.StockConveyor:
+				if n < 0 || n >= 1 {
+					return fmt.Errorf("conveyor(%s): leak_fraction %f not in [0, 1)", name, n)
+				}
+				leak = n
+			default:
+				return fmt.Errorf("stock(%s): %s not valid for %s stocks", name, k, v.Flavor)
+			}
+		case "leakage":
+			id, _ := stripUnits(val).(*RefExpr)
+			if v.Flavor != runtime.StockConveyor || id == nil {
+				return fmt.Errorf("stock(%s): leakage must name a conveyor's leak flow", name)
+			}
+			leakage = id.Name
 		default:
-			panic(fmt.Sprintf("stock(%s): unknown k %s",
-				name, k))
+			return fmt.Errorf("stock(%s): unknown key %s", name, k)
+		}
+	}
+
+	// queues, like conveyors, can't give out more than they hold
+	if v.Flavor == runtime.StockQueue {
+		nonNeg = true
+	}
+
+	v.Inflows = in
+	v.Outflows = out
+	v.NonNegative = nonNeg
+	v.Capacity = capacity
+	if leakage != "" {
+		v.Outflows = append(v.Outflows, leakage)
+	}
+	g.curr.Vars[name] = v
+
+	if capacity > 0 && len(in) > 0 {
+		limit := fmt.Sprintf(`s.LimitInflows("%s", dt)`, name)
+		g.curr.Limits = append(g.curr.Limits, limit)
+	}
+	if nonNeg && len(out) > 0 {
+		limit := fmt.Sprintf(`s.LimitOutflows("%s", dt)`, name)
+		g.curr.Limits = append(g.curr.Limits, limit)
+	}
+
+	sum := func(flows []string) string {
+		if len(flows) == 0 {
+			return "0"
 		}
+		var terms []string
+		for _, f := range flows {
+			terms = append(terms, fmt.Sprintf(`s.Curr["%s"]`, f))
+		}
+		return strings.Join(terms, " + ")
+	}
+
+	var eqn string
+	switch v.Flavor {
+	case runtime.StockConveyor:
+		if transit <= 0 {
+			return fmt.Errorf("conveyor(%s): transit_time must be positive", name)
+		}
+		if len(out) == 0 {
+			return fmt.Errorf("conveyor(%s): missing outflow", name)
+		}
+		init := fmt.Sprintf(`s.Conveyors["%s"] = runtime.NewConveyor(s.Curr["%s"], %f, dt, %f)`,
+			name, name, transit, leak)
+		g.curr.InitialStates = append(g.curr.InitialStates, init)
+		flow := fmt.Sprintf(`s.Curr["%s"] = s.Conveyors["%s"].Outflow(dt)`, out[0], name)
+		g.curr.StateFlows = append(g.curr.StateFlows, flow)
+		if leakage != "" {
+			flow = fmt.Sprintf(`s.Curr["%s"] = s.Conveyors["%s"].Leakage(dt)`, leakage, name)
+			g.curr.StateFlows = append(g.curr.StateFlows, flow)
+		}
+		eqn = fmt.Sprintf(`s.Next["%s"] = s.Conveyors["%s"].Advance((%s)*dt, s.Curr["%s"]*dt)`,
+			name, name, sum(in), out[0])
+	case runtime.StockQueue:
+		init := fmt.Sprintf(`s.Queues["%s"] = runtime.NewQueue(s.Curr["%s"], s.Curr["time"])`,
+			name, name)
+		g.curr.InitialStates = append(g.curr.InitialStates, init)
+		eqn = fmt.Sprintf(`s.Next["%s"] = s.Queues["%s"].Advance((%s)*dt, (%s)*dt, s.Curr["time"])`,
+			name, name, sum(in), sum(out))
+	default:
+		net := "0"
+		if len(in) > 0 || len(out) > 0 {
+			var terms []string
+			for _, f := range in {
+				terms = append(terms, fmt.Sprintf(`+s.Curr["%s"]`, f))
+			}
+			for _, f := range out {
+				terms = append(terms, fmt.Sprintf(`-s.Curr["%s"]`, f))
+			}
+			net = strings.Join(terms, " ")
+		}
+		eqn = fmt.Sprintf(`s.Next["%s"] = s.Curr["%s"] + (%s)*dt`, name, name, net)
 	}
-	eqn := fmt.Sprintf(`s.Next["%s"] = s.Curr["%s"] + (%s %s %s)*dt`, name, name, bi, in, out)
 	g.curr.Stocks = append(g.curr.Stocks, eqn)
 	return nil
 }
 
+var lookupModes = map[string]runtime.LookupMode{
+	"interpolate": runtime.LookupInterpolate,
+	"extrapolate": runtime.LookupExtrapolate,
+	"discrete":    runtime.LookupDiscrete,
+}
+
+// table records the table defined by e, which is a table literal, a
+// table literal indexed by the value to look up, a file call, or a
+// composite literal giving the table's points and lookup mode:
+//
+//	effect table = {
+//		points: [(0, 1), (1, 1.5), (2, 1.75)]
+//		lookup: extrapolate
+//	}
+//
+// An aux defined by a file call is a time series: the table, looked
+// up at the current time.
 func (g *generator) table(name string, e Expr) error {
-	var t *TableExpr
+	var points, units Expr
+	mode := runtime.LookupInterpolate
 
-	// if we're wrapped in units, remove them.  Unit safety is a
-	// separate issue.
+	// units are only checked against data files, which can
+	// declare the units of their columns.
+	if u, ok := e.(*UnitExpr); ok {
+		units = u.Unit
+	}
 	e = stripUnits(e)
 
 	switch r := e.(type) {
 	case *TableExpr:
-		t = r
+		points = r
+	case *CallExpr:
+		points = r
+		if g.curr.Vars[name].Type == runtime.TyAux {
+			eqn := fmt.Sprintf(`s.Curr["%s"] = s.Tables["%s"].Lookup(s.Now())`,
+				name, name)
+			g.curr.Equations = append(g.curr.Equations, eqn)
+		}
 	case *IndexExpr:
-		t, _ = r.X.(*TableExpr)
+		points = r.X
 
 		eqn := fmt.Sprintf(`s.Curr["%s"] = s.Tables["%s"].Lookup(%s)`,
 			name, name, r.Index)
 		g.curr.Equations = append(g.curr.Equations, eqn)
-
+	case *CompositeLit:
+		if isGrid(r) {
+			return g.table2D(name, r)
+		}
+		for _, elt := range r.Elts {
+			k, val, err := kvConvert(elt)
+			if err != nil {
+				return err
+			}
+			switch k {
+			case "points":
+				points = val
+			case "lookup":
+				if mode, err = lookupMode(val); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("unknown key %s", k)
+			}
+		}
 	default:
 		return fmt.Errorf("table w/ non-table '%s': %#v", name, e)
 	}
+	if points == nil {
+		return fmt.Errorf("table '%s' has no points", name)
+	}
+
+	tab, err := g.points(points, units)
+	if err != nil {
+		return fmt.Errorf("table '%s': %s", name, err)
+	}
+	tab.Mode = mode
+
+	if err := tab.Validate(); err != nil {
+		return fmt.Errorf("table '%s': %s", name, err)
+	}
+
+	g.curr.Tables[name] = tab
+
+	return nil
+}
+
+// points returns a table with the points given by e, either a table
+// literal or a call of file.  units, if non-nil, are the units the
+// model declares for the table's values.
+func (g *generator) points(e, units Expr) (runtime.Table, error) {
+	// a unit on the points themselves, as in a composite
+	// literal, takes precedence.
+	if u, ok := e.(*UnitExpr); ok {
+		units = u.Unit
+	}
+	e = stripUnits(e)
+
+	if call, ok := e.(*CallExpr); ok {
+		return g.fileTable(call, units)
+	}
+
+	t, ok := e.(*TableExpr)
+	if !ok {
+		return runtime.Table{}, fmt.Errorf("points %s not a table", e)
+	}
 
 	l := len(t.Pairs)
-	tab := [2][]float64{make([]float64, l), make([]float64, l)}
+	tab := runtime.Table{
+		X: make([]float64, l),
+		Y: make([]float64, l),
+	}
 
 	for i, p := range t.Pairs {
 		x, err := constEval(p.X)
 		if err != nil {
-			return fmt.Errorf("pair %d X (%s): %s", i, p.X, err)
+			return tab, fmt.Errorf("pair %d X (%s): %s", i, p.X, err)
 		}
 		y, err := constEval(p.Y)
 		if err != nil {
-			return fmt.Errorf("pair %d Y (%s): %s", i, p.Y, err)
+			return tab, fmt.Errorf("pair %d Y (%s): %s", i, p.Y, err)
 		}
-		tab[0][i] = x
-		tab[1][i] = y
+		tab.X[i] = x
+		tab.Y[i] = y
 	}
 
-	g.curr.Tables[name] = tab
+	return tab, nil
+}
+
+func lookupMode(e Expr) (runtime.LookupMode, error) {
+	if id, ok := stripUnits(e).(*RefExpr); ok {
+		if mode, ok := lookupModes[id.Name]; ok {
+			return mode, nil
+		}
+	}
+	return runtime.LookupInterpolate, fmt.Errorf("unknown lookup mode %s", e)
+}
+
+// isGrid reports whether the composite literal e defines a
+// two-dimensional table.
+func isGrid(e *CompositeLit) bool {
+	for _, elt := range e.Elts {
+		if k, _, err := kvConvert(elt); err == nil && k == "grid" {
+			return true
+		}
+	}
+	return false
+}
+
+// constList evaluates a list literal of constants.
+func constList(e Expr) ([]float64, error) {
+	l, ok := stripUnits(e).(*ListExpr)
+	if !ok {
+		return nil, fmt.Errorf("%s isn't a list", e)
+	}
+	r := make([]float64, len(l.Elts))
+	for i, elt := range l.Elts {
+		v, err := constEval(elt)
+		if err != nil {
+			return nil, fmt.Errorf("element %d (%s): %s", i, elt, err)
+		}
+		r[i] = v
+	}
+	return r, nil
+}
+
+// table2D records the two-dimensional table defined by e.  grid has
+// a row for each x value, giving the table's value at each y value:
+//
+//	effect table = {
+//		x:      [0, 0.5, 1]
+//		y:      [0, 10]
+//		grid:   [[1, 0.5], [1.2, 0.8], [1.5, 1]]
+//		lookup: extrapolate
+//	}
+func (g *generator) table2D(name string, e *CompositeLit) error {
+	var tab runtime.Table2D
+	for _, elt := range e.Elts {
+		k, val, err := kvConvert(elt)
+		if err != nil {
+			return err
+		}
+		switch k {
+		case "x":
+			tab.X, err = constList(val)
+		case "y":
+			tab.Y, err = constList(val)
+		case "grid":
+			rows, ok := stripUnits(val).(*ListExpr)
+			if !ok {
+				return fmt.Errorf("table '%s': grid isn't a list of rows", name)
+			}
+			tab.Z = make([][]float64, len(rows.Elts))
+			for i, row := range rows.Elts {
+				if tab.Z[i], err = constList(row); err != nil {
+					err = fmt.Errorf("row %d: %s", i, err)
+					break
+				}
+			}
+		case "lookup":
+			tab.Mode, err = lookupMode(val)
+		default:
+			err = fmt.Errorf("unknown key %s", k)
+		}
+		if err != nil {
+			return fmt.Errorf("table '%s': %s", name, err)
+		}
+	}
+
+	if err := tab.Validate(); err != nil {
+		return fmt.Errorf("table '%s': %s", name, err)
+	}
+
+	g.curr.Tables2D[name] = tab
 
 	return nil
 }
 
-func (g *generator) expr(name string, expr Expr) {
+func (g *generator) expr(name string, expr Expr) error {
 	var eqn string
 	switch g.curr.Vars[name].Type {
 	case runtime.TyAux:
@@ -323,41 +752,115 @@ func (g *generator) expr(name string, expr Expr) {
 			g.initial(name, expr)
 			eqn = fmt.Sprintf(`s.Curr["%s"] = c.Data(s, "%s")`, name, name)
 			g.curr.UseCoordFlows = true
+		} else if isTableLookup(expr) || isFileCall(expr) {
+			return g.table(name, expr)
 		} else if e, ok := expr.(*CompositeLit); ok {
 			log.Printf("%s - composit lit %T (%#v)", name, e, e)
 			tyName, ok := identString(e.Type)
 			if !ok {
 				log.Printf("composit lit with unknown type")
-				return
+				return nil
 			}
-			instanceName := tmpName(tyName)
+			instanceName := g.tmpName(tyName)
 			// TODO: instantiate model instance
 			eqn = fmt.Sprintf(`s.Curr["%s"] = c.Data(s, "%s")`, name, instanceName)
 		} else {
+			expr, err := g.rewrite(expr)
+			if err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
 			eqn = fmt.Sprintf(`s.Curr["%s"] = %s`, name, expr)
 		}
 	case runtime.TyTable:
 		if err := g.table(name, expr); err != nil {
-			log.Printf("table(%s): %s", name, err)
+			return err
 		}
 	default:
 		log.Printf("%s - expr2 %T (%#v)", name, expr, expr)
-		eqn = fmt.Sprintf(`s.Curr["%s"] = %s`, name, expr)
+		expr, err := g.rewrite(expr)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		if g.curr.Vars[name].Uniflow {
+			eqn = fmt.Sprintf(`s.Curr["%s"] = runtime.Uniflow(%s)`, name, expr)
+		} else {
+			eqn = fmt.Sprintf(`s.Curr["%s"] = %s`, name, expr)
+		}
 	}
 	if len(eqn) > 0 {
 		g.curr.Equations = append(g.curr.Equations, eqn)
 	}
+	return nil
+}
+
+// method records the model's integration_method, the string rhs.
+func (g *generator) method(rhs Expr) error {
+	method, ok := stringArg(stripUnits(rhs))
+	if !ok {
+		return fmt.Errorf("integration_method is %s, not a string", rhs)
+	}
+	switch method {
+	case runtime.Euler, runtime.RK2, runtime.RK4, runtime.RK45:
+	default:
+		return fmt.Errorf("unknown integration_method %q", method)
+	}
+	g.curr.Method = method
+	return nil
+}
+
+// discrete returns an error naming what if the model is integrated
+// with a Runge-Kutta method.  what moves material in discrete steps,
+// as conveyors and queues do, so can only be stepped with Euler's.
+func (g *generator) discrete(what string) error {
+	if g.curr.Method != "" && g.curr.Method != runtime.Euler {
+		return fmt.Errorf("%s can't be integrated with integration_method %q; use %q",
+			what, g.curr.Method, runtime.Euler)
+	}
+	return nil
+}
+
+// stopWhen generates the model's stop_when condition, cond, as the
+// equation of the internal variable runtime.StopVar.  The variables
+// it refers to must be the model's.
+func (g *generator) stopWhen(cond Expr) error {
+	if lit, ok := cond.(*BasicLit); ok && lit.Kind == token.STRING {
+		return fmt.Errorf("stop_when is the string %q; write the condition without quotes", lit.Value)
+	}
+	if b, ok := cond.(*BinaryExpr); !ok || opFuncs[b.Op] == "" {
+		return fmt.Errorf("stop_when %s isn't a condition, like population < 10", source(cond))
+	}
+	for _, name := range deps(cond) {
+		if _, ok := g.curr.Vars[name]; !ok {
+			return fmt.Errorf("stop_when: unknown variable %s", name)
+		}
+	}
+	expr, err := g.rewrite(cond)
+	if err != nil {
+		return fmt.Errorf("stop_when: %s", err)
+	}
+	name := runtime.StopVar
+	g.curr.StopWhen = source(cond)
+	g.curr.Vars[name] = runtime.Var{Name: name, Type: runtime.TyAux, Internal: true,
+		Eqn: g.curr.StopWhen, Deps: deps(cond)}
+	g.curr.stop = fmt.Sprintf(`s.Curr["%s"] = %s`, name, expr)
+	return nil
 }
 
 func (g *generator) assign(s *AssignStmt) error {
+	if s.Lhs.Name.Name == "integration_method" {
+		// read by g.vars, before any stock is generated.
+		return nil
+	}
+	if s.Lhs.Name.Name == "stop_when" {
+		return g.stopWhen(stripUnits(s.Rhs))
+	}
 	if s.Lhs.Name.Name == "timespec" {
 		c, ok := s.Rhs.(*CompositeLit)
 		if !ok {
 			return fmt.Errorf("timespec is %T, not CompositeLit",
 				s.Rhs)
 		}
-		g.timespec(c.Elts)
-		return nil
+		return g.timespec(c.Elts)
 	}
 	v, ok := g.curr.Vars[s.Lhs.Name.Name]
 	if !ok {
@@ -367,8 +870,8 @@ func (g *generator) assign(s *AssignStmt) error {
 		if err := g.stock(v.Name, s.Rhs); err != nil {
 			return err
 		}
-	} else {
-		g.expr(v.Name, s.Rhs)
+	} else if err := g.expr(v.Name, s.Rhs); err != nil {
+		return err
 	}
 	return nil
 }
@@ -404,7 +907,9 @@ var (
 // doesn't have an explicit type, it figures out the implicit type
 // from rhs.
 func resolveType(d *VarDecl, rhs Expr) error {
-	if d.Type != nil {
+	// the parser gives variables declared without a type the type
+	// aux, which a bare table literal refines to table.
+	if d.Type != nil && d.Type.Name != identAux.Name {
 		// TODO: verify type matches rhs
 		return nil
 	}
@@ -413,29 +918,104 @@ func resolveType(d *VarDecl, rhs Expr) error {
 	// separate issue.
 	rhs = stripUnits(rhs)
 
-	switch r := rhs.(type) {
+	switch rhs.(type) {
 	case *TableExpr:
 		d.Type = &identTable
-	case *IndexExpr:
-		if _, ok := r.X.(*TableExpr); ok {
-			d.Type = &identTable
-		} else {
-			d.Type = &identAux
-		}
 	default:
+		// including table literals indexed by a value: the
+		// result of the lookup is an ordinary aux.
 		d.Type = &identAux
 	}
 
 	return nil
 }
 
+// isTableLookup reports whether e is a table literal indexed by the
+// value to look up.
+func isTableLookup(e Expr) bool {
+	if r, ok := stripUnits(e).(*IndexExpr); ok {
+		_, ok = r.X.(*TableExpr)
+		return ok
+	}
+	return false
+}
+
+// source returns e as it would be written in a model, for the
+// equations of variables without a statement of their own, like
+// flows written inline in a stock's initializer.  Units are left
+// out.
+func source(e Expr) string {
+	switch e := e.(type) {
+	case *BasicLit:
+		return e.Value
+	case *Ident:
+		return e.Name
+	case *RefExpr:
+		return e.Name
+	case *ParenExpr:
+		return "(" + source(e.X) + ")"
+	case *UnaryExpr:
+		return e.Op.String() + source(e.X)
+	case *BinaryExpr:
+		return source(e.X) + " " + e.Op.String() + " " + source(e.Y)
+	case *UnitExpr:
+		return source(e.X)
+	case *IndexExpr:
+		return source(e.X) + "[" + source(e.Index) + "]"
+	case *CallExpr:
+		args := make([]string, len(e.Args))
+		for i, a := range e.Args {
+			args[i] = source(a)
+		}
+		return source(e.Fun) + "(" + strings.Join(args, ", ") + ")"
+	}
+	return fmt.Sprintf("%s", e)
+}
+
+// deps returns the names of the variables and tables e refers to,
+// in order.  Intrinsics and the lookup mode of a table literal
+// aren't variables, and are left out.
+func deps(e Expr) []string {
+	seen := map[string]bool{}
+	Inspect(e, func(n Node) bool {
+		switch n := n.(type) {
+		case *KeyValueExpr:
+			if k, ok := n.Key.(*Ident); ok && k.Name == "lookup" {
+				return false
+			}
+		case *RefExpr:
+			if _, ok := intrinsics[strings.ToLower(n.Name)]; !ok {
+				seen[n.Name] = true
+			}
+		case *IndexExpr:
+			if id, ok := n.X.(*Ident); ok {
+				seen[id.Name] = true
+			}
+		case *IndexListExpr:
+			if id, ok := n.X.(*Ident); ok {
+				seen[id.Name] = true
+			}
+		}
+		return true
+	})
+	if len(seen) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(seen))
+	for n := range seen {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (g *generator) vars(stmts ...Stmt) (err error) {
 	addVar := func(vd *VarDecl) error {
 		v, err := varFromDecl(vd)
 		if err != nil {
 			return fmt.Errorf("varFromDecl(%v): %s", vd, err)
 		}
-		if v.Name != "timespec" {
+		if v.Name != "timespec" && v.Name != "integration_method" && v.Name != "stop_when" {
 			g.curr.Vars[v.Name] = v
 		}
 		return nil
@@ -444,10 +1024,39 @@ outer:
 	for i, s := range stmts {
 		switch ss := s.(type) {
 		case *AssignStmt:
+			if ss.Lhs.Name.Name == "integration_method" {
+				if err = g.method(ss.Rhs); err != nil {
+					break outer
+				}
+			}
 			if err = resolveType(ss.Lhs, ss.Rhs); err != nil {
 				break outer
 			}
 			err = addVar(ss.Lhs)
+			if v, ok := g.curr.Vars[ss.Lhs.Name.Name]; ok && err == nil {
+				v.Eqn = ss.Eqn
+				v.Deps = deps(ss.Rhs)
+				// units can also follow the equation,
+				// as in delay = 2 `minutes`, and are
+				// reported apart from it.
+				if u, ok := ss.Rhs.(*UnitExpr); ok && u.Unit != nil {
+					if v.Units == "" {
+						v.Units = unitsString(u.Unit)
+					}
+					if i := strings.LastIndex(strings.TrimSuffix(v.Eqn, "`"), "`"); i >= 0 {
+						v.Eqn = strings.TrimSpace(v.Eqn[:i])
+					}
+				}
+				g.curr.Vars[v.Name] = v
+			}
+			// a conveyor's outflows are defined by the
+			// conveyor itself, not by their own statements.
+			cl, ok := ss.Rhs.(*CompositeLit)
+			if ok && ss.Lhs.Type.Name == "conveyor" {
+				for _, n := range stockOutputs(cl) {
+					g.curr.Vars[n] = runtime.Var{Name: n, Type: runtime.TyFlow}
+				}
+			}
 		case *DeclStmt:
 			g.curr.Abstract = true
 			err = addVar(ss.Decl)
@@ -470,27 +1079,114 @@ func (g *generator) model(m *ModelDecl) error {
 		CamelName: camelName,
 		Vars:      map[string]runtime.Var{},
 		Tables:    map[string]runtime.Table{},
+		Tables2D:  map[string]runtime.Table2D{},
 		Equations: []string{},
 		Stocks:    []string{},
+		Limits:    []string{},
 		Initials:  map[string]string{},
 	}
-	g.vars(m.Body.List...)
+	if err := g.vars(m.Body.List...); err != nil {
+		return err
+	}
 	for _, s := range m.Body.List {
 		if err := g.stmt(s); err != nil {
 			return err
 		}
 	}
+	// stop_when is of the values a step saves, so it is
+	// computed once they all are.
+	if g.curr.stop != "" {
+		g.curr.Stocks = append(g.curr.Stocks, g.curr.stop)
+	}
+	g.curr.assignSlots()
 	g.Models[m.Name.Name] = g.curr
 	g.curr = nil
 
 	return nil
 }
 
+// Attrs returns the model attributes of gm, or nil if it has none.
+func (gm *genModel) Attrs() map[string]interface{} {
+	attrs := map[string]interface{}{}
+	if gm.Stochastic {
+		attrs["stochastic"] = true
+	}
+	if gm.Method != "" {
+		attrs["integration_method"] = gm.Method
+	}
+	if gm.StopWhen != "" {
+		attrs["stop_when"] = gm.StopWhen
+	}
+	if len(attrs) == 0 {
+		return nil
+	}
+	return attrs
+}
+
+// stateRef matches references to sim state by variable name in
+// generated code, which the template turns into references by slot.
+var stateRef = regexp.MustCompile(`s\.(Curr|Next)\["([^"]*)"\]|s\.(LimitOutflows|LimitInflows)\("([^"]*)"`)
+
+// assignSlots gives a slot to each of gm's variables, and to any
+// other name its equations refer to.  time is always slot 0, then
+// come the variables in name order, then the rest in order of use.
+func (gm *genModel) assignSlots() {
+	gm.Slots = map[string]int{"time": 0}
+	add := func(name string) {
+		if _, ok := gm.Slots[name]; !ok {
+			gm.Slots[name] = len(gm.Slots)
+		}
+	}
+
+	names := make([]string, 0, len(gm.Vars))
+	for n := range gm.Vars {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		add(n)
+	}
+
+	names = names[:0]
+	for n := range gm.Initials {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var code []string
+	for _, n := range names {
+		code = append(code, gm.Initials[n])
+	}
+	for _, list := range [][]string{gm.InitialStates, gm.StateFlows, gm.Equations,
+		gm.StockFlows, gm.Limits, gm.Stocks} {
+		code = append(code, list...)
+	}
+	for _, c := range code {
+		for _, m := range stateRef.FindAllStringSubmatch(c, -1) {
+			add(m[2] + m[4])
+		}
+	}
+}
+
+// Slotted rewrites references to sim state by name in the generated
+// code c to refer to slots.
+func (gm *genModel) Slotted(c string) string {
+	return stateRef.ReplaceAllStringFunc(c, func(ref string) string {
+		m := stateRef.FindStringSubmatch(ref)
+		if m[1] != "" {
+			return fmt.Sprintf("s.%s[%d]", m[1], gm.Slots[m[2]])
+		}
+		return fmt.Sprintf("s.%s(%d", m[3], gm.Slots[m[4]])
+	})
+}
+
 func tmplSimple(eqn string) bool {
 	return !strings.HasPrefix(eqn, `s.Curr["`)
 }
 
-func (g *generator) file(f *File) ([]byte, error) {
+// models fills in g.Models from the model declarations in f.
+func (g *generator) models(f *File) error {
+	g.dir = filepath.Dir(f.Filename)
+
 	for _, d := range f.Decls {
 		md, ok := d.(*ModelDecl)
 		if !ok {
@@ -498,9 +1194,16 @@ func (g *generator) file(f *File) ([]byte, error) {
 			continue
 		}
 		if err := g.model(md); err != nil {
-			return nil, fmt.Errorf("g.model: %s", err)
+			return fmt.Errorf("g.model: %s", err)
 		}
 	}
+	return nil
+}
+
+func (g *generator) file(f *File) ([]byte, error) {
+	if err := g.models(f); err != nil {
+		return nil, err
+	}
 
 	var buf bytes.Buffer
 	tmpl := template.New("model.go")
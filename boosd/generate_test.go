@@ -0,0 +1,356 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package boosd
+
+import (
+	"bytes"
+	"github.com/bpowers/boosd/runtime"
+	"go/format"
+	"go/token"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// compiled builds the program GenGo generates for the model source
+// src and runs it with args, returning its output by column, with
+// every digit of each value.
+func compiled(t testing.TB, src string, args ...string) map[string][]float64 {
+	dir := build(t, src)
+	defer os.RemoveAll(dir)
+
+	args = append([]string{"run", "./" + dir, "-precision", "-1"}, args...)
+	out, err := exec.Command("go", args...).Output()
+	if err != nil {
+		if e, ok := err.(*exec.ExitError); ok {
+			t.Fatalf("go run: %s\n%s", err, e.Stderr)
+		}
+		t.Fatalf("go run: %s", err)
+	}
+	return columns(t, "compiled model", string(out))
+}
+
+// build writes the program GenGo generates for src to a new
+// directory in testdata, so that it is built with this copy of the
+// runtime, and returns the directory.  Tests calling it are skipped
+// in short mode, or without a go command to build with.
+func build(t testing.TB, src string) string {
+	if testing.Short() {
+		t.Skip("building compiled models in short mode")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("no go command to build compiled models with")
+	}
+	fset := token.NewFileSet()
+	f, err := Parse(fset.AddFile("", fset.Base(), len(src)), src)
+	if err != nil {
+		t.Fatalf("Parse: %s", err)
+	}
+	if f.NErrors > 0 {
+		t.Fatalf("%d parse errors", f.NErrors)
+	}
+	goFile, err := GenGo(f)
+	if err != nil {
+		t.Fatalf("GenGo: %s", err)
+	}
+	var buf bytes.Buffer
+	if err := format.Node(&buf, token.NewFileSet(), goFile); err != nil {
+		t.Fatalf("format: %s", err)
+	}
+	dir, err := ioutil.TempDir("testdata", "compiled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.go"), buf.Bytes(), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return dir
+}
+
+const multiFlowModel = `
+main model {
+        timespec = {
+                start:     0
+                end:       3
+                dt:        1
+                save_step: 1
+        }
+        births flow = 3
+        immigration flow = 2
+        deaths flow = 1
+        emigration flow = .5
+        population stock = {
+                initial: 10
+                inflow: [births, immigration]
+                outflow: [deaths, emigration]
+        }
+}
+`
+
+func TestMultipleFlows(t *testing.T) {
+	for name, m := range backends(t, multiFlowModel) {
+		v, _ := m.Var("population")
+		if want := []string{"births", "immigration"}; !reflect.DeepEqual(v.Inflows, want) {
+			t.Errorf("%s: inflows %v, want %v", name, v.Inflows, want)
+		}
+		if want := []string{"deaths", "emigration"}; !reflect.DeepEqual(v.Outflows, want) {
+			t.Errorf("%s: outflows %v, want %v", name, v.Outflows, want)
+		}
+
+		s := run(t, m, nil)
+		got := series(t, s, "population")
+		want := []float64{10, 13.5, 17, 20.5}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: population = %v, want %v", name, got, want)
+		}
+	}
+}
+
+const nonNegativeModel = `
+main model {
+        timespec = {
+                start:     0
+                end:       4
+                dt:        1
+                save_step: 1
+        }
+        demand uniflow = 30 - time * 10
+        sales flow = 60
+        restock flow = 5
+        inventory stock = {
+                initial: 100
+                inflow: [restock, demand]
+                outflow: sales
+                non_negative: 1
+        }
+}
+`
+
+func TestNonNegative(t *testing.T) {
+	for name, m := range backends(t, nonNegativeModel) {
+		v, _ := m.Var("inventory")
+		if !v.NonNegative {
+			t.Errorf("%s: inventory isn't non-negative", name)
+		}
+		if v, _ := m.Var("demand"); !v.Uniflow {
+			t.Errorf("%s: demand isn't a uniflow", name)
+		}
+
+		s := run(t, m, nil)
+		for _, c := range []struct {
+			name string
+			want []float64
+		}{
+			// demand is clamped at zero from time 3
+			{"demand", []float64{30, 20, 10, 0, 0}},
+			{"inventory", []float64{100, 75, 40, 0, 0}},
+			// sales are limited to what is in stock
+			{"sales", []float64{60, 60, 55, 5, 5}},
+		} {
+			if got := series(t, s, c.name); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("%s: %s = %v, want %v", name, c.name, got, c.want)
+			}
+		}
+	}
+}
+
+const conveyorModel = `
+main model {
+        timespec = {
+                start:     0
+                end:       8
+                dt:        1
+                save_step: 1
+        }
+        starts flow = 10
+        shipments flow = 4
+        wip conveyor = {
+                initial: 40
+                inflow: starts
+                outflow: completions
+                transit_time: 4
+                leak_fraction: .1
+                leakage: scrap
+        }
+        backlog queue = {
+                inflow: completions
+                outflow: shipments
+                capacity: 20
+        }
+}
+`
+
+func TestConveyorAndQueue(t *testing.T) {
+	for name, m := range backends(t, conveyorModel) {
+		if v, _ := m.Var("wip"); v.Flavor != runtime.StockConveyor {
+			t.Errorf("%s: wip is a %s", name, v.Flavor.Name())
+		}
+		if v, _ := m.Var("backlog"); v.Flavor != runtime.StockQueue || v.Capacity != 20 {
+			t.Errorf("%s: backlog is a %s of capacity %g", name, v.Flavor.Name(), v.Capacity)
+		}
+
+		s := run(t, m, nil)
+		wip, backlog := series(t, s, "wip"), series(t, s, "backlog")
+		starts, completions := series(t, s, "starts"), series(t, s, "completions")
+		scrap, shipments := series(t, s, "scrap"), series(t, s, "shipments")
+		for i := 1; i < len(wip); i++ {
+			want := wip[i-1] + starts[i-1] - completions[i-1] - scrap[i-1]
+			if math.Abs(wip[i]-want) > 1e-9 {
+				t.Errorf("%s: wip[%d] = %g, want %g", name, i, wip[i], want)
+			}
+			want = backlog[i-1] + completions[i-1] - shipments[i-1]
+			if math.Abs(backlog[i]-want) > 1e-9 {
+				t.Errorf("%s: backlog[%d] = %g, want %g", name, i, backlog[i], want)
+			}
+			if backlog[i] > 20+1e-9 {
+				t.Errorf("%s: backlog[%d] = %g, over its capacity", name, i, backlog[i])
+			}
+		}
+		// the belt's initial contents come off first
+		if completions[0] != 10 {
+			t.Errorf("%s: completions[0] = %g, want 10", name, completions[0])
+		}
+	}
+}
+
+// TestDiscreteMethods checks that models with conveyors, queues or
+// delay_fixed fail to compile with Runge-Kutta methods, naming
+// what can't be integrated, wherever integration_method is.
+func TestDiscreteMethods(t *testing.T) {
+	for _, c := range []struct {
+		src, want string
+	}{
+		{strings.Replace(conveyorModel, "starts flow", "integration_method = \"rk4\"\n        starts flow", 1),
+			`conveyor stock wip can't be integrated with integration_method "rk4"`},
+		{eqnModel(3, `integration_method = "rk2"`, "arrivals flow = 2", "served flow = 1",
+			"line queue = {", "        inflow: arrivals", "        outflow: served", "}"),
+			`queue stock line can't be integrated with integration_method "rk2"`},
+		{eqnModel(3, "d = delay_fixed(time, 2)", `integration_method = "rk45"`),
+			`d: delay_fixed can't be integrated with integration_method "rk45"`},
+	} {
+		if _, err := Load(c.src); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("Load: %v, want %q", err, c.want)
+		}
+		if _, err := LoadVM(c.src); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("LoadVM: %v, want %q", err, c.want)
+		}
+	}
+	if _, err := Load(eqnModel(3, "d = delay_fixed(time, 2)", `integration_method = "euler"`)); err != nil {
+		t.Errorf("Load with euler: %s", err)
+	}
+}
+
+func TestTableDecls(t *testing.T) {
+	src := eqnModel(3,
+		"clamped = [(0, 0), (2, 10)][time]",
+		"extended table = {",
+		"        points: [(0, 0), (2, 10)]",
+		"        lookup: extrapolate",
+		"}",
+		"stepped table = {",
+		"        points: [(0, 0), (1, 10), (2, 20)]",
+		"        lookup: discrete",
+		"}",
+		"a = extended[time]",
+		"b = stepped[time * .75]")
+	for name, m := range backends(t, src) {
+		s := run(t, m, nil)
+		for v, want := range map[string][]float64{
+			"clamped": {0, 5, 10, 10},
+			"a":       {0, 5, 10, 15},
+			"b":       {0, 0, 10, 20},
+		} {
+			if got := series(t, s, v); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %s = %v, want %v", name, v, got, want)
+			}
+		}
+		// neither the lookup mode nor time is a dependency
+		for v, want := range map[string][]string{
+			"extended": nil,
+			"a":        {"extended"},
+		} {
+			if got, _ := m.VarInfo(v)["deps"].([]string); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: VarInfo(%s)[deps] = %#v, want %#v", name, v, got, want)
+			}
+		}
+	}
+
+	for _, eqn := range []string{
+		"bad = [(0, 1)][time]",
+		"bad = [(0, 1), (0, 2)][time]",
+		"bad = [(1, 1), (0, 2)][time]",
+		"bad table = {\n points: [(0, 1), (1, 2)]\n lookup: cubic\n}",
+	} {
+		if _, err := Load(eqnModel(3, eqn)); err == nil {
+			t.Errorf("Load(%s) succeeded", eqn)
+		}
+	}
+}
+
+func TestTable2D(t *testing.T) {
+	decl := func(mode string) []string {
+		return []string{
+			"effect table = {",
+			"        x:      [0, 1]",
+			"        y:      [0, 10]",
+			"        grid:   [[0, 10], [20, 40]]",
+			"        lookup: " + mode,
+			"}",
+			"e = effect[time / 2, time * 5]",
+		}
+	}
+	for mode, want := range map[string][]float64{
+		"interpolate": {0, 17.5, 40, 40},
+		"extrapolate": {0, 17.5, 40, 67.5},
+	} {
+		for name, m := range backends(t, eqnModel(3, decl(mode)...)) {
+			if got := series(t, run(t, m, nil), "e"); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %s: e = %v, want %v", name, mode, got, want)
+			}
+		}
+	}
+
+	for _, grid := range []string{"[[0, 10], [20]]", "[[0, 10]]", "[0, 10]"} {
+		src := eqnModel(3, "effect table = {", "x: [0, 1]", "y: [0, 10]", "grid: "+grid, "}")
+		if _, err := Load(src); err == nil {
+			t.Errorf("Load with grid %s succeeded", grid)
+		}
+	}
+}
+
+func TestSlots(t *testing.T) {
+	gm, err := loadMain(eqnModel(3, "b = 1", "a = b * 2", "c = a + b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// time first, then the variables in name order
+	for i, n := range []string{"time", "a", "b", "c"} {
+		if gm.Slots[n] != i {
+			t.Errorf("%s in slot %d, want %d", n, gm.Slots[n], i)
+		}
+	}
+	for n, i := range gm.Slots {
+		if i < 0 || i >= len(gm.Slots) {
+			t.Errorf("%s in slot %d of %d", n, i, len(gm.Slots))
+		}
+	}
+
+	for _, c := range []struct{ in, want string }{
+		{`s.Curr["a"] = s.Curr["b"] * 2`, `s.Curr[1] = s.Curr[2] * 2`},
+		{`s.Next["b"] = s.Next["time"]`, `s.Next[2] = s.Next[0]`},
+		{`s.LimitOutflows("c", dt)`, `s.LimitOutflows(3, dt)`},
+		{`c.Data(s, "b")`, `c.Data(s, "b")`},
+	} {
+		if got := gm.Slotted(c.in); got != c.want {
+			t.Errorf("Slotted(%s) = %s, want %s", c.in, got, c.want)
+		}
+	}
+}
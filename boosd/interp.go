@@ -0,0 +1,605 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package boosd
+
+import (
+	"fmt"
+	"github.com/bpowers/boosd/runtime"
+	"github.com/bpowers/boosd/vm"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The interpreter runs models in-process, without generating,
+// compiling and linking a Go program.  It evaluates the Go
+// statements GenGo generates for a model's equations, in the same
+// order and against the same runtime: each is parsed with go/parser
+// when the model is loaded and turned into a closure over the sim,
+// so only the subset of Go that the generator writes is supported.
+// Every number is a float64, as the generator writes literals as
+// floating-point constants.  Results match compiled models', except
+// that the Go compiler folds constant subexpressions, like 1/3 * 3,
+// with exact arithmetic, where the interpreter rounds each step.
+
+// Load parses and checks the model source src, and returns its main
+// model.  Data files referenced by the model are found relative to
+// the current directory.
+func Load(src string) (runtime.Model, error) {
+	gm, err := loadMain(src)
+	if err != nil {
+		return nil, err
+	}
+	return newInterpModel(gm)
+}
+
+// loadMain parses src and generates code for its models, returning
+// the main model.
+func loadMain(src string) (*genModel, error) {
+	fset := token.NewFileSet()
+	f, err := Parse(fset.AddFile("", fset.Base(), len(src)), src)
+	if err != nil {
+		return nil, err
+	}
+	if f.NErrors > 0 {
+		return nil, fmt.Errorf("%d parse errors", f.NErrors)
+	}
+
+	g := &generator{
+		Models: map[string]*genModel{},
+	}
+	if err := g.models(f); err != nil {
+		return nil, err
+	}
+
+	gm, ok := g.Models["main"]
+	if !ok {
+		return nil, fmt.Errorf("no main model")
+	}
+	if err := gm.Time.Check(); err != nil {
+		return nil, fmt.Errorf("main: %s", err)
+	}
+	return gm, nil
+}
+
+// calcs returns the statements of the calcInitial, calcFlows and
+// calcStocks functions that the template generates for gm, along
+// with gm's default constants.
+func (gm *genModel) calcs() (initial, flows, stocks []string, defaults runtime.DefaultMap, err error) {
+	defaults = runtime.DefaultMap{}
+
+	// initials in name order, as the template ranges over them,
+	// with constants supplied by the coordinator.
+	names := make([]string, 0, len(gm.Initials))
+	for n := range gm.Initials {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		eqn := gm.Initials[n]
+		if tmplSimple(eqn) {
+			v, err := strconv.ParseFloat(eqn, 64)
+			if err != nil {
+				return nil, nil, nil, nil, fmt.Errorf("default %s (%s): %s", n, eqn, err)
+			}
+			defaults[n] = v
+			eqn = fmt.Sprintf(`c.Data(s, "%s")`, n)
+		}
+		initial = append(initial, fmt.Sprintf(`s.Curr["%s"] = %s`, n, eqn))
+	}
+	initial = append(initial, gm.InitialStates...)
+
+	flows = append(append(flows, gm.StateFlows...), gm.Equations...)
+	stocks = append(append(append(stocks, gm.StockFlows...), gm.Limits...), gm.Stocks...)
+
+	return initial, flows, stocks, defaults, nil
+}
+
+type interpSim struct {
+	runtime.BaseSim
+}
+
+type interpModel struct {
+	runtime.BaseModel
+	time runtime.Timespec
+
+	calcInitial []stmtFn
+	calcFlows   []stmtFn
+	calcStocks  []stmtFn
+}
+
+// an evalFn computes the value of an expression for the sim s.
+type evalFn func(s *interpSim, dt float64) float64
+
+// a stmtFn executes a statement for the sim s.
+type stmtFn func(s *interpSim, dt float64)
+
+// a callFn calls a function or method, returning its results.
+type callFn func(s *interpSim, dt float64) []reflect.Value
+
+// interpFuncs are the functions from the runtime package that
+// generated code calls.
+var interpFuncs = map[string]interface{}{
+	"Abs":         runtime.Abs,
+	"Exp":         runtime.Exp,
+	"Ln":          runtime.Ln,
+	"Sqrt":        runtime.Sqrt,
+	"Min":         runtime.Min,
+	"Max":         runtime.Max,
+	"Uniflow":     runtime.Uniflow,
+	"Less":        runtime.Less,
+	"LessEq":      runtime.LessEq,
+	"Greater":     runtime.Greater,
+	"GreaterEq":   runtime.GreaterEq,
+	"Equal":       runtime.Equal,
+	"NotEqual":    runtime.NotEqual,
+	"And":         runtime.And,
+	"Or":          runtime.Or,
+	"NewConveyor": runtime.NewConveyor,
+	"NewQueue":    runtime.NewQueue,
+}
+
+var (
+	simType   = reflect.TypeOf((*interpSim)(nil))
+	coordType = reflect.TypeOf((*runtime.Coordinator)(nil)).Elem()
+	float64Ty = reflect.TypeOf(float64(0))
+)
+
+func newInterpModel(gm *genModel) (*interpModel, error) {
+	m := &interpModel{
+		BaseModel: runtime.BaseModel{
+			MName:    gm.Name,
+			Vars:     gm.Vars,
+			Tables:   gm.Tables,
+			Tables2D: gm.Tables2D,
+			Slots:    gm.Slots,
+		},
+		time: gm.Time,
+	}
+	m.Attrs = gm.Attrs()
+
+	initial, flows, stocks, defaults, err := gm.calcs()
+	if err != nil {
+		return nil, err
+	}
+	m.Defaults = defaults
+
+	if m.calcInitial, err = m.compile(initial); err != nil {
+		return nil, fmt.Errorf("calcInitial: %s", err)
+	}
+	if m.calcFlows, err = m.compile(flows); err != nil {
+		return nil, fmt.Errorf("calcFlows: %s", err)
+	}
+	if m.calcStocks, err = m.compile(stocks); err != nil {
+		return nil, fmt.Errorf("calcStocks: %s", err)
+	}
+
+	return m, nil
+}
+
+func (m *interpModel) NewSim(name string, c runtime.Coordinator, opts *runtime.Options) runtime.Sim {
+	s := new(interpSim)
+	s.InstanceName = name
+	s.Parent = m
+	s.Coord = c
+
+	s.Init(m, &m.BaseModel, m.time, opts)
+
+	s.CalcInitial = s.run(m.calcInitial)
+	s.CalcFlows = s.run(m.calcFlows)
+	s.CalcStocks = s.run(m.calcStocks)
+
+	return s
+}
+
+func (s *interpSim) run(stmts []stmtFn) func(dt float64) {
+	return func(dt float64) {
+		for _, stmt := range stmts {
+			stmt(s, dt)
+		}
+	}
+}
+
+// compile parses a list of generated Go statements.
+func (m *interpModel) compile(code []string) ([]stmtFn, error) {
+	src := "package p\nfunc f() {\n" + strings.Join(code, "\n") + "\n}\n"
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	if err != nil {
+		return nil, err
+	}
+	body := f.Decls[0].(*ast.FuncDecl).Body
+
+	return m.stmts(body.List)
+}
+
+func (m *interpModel) stmts(list []ast.Stmt) ([]stmtFn, error) {
+	result := make([]stmtFn, 0, len(list))
+	for _, st := range list {
+		fn, err := m.stmt(st)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, fn)
+	}
+	return result, nil
+}
+
+func (m *interpModel) stmt(st ast.Stmt) (stmtFn, error) {
+	switch st := st.(type) {
+	case *ast.AssignStmt:
+		if st.Tok != token.ASSIGN || len(st.Lhs) != 1 || len(st.Rhs) != 1 {
+			return nil, fmt.Errorf("unsupported assignment")
+		}
+		field, key, err := vm.SimIndex(st.Lhs[0])
+		if err != nil {
+			return nil, err
+		}
+		switch field {
+		case "Curr", "Next":
+			i, err := m.slot(key)
+			if err != nil {
+				return nil, err
+			}
+			rhs, err := m.expr(st.Rhs[0])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", key, err)
+			}
+			if field == "Curr" {
+				return func(s *interpSim, dt float64) {
+					s.Curr[i] = rhs(s, dt)
+				}, nil
+			}
+			return func(s *interpSim, dt float64) {
+				s.Next[i] = rhs(s, dt)
+			}, nil
+		case "Conveyors", "Queues":
+			call, ok := st.Rhs[0].(*ast.CallExpr)
+			if !ok {
+				return nil, fmt.Errorf("%s: state not created by a call", key)
+			}
+			fn, ft, err := m.call(call)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", key, err)
+			}
+			if ft.NumOut() != 1 {
+				return nil, fmt.Errorf("%s: %s doesn't return a value", key, vm.Selector(call))
+			}
+			if field == "Conveyors" {
+				return func(s *interpSim, dt float64) {
+					s.Conveyors[key] = fn(s, dt)[0].Interface().(*runtime.Conveyor)
+				}, nil
+			}
+			return func(s *interpSim, dt float64) {
+				s.Queues[key] = fn(s, dt)[0].Interface().(*runtime.Queue)
+			}, nil
+		}
+		return nil, fmt.Errorf("assignment to unsupported s.%s", field)
+	case *ast.ExprStmt:
+		call, ok := st.X.(*ast.CallExpr)
+		if !ok {
+			return nil, fmt.Errorf("unsupported expression statement")
+		}
+		fn, _, err := m.call(call)
+		if err != nil {
+			return nil, err
+		}
+		return func(s *interpSim, dt float64) {
+			fn(s, dt)
+		}, nil
+	case *ast.IfStmt:
+		if st.Init != nil || st.Else != nil || vm.Selector(st.Cond) != "s.Initializing" {
+			return nil, fmt.Errorf("unsupported if statement")
+		}
+		body, err := m.stmts(st.Body.List)
+		if err != nil {
+			return nil, err
+		}
+		return func(s *interpSim, dt float64) {
+			if s.Initializing {
+				for _, stmt := range body {
+					stmt(s, dt)
+				}
+			}
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported statement %T", st)
+}
+
+func (m *interpModel) expr(e ast.Expr) (evalFn, error) {
+	switch e := e.(type) {
+	case *ast.BasicLit:
+		if e.Kind != token.INT && e.Kind != token.FLOAT {
+			return nil, fmt.Errorf("non-numeric literal %s", e.Value)
+		}
+		v, err := strconv.ParseFloat(e.Value, 64)
+		if err != nil {
+			return nil, err
+		}
+		return func(*interpSim, float64) float64 { return v }, nil
+	case *ast.Ident:
+		if e.Name != "dt" {
+			return nil, fmt.Errorf("unknown identifier %s", e.Name)
+		}
+		return func(_ *interpSim, dt float64) float64 { return dt }, nil
+	case *ast.ParenExpr:
+		return m.expr(e.X)
+	case *ast.UnaryExpr:
+		x, err := m.expr(e.X)
+		if err != nil {
+			return nil, err
+		}
+		switch e.Op {
+		case token.ADD:
+			return x, nil
+		case token.SUB:
+			return func(s *interpSim, dt float64) float64 { return -x(s, dt) }, nil
+		}
+		return nil, fmt.Errorf("unsupported unary operator %s", e.Op)
+	case *ast.BinaryExpr:
+		x, err := m.expr(e.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := m.expr(e.Y)
+		if err != nil {
+			return nil, err
+		}
+		switch e.Op {
+		case token.ADD:
+			return func(s *interpSim, dt float64) float64 { return x(s, dt) + y(s, dt) }, nil
+		case token.SUB:
+			return func(s *interpSim, dt float64) float64 { return x(s, dt) - y(s, dt) }, nil
+		case token.MUL:
+			return func(s *interpSim, dt float64) float64 { return x(s, dt) * y(s, dt) }, nil
+		case token.QUO:
+			return func(s *interpSim, dt float64) float64 { return x(s, dt) / y(s, dt) }, nil
+		}
+		return nil, fmt.Errorf("unsupported binary operator %s", e.Op)
+	case *ast.IndexExpr:
+		field, key, err := vm.SimIndex(e)
+		if err != nil {
+			return nil, err
+		}
+		switch field {
+		case "Curr", "Next":
+			i, err := m.slot(key)
+			if err != nil {
+				return nil, err
+			}
+			if field == "Curr" {
+				return func(s *interpSim, _ float64) float64 { return s.Curr[i] }, nil
+			}
+			return func(s *interpSim, _ float64) float64 { return s.Next[i] }, nil
+		}
+		return nil, fmt.Errorf("unsupported index of s.%s", field)
+	case *ast.SelectorExpr:
+		switch vm.Selector(e) {
+		case "s.Time.Start":
+			return func(s *interpSim, _ float64) float64 { return s.Time.Start }, nil
+		case "s.Time.End":
+			return func(s *interpSim, _ float64) float64 { return s.Time.End }, nil
+		case "s.Time.DT":
+			return func(s *interpSim, _ float64) float64 { return s.Time.DT }, nil
+		case "s.Time.SaveStep":
+			return func(s *interpSim, _ float64) float64 { return s.Time.SaveStep }, nil
+		}
+		return nil, fmt.Errorf("unsupported selector %s", vm.Selector(e))
+	case *ast.CallExpr:
+		fn, ft, err := m.call(e)
+		if err != nil {
+			return nil, err
+		}
+		if ft.NumOut() != 1 || ft.Out(0) != float64Ty {
+			return nil, fmt.Errorf("%s doesn't return a float64", vm.Selector(e.Fun))
+		}
+		return func(s *interpSim, dt float64) float64 {
+			return fn(s, dt)[0].Float()
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported expression %T", e)
+}
+
+// call compiles a call of a runtime function, or of a method on the
+// sim, its coordinator, or one of its tables, conveyors or queues.
+// It returns the type of the function called, without any receiver.
+func (m *interpModel) call(call *ast.CallExpr) (callFn, reflect.Type, error) {
+	fun, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported call of %T", call.Fun)
+	}
+	name := fun.Sel.Name
+
+	// bound returns the function or method value to call.
+	var bound func(s *interpSim) reflect.Value
+	var ft reflect.Type
+
+	switch x := fun.X.(type) {
+	case *ast.Ident:
+		switch x.Name {
+		case "runtime":
+			f, ok := interpFuncs[name]
+			if !ok {
+				return nil, nil, fmt.Errorf("unknown function runtime.%s", name)
+			}
+			fv := reflect.ValueOf(f)
+			bound = func(*interpSim) reflect.Value { return fv }
+			ft = fv.Type()
+		case "s":
+			mt, ok := simType.MethodByName(name)
+			if !ok {
+				return nil, nil, fmt.Errorf("unknown method s.%s", name)
+			}
+			bound = func(s *interpSim) reflect.Value {
+				return reflect.ValueOf(s).Method(mt.Index)
+			}
+			ft = withoutReceiver(mt.Type)
+		case "c":
+			mt, ok := coordType.MethodByName(name)
+			if !ok {
+				return nil, nil, fmt.Errorf("unknown method c.%s", name)
+			}
+			bound = func(s *interpSim) reflect.Value {
+				return reflect.ValueOf(&s.Coord).Elem().Method(mt.Index)
+			}
+			ft = mt.Type
+		default:
+			return nil, nil, fmt.Errorf("unknown identifier %s", x.Name)
+		}
+	case *ast.IndexExpr:
+		field, key, err := vm.SimIndex(x)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch field {
+		case "Tables":
+			if _, ok := m.Tables[key]; !ok {
+				return nil, nil, fmt.Errorf("unknown table %s", key)
+			}
+		case "Tables2D":
+			if _, ok := m.Tables2D[key]; !ok {
+				return nil, nil, fmt.Errorf("unknown table %s", key)
+			}
+		case "Conveyors", "Queues":
+			// created by calcInitial
+		default:
+			return nil, nil, fmt.Errorf("unsupported call on s.%s", field)
+		}
+		sf, _ := simType.Elem().FieldByName("BaseSim")
+		mf, _ := sf.Type.FieldByName(field)
+		mt, ok := mf.Type.Elem().MethodByName(name)
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown method %s", vm.Selector(fun))
+		}
+		keyv := reflect.ValueOf(key)
+		bound = func(s *interpSim) reflect.Value {
+			v := reflect.ValueOf(&s.BaseSim).Elem().FieldByIndex(mf.Index)
+			return v.MapIndex(keyv).Method(mt.Index)
+		}
+		ft = withoutReceiver(mt.Type)
+	default:
+		return nil, nil, fmt.Errorf("unsupported call of %s", vm.Selector(fun))
+	}
+
+	if ft.IsVariadic() || ft.NumIn() != len(call.Args) {
+		return nil, nil, fmt.Errorf("%s takes %d arguments, not %d",
+			vm.Selector(fun), ft.NumIn(), len(call.Args))
+	}
+
+	args := make([]func(s *interpSim, dt float64) reflect.Value, len(call.Args))
+	for i, a := range call.Args {
+		switch in := ft.In(i); {
+		case in == float64Ty:
+			x, err := m.expr(a)
+			if err != nil {
+				return nil, nil, err
+			}
+			args[i] = func(s *interpSim, dt float64) reflect.Value {
+				return reflect.ValueOf(x(s, dt))
+			}
+		case in.Kind() == reflect.String:
+			lit, ok := a.(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return nil, nil, fmt.Errorf("%s argument %d not a string", vm.Selector(fun), i+1)
+			}
+			str, err := strconv.Unquote(lit.Value)
+			if err != nil {
+				return nil, nil, err
+			}
+			v := reflect.ValueOf(str)
+			args[i] = func(*interpSim, float64) reflect.Value { return v }
+		case in.Kind() == reflect.Int:
+			// the generated code names the stock whose slot
+			// LimitOutflows and LimitInflows take.
+			lit, ok := a.(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return nil, nil, fmt.Errorf("%s argument %d not a variable name", vm.Selector(fun), i+1)
+			}
+			str, err := strconv.Unquote(lit.Value)
+			if err != nil {
+				return nil, nil, err
+			}
+			slot, err := m.slot(str)
+			if err != nil {
+				return nil, nil, err
+			}
+			v := reflect.ValueOf(slot)
+			args[i] = func(*interpSim, float64) reflect.Value { return v }
+		case simType.Implements(in):
+			if id, ok := a.(*ast.Ident); !ok || id.Name != "s" {
+				return nil, nil, fmt.Errorf("%s argument %d not the sim", vm.Selector(fun), i+1)
+			}
+			args[i] = func(s *interpSim, _ float64) reflect.Value {
+				return reflect.ValueOf(s)
+			}
+		default:
+			return nil, nil, fmt.Errorf("%s argument %d has unsupported type %s",
+				vm.Selector(fun), i+1, in)
+		}
+	}
+
+	return func(s *interpSim, dt float64) []reflect.Value {
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			in[i] = arg(s, dt)
+		}
+		return bound(s).Call(in)
+	}, ft, nil
+}
+
+// slot returns the index of the variable name in a sim's Data.
+func (m *interpModel) slot(name string) (int, error) {
+	i, ok := m.Slots[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown var %s", name)
+	}
+	return i, nil
+}
+
+// withoutReceiver returns the type of a method value, given the type
+// of a method expression.
+func withoutReceiver(t reflect.Type) reflect.Type {
+	in := make([]reflect.Type, t.NumIn()-1)
+	for i := range in {
+		in[i] = t.In(i + 1)
+	}
+	out := make([]reflect.Type, t.NumOut())
+	for i := range out {
+		out[i] = t.Out(i)
+	}
+	return reflect.FuncOf(in, out, t.IsVariadic())
+}
+
+// LoadVM parses and checks the model source src, and compiles its
+// main model to a vm.Program.  Compiling once and creating a sim
+// per run is much faster than Load when a model is run many times.
+func LoadVM(src string) (*vm.Program, error) {
+	gm, err := loadMain(src)
+	if err != nil {
+		return nil, err
+	}
+	initial, flows, stocks, defaults, err := gm.calcs()
+	if err != nil {
+		return nil, err
+	}
+
+	d := &vm.ModelDesc{
+		Name:     gm.Name,
+		Time:     gm.Time,
+		Vars:     gm.Vars,
+		Defaults: defaults,
+		Tables:   gm.Tables,
+		Tables2D: gm.Tables2D,
+		Initial:  initial,
+		Flows:    flows,
+		Stocks:   stocks,
+		Attrs:    gm.Attrs(),
+	}
+	return vm.Compile(d)
+}
@@ -0,0 +1,712 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package boosd
+
+import (
+	"bytes"
+	"github.com/bpowers/boosd/runtime"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// backends loads the model source src with each of Load and
+// LoadVM, by name.
+func backends(t testing.TB, src string) map[string]runtime.Model {
+	m, err := Load(src)
+	if err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+	p, err := LoadVM(src)
+	if err != nil {
+		t.Fatalf("LoadVM: %s", err)
+	}
+	return map[string]runtime.Model{"interp": m, "vm": p}
+}
+
+// coord supplies constants to the sims of every test.
+var coord = runtime.NewCoordinator()
+
+// run runs a new sim of m to the end.
+func run(t testing.TB, m runtime.Model, opts *runtime.Options) runtime.Sim {
+	s := m.NewSim("main", coord, opts)
+	if err := s.RunToEnd(); err != nil {
+		t.Fatalf("RunToEnd: %s", err)
+	}
+	return s
+}
+
+// series returns the saved values of the variable name of s.
+func series(t testing.TB, s runtime.Sim, name string) []float64 {
+	r, err := s.ValueSeries(name)
+	if err != nil {
+		t.Fatalf("ValueSeries(%s): %s", name, err)
+	}
+	return r[1]
+}
+
+func readModel(t testing.TB, path string) string {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(src)
+}
+
+// readGolden reads the tab-separated output of a compiled model,
+// returning each column by its unqualified name.
+func readGolden(t testing.TB, path string) map[string][]float64 {
+	return columns(t, path, readModel(t, path))
+}
+
+// columns parses the tab-separated output out of a compiled model,
+// from where, returning each column by its unqualified name.  The
+// header's # lines are skipped.
+func columns(t testing.TB, where, out string) map[string][]float64 {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	for len(lines) > 0 && strings.HasPrefix(lines[0], "#") {
+		lines = lines[1:]
+	}
+	names := strings.Split(lines[0], "\t")
+	cols := map[string][]float64{}
+	for _, l := range lines[1:] {
+		for i, f := range strings.Split(l, "\t") {
+			v, err := strconv.ParseFloat(f, 64)
+			if err != nil {
+				t.Fatalf("%s: %s", where, err)
+			}
+			n := strings.TrimPrefix(names[i], "main.")
+			cols[n] = append(cols[n], v)
+		}
+	}
+	return cols
+}
+
+// TestGolden checks both backends against the output of compiled
+// models, kept in testdata and printed with six decimals.
+func TestGolden(t *testing.T) {
+	for _, path := range []string{
+		"../models/bathtub.osm",
+		"../models/exp.osm",
+		"../models/pop.osm",
+		"../models/table.osm",
+		"testdata/builtins.osm",
+	} {
+		name := strings.TrimSuffix(filepath.Base(path), ".osm")
+		want := readGolden(t, "testdata/"+name+".tsv")
+		for backend, m := range backends(t, readModel(t, path)) {
+			s := run(t, m, nil)
+			for v, col := range want {
+				got := series(t, s, v)
+				if len(got) != len(col) {
+					t.Errorf("%s: %s: %d values of %s, want %d", backend, name, len(got), v, len(col))
+					continue
+				}
+				for i := range col {
+					if math.Abs(got[i]-col[i]) > 5e-7*math.Max(1, math.Abs(col[i])) {
+						t.Errorf("%s: %s: %s[%d] = %f, want %f", backend, name, v, i, got[i], col[i])
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestCompiled checks both backends against models compiled now,
+// with every digit of their output.
+func TestCompiled(t *testing.T) {
+	for _, src := range []string{
+		readModel(t, "testdata/builtins.osm"),
+		eqnModel(4, "half = 1/2", "third = time / 3", "rate = 7/2 * half",
+			"level stock = {", "        initial: 1", "        inflow: level * rate / 10", "}"),
+		eqnModel(4, "late = time > 1", "early = (time <= 2) * 2 + (time == 3) * 4",
+			"mid = time != 0 && time >= 2 || time < 1 && time != 1"),
+	} {
+		want := compiled(t, src)
+		for backend, m := range backends(t, src) {
+			s := run(t, m, nil)
+			for v, col := range want {
+				if got := series(t, s, v); !within(got, col) {
+					t.Errorf("%s: %s = %v, but compiled %v", backend, v, got, col)
+				}
+			}
+		}
+	}
+}
+
+// TestBackends checks that the VM gives exactly the interpreter's
+// results on every example model, and fails to load the same ones.
+func TestBackends(t *testing.T) {
+	paths, err := filepath.Glob("../models/*.osm")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no models: %v", err)
+	}
+	// long runs are cut short
+	short := map[string]float64{"speed.osm": 1000}
+	for _, path := range paths {
+		name := filepath.Base(path)
+		src := readModel(t, path)
+		m, err := Load(src)
+		p, vmErr := LoadVM(src)
+		if (err == nil) != (vmErr == nil) {
+			t.Errorf("%s: Load: %v, but LoadVM: %v", name, err, vmErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		var opts *runtime.Options
+		if end, ok := short[name]; ok {
+			opts = &runtime.Options{Timespec: map[string]float64{"end": end}}
+		}
+		want, got := run(t, m, opts), run(t, p, opts)
+		for _, v := range append(m.VarNames(), "time") {
+			if vv, _ := m.Var(v); vv.Type == runtime.TyTable {
+				continue
+			}
+			if a, b := series(t, want, v), series(t, got, v); !reflect.DeepEqual(a, b) {
+				t.Errorf("%s: %s differs: interp %v, vm %v", name, v, a, b)
+			}
+		}
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	src := eqnModel(2, "rate = .5", "growth flow = level * rate",
+		"level stock = {", "        initial: 10", "        inflow: growth", "}")
+	for name, m := range backends(t, src) {
+		opts := &runtime.Options{
+			Set:      map[string]float64{"main.rate": 1},
+			Timespec: map[string]float64{"end": 3},
+		}
+		if got, want := series(t, run(t, m, opts), "level"), []float64{10, 20, 40, 80}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: level = %v, want %v", name, got, want)
+		}
+		// other sims keep the model's values
+		if got, want := series(t, run(t, m, nil), "level"), []float64{10, 15, 22.5}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: level = %v, want %v", name, got, want)
+		}
+
+		// a stock's constant initial value can be set too
+		opts = &runtime.Options{Set: map[string]float64{"level": 2}}
+		if got, want := series(t, run(t, m, opts), "level"), []float64{2, 3, 4.5}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: level = %v, want %v", name, got, want)
+		}
+
+		for _, set := range []string{"growth", "main.speed", "other.rate"} {
+			s := m.NewSim("main", coord, &runtime.Options{Set: map[string]float64{set: 1}})
+			if err := s.RunToEnd(); err == nil {
+				t.Errorf("%s: setting %s succeeded", name, set)
+			}
+		}
+	}
+}
+
+// growthModel is a model of a stock growing at a constant rate.
+var growthModel = eqnModel(3,
+	"// how fast it grows",
+	"rate = .5 `1/year`",
+	"growth flow = level * rate",
+	"level stock = {",
+	"        initial: 10",
+	"        inflow: growth",
+	"}")
+
+func TestValue(t *testing.T) {
+	for name, m := range backends(t, growthModel) {
+		s := m.NewSim("main", coord, nil)
+		value := func(v string, want float64) {
+			if got, err := s.Value(v); err != nil || got != want {
+				t.Errorf("%s: Value(%s) = %g, %v; want %g", name, v, got, err, want)
+			}
+		}
+
+		value("rate", .5)
+		value("main.level", 10)
+		if err := s.SetValue("main.rate", 1); err != nil {
+			t.Fatal(err)
+		}
+		value("rate", 1)
+
+		if err := s.RunTo(1); err != nil {
+			t.Fatal(err)
+		}
+		// stocks are ready for the next step, and flows are
+		// those of the last
+		value("time", 2)
+		value("level", 40)
+		value("growth", 20)
+
+		if err := s.SetValue("level", 100); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RunToEnd(); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := series(t, s, "level"), []float64{10, 20, 100, 200}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: level = %v, want %v", name, got, want)
+		}
+
+		for _, v := range []string{"growth", "nope", "other.rate"} {
+			if err := s.SetValue(v, 1); err == nil {
+				t.Errorf("%s: SetValue(%s) succeeded", name, v)
+			}
+		}
+		if _, err := s.Value("nope"); err == nil {
+			t.Errorf("%s: Value(nope) succeeded", name)
+		}
+	}
+}
+
+func TestVarInfo(t *testing.T) {
+	for name, m := range backends(t, growthModel) {
+		for v, want := range map[string]map[string]interface{}{
+			"rate": {
+				"name":     "rate",
+				"type":     "aux",
+				"units":    "1/year",
+				"equation": ".5",
+				"doc":      "how fast it grows",
+			},
+			"growth": {
+				"name":     "growth",
+				"type":     "flow",
+				"equation": "level * rate",
+				"deps":     []string{"level", "rate"},
+			},
+			"level": {
+				"name":    "level",
+				"type":    "stock",
+				"inflows": []string{"growth"},
+			},
+		} {
+			got := m.VarInfo(v)
+			for k, w := range want {
+				if !reflect.DeepEqual(got[k], w) {
+					t.Errorf("%s: VarInfo(%s)[%s] = %#v, want %#v", name, v, k, got[k], w)
+				}
+			}
+		}
+		if info := m.VarInfo("nope"); info != nil {
+			t.Errorf("%s: VarInfo(nope) = %v", name, info)
+		}
+	}
+}
+
+func TestCheckFinite(t *testing.T) {
+	for _, c := range []struct {
+		eqns []string
+		err  runtime.NonFiniteError
+	}{
+		{
+			[]string{
+				"capacity = 0",
+				"load = 5",
+				"utilization = load / capacity",
+				"cost = utilization * 2",
+			},
+			runtime.NonFiniteError{
+				Name:   "main.utilization",
+				Value:  math.Inf(1),
+				Eqn:    "load / capacity",
+				Inputs: map[string]float64{"capacity": 0, "load": 5},
+			},
+		},
+		{
+			// inline flows are named for their stock
+			[]string{
+				"capacity = 0",
+				"tank stock = {",
+				"        initial: 10",
+				"        outflow: tank / capacity * min(tank, 3)",
+				"}",
+			},
+			runtime.NonFiniteError{
+				Name:   "main.tank outflow",
+				Value:  math.Inf(1),
+				Eqn:    "tank / capacity * min(tank, 3)",
+				Inputs: map[string]float64{"capacity": 0, "tank": 10},
+			},
+		},
+	} {
+		for name, m := range backends(t, eqnModel(3, c.eqns...)) {
+			// without the check, the run goes on
+			run(t, m, nil)
+
+			s := m.NewSim("main", coord, &runtime.Options{CheckFinite: true})
+			err, ok := s.RunToEnd().(*runtime.NonFiniteError)
+			if !ok {
+				t.Errorf("%s: %s: RunToEnd: %v, want a NonFiniteError", name, c.err.Name, err)
+				continue
+			}
+			if !reflect.DeepEqual(*err, c.err) {
+				t.Errorf("%s: %s", name, err)
+			}
+		}
+	}
+}
+
+// stateModel is a model with state of every kind a sim carries
+// between steps.
+var stateModel = eqnModel(12,
+	"noise = random_normal(-1, 1, 0, .5)",
+	"starts flow = 10 + noise",
+	"ship_rate = 6",
+	"shipments flow = ship_rate",
+	"avg = smooth(starts, 3)",
+	"last = previous(avg, 0)",
+	"wip conveyor = {",
+	"        initial: 20",
+	"        inflow: starts",
+	"        outflow: completions",
+	"        transit_time: 3",
+	"}",
+	"backlog queue = {",
+	"        inflow: completions",
+	"        outflow: shipments",
+	"}")
+
+// sameResults reports any variable of m whose saved values differ
+// between the sims a and b.
+func sameResults(t *testing.T, what string, m runtime.Model, a, b runtime.Sim) {
+	for _, v := range append(m.VarNames(), "time") {
+		if x, y := series(t, a, v), series(t, b, v); !reflect.DeepEqual(x, y) {
+			t.Errorf("%s: %s = %v, want %v", what, v, y, x)
+		}
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	for _, c := range []struct {
+		src  string
+		opts *runtime.Options
+	}{
+		{stateModel, nil},
+		{growthModel, &runtime.Options{Method: runtime.RK45, Set: map[string]float64{"rate": .3}}},
+	} {
+		ms := backends(t, c.src)
+		for from, fm := range ms {
+			want := run(t, fm, c.opts)
+
+			s := fm.NewSim("main", coord, c.opts)
+			if err := s.RunTo(2); err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := s.Checkpoint(&buf); err != nil {
+				t.Fatal(err)
+			}
+			// checkpoints can be restored to either backend
+			for to, tm := range ms {
+				r := tm.NewSim("main", coord, c.opts)
+				if err := r.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+					t.Fatalf("%s to %s: Restore: %s", from, to, err)
+				}
+				if err := r.RunToEnd(); err != nil {
+					t.Fatal(err)
+				}
+				sameResults(t, from+" to "+to, fm, want, r)
+			}
+		}
+	}
+}
+
+func TestCheckpointErrors(t *testing.T) {
+	s := run(t, backends(t, growthModel)["interp"], nil)
+	var buf bytes.Buffer
+	if err := s.Checkpoint(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for name, m := range backends(t, stateModel) {
+		if err := m.NewSim("main", coord, nil).Restore(bytes.NewReader(buf.Bytes())); err == nil {
+			t.Errorf("%s: restoring another model's checkpoint succeeded", name)
+		}
+		if err := m.NewSim("main", coord, nil).Restore(strings.NewReader("garbage")); err == nil {
+			t.Errorf("%s: restoring garbage succeeded", name)
+		}
+	}
+	// the timespec of the sim restored to must keep the save grid
+	m := backends(t, growthModel)["vm"]
+	r := m.NewSim("main", coord, &runtime.Options{Timespec: map[string]float64{"save_step": 2}})
+	if err := r.Restore(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Error("restoring to a different save step succeeded")
+	}
+}
+
+func TestFork(t *testing.T) {
+	for name, m := range backends(t, stateModel) {
+		want := run(t, m, nil)
+
+		// a sim changed at time 3, to compare the forks with
+		changed := m.NewSim("main", coord, nil)
+		if err := changed.RunTo(3); err != nil {
+			t.Fatal(err)
+		}
+		if err := changed.SetValue("ship_rate", 9); err != nil {
+			t.Fatal(err)
+		}
+		if err := changed.RunToEnd(); err != nil {
+			t.Fatal(err)
+		}
+
+		s := m.NewSim("main", coord, nil)
+		if err := s.RunTo(3); err != nil {
+			t.Fatal(err)
+		}
+		forks := make([]runtime.Sim, 3)
+		for i := range forks {
+			f, err := s.Fork()
+			if err != nil {
+				t.Fatalf("%s: Fork: %s", name, err)
+			}
+			forks[i] = f
+		}
+		if err := forks[1].SetValue("ship_rate", 9); err != nil {
+			t.Fatal(err)
+		}
+
+		// forks share nothing, so can run in parallel
+		errs := make(chan error, len(forks))
+		for _, f := range forks {
+			go func(f runtime.Sim) { errs <- f.RunToEnd() }(f)
+		}
+		for range forks {
+			if err := <-errs; err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.RunToEnd(); err != nil {
+			t.Fatal(err)
+		}
+
+		sameResults(t, name+" original", m, want, s)
+		sameResults(t, name+" fork 0", m, want, forks[0])
+		sameResults(t, name+" fork 1", m, changed, forks[1])
+		sameResults(t, name+" fork 2", m, want, forks[2])
+	}
+}
+
+// doublingModel is a model of a stock doubling every step.
+var doublingModel = eqnModel(4, "rate = 1", "growth flow = level * rate",
+	"level stock = {", "        initial: 10", "        inflow: growth", "}")
+
+func TestEvents(t *testing.T) {
+	at := func(t float64) *float64 { return &t }
+	for _, c := range []struct {
+		events []runtime.Event
+		want   []float64
+		fired  []runtime.FiredEvent
+	}{
+		{
+			[]runtime.Event{{Name: "halt", At: at(2), Set: map[string]float64{"rate": 0}}},
+			[]float64{10, 20, 40, 40, 40},
+			[]runtime.FiredEvent{{Time: 2, Name: "halt", Values: map[string]float64{"main.rate": 0}}},
+		},
+		{
+			// conditions happen each time they become true
+			[]runtime.Event{{When: "level > 30", Add: map[string]float64{"level": -30}}},
+			[]float64{10, 20, 10, 20, 10},
+			[]runtime.FiredEvent{
+				{Time: 2, Name: "when level > 30", Values: map[string]float64{"main.level": 10}},
+				{Time: 4, Name: "when level > 30", Values: map[string]float64{"main.level": 10}},
+			},
+		},
+		{
+			[]runtime.Event{{When: "level > 30 && time < 10", Once: true, Add: map[string]float64{"main.level": -30}}},
+			[]float64{10, 20, 10, 20, 40},
+			[]runtime.FiredEvent{
+				{Time: 2, Name: "when level > 30 && time < 10", Values: map[string]float64{"main.level": 10}},
+			},
+		},
+	} {
+		for name, m := range backends(t, doublingModel) {
+			s := run(t, m, &runtime.Options{Events: c.events})
+			if got := series(t, s, "level"); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("%s: level = %v, want %v", name, got, c.want)
+			}
+			fired := s.(interface {
+				Events() []runtime.FiredEvent
+			}).Events()
+			if got := fired; !reflect.DeepEqual(got, c.fired) {
+				t.Errorf("%s: fired %+v, want %+v", name, got, c.fired)
+			}
+		}
+	}
+}
+
+func TestEventErrors(t *testing.T) {
+	at := 1.0
+	for _, e := range []runtime.Event{
+		{Set: map[string]float64{"rate": 2}},
+		{At: &at, When: "level > 1", Set: map[string]float64{"rate": 2}},
+		{At: &at},
+		{At: &at, Set: map[string]float64{"speed": 2}},
+		{At: &at, Set: map[string]float64{"growth": 2}},
+		{When: "level >", Set: map[string]float64{"rate": 2}},
+		{When: "height > 1", Set: map[string]float64{"rate": 2}},
+	} {
+		for name, m := range backends(t, doublingModel) {
+			s := m.NewSim("main", coord, &runtime.Options{Events: []runtime.Event{e}})
+			if err := s.RunToEnd(); err == nil {
+				t.Errorf("%s: running with event %+v succeeded", name, e)
+			}
+		}
+	}
+}
+
+func TestStopWhen(t *testing.T) {
+	stopping := eqnModel(4, "rate = 1", "growth flow = level * rate",
+		"level stock = {", "        initial: 10", "        inflow: growth", "}",
+		"stop_when = level > 30 || time >= 3 && growth < 0")
+	for _, c := range []struct {
+		src      string
+		stopWhen string
+		want     []float64
+		stop     *runtime.Stop
+	}{
+		{doublingModel, "", []float64{10, 20, 40, 80, 160}, nil},
+		{doublingModel, "level >= 80", []float64{10, 20, 40, 80}, &runtime.Stop{Time: 3, Reason: "level >= 80"}},
+		{doublingModel, "time > 10 || main.level > growth", []float64{10, 20, 40, 80, 160}, nil},
+		{doublingModel, "time >= 1 && level > 15", []float64{10, 20}, &runtime.Stop{Time: 1, Reason: "time >= 1 && level > 15"}},
+		{stopping, "", []float64{10, 20, 40}, &runtime.Stop{Time: 2, Reason: "level > 30 || time >= 3 && growth < 0"}},
+		// the options override the model
+		{stopping, "level > 100", []float64{10, 20, 40, 80, 160}, &runtime.Stop{Time: 4, Reason: "level > 100"}},
+	} {
+		for name, m := range backends(t, c.src) {
+			s := run(t, m, &runtime.Options{StopWhen: c.stopWhen})
+			if got := series(t, s, "level"); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("%s: stop_when %q: level = %v, want %v", name, c.stopWhen, got, c.want)
+			}
+			stop := s.(interface {
+				Stopped() *runtime.Stop
+			}).Stopped()
+			if !reflect.DeepEqual(stop, c.stop) {
+				t.Errorf("%s: stop_when %q: stopped %+v, want %+v", name, c.stopWhen, stop, c.stop)
+			}
+		}
+	}
+
+	// compiled models stop at the same step
+	if got := compiled(t, stopping)["level"]; !within(got, []float64{10, 20, 40}) {
+		t.Errorf("compiled: level = %v, want [10 20 40]", got)
+	}
+
+	// conditions are checked when the model is compiled
+	for _, eqn := range []string{
+		"stop_when = 1",
+		"stop_when = a",
+		"stop_when = a >",
+		`stop_when = "a > 1"`,
+		"stop_when = b > 1",
+		"stop_when = a > 1 > 0",
+	} {
+		src := eqnModel(4, "a = 1", eqn)
+		if _, err := Load(src); err == nil {
+			t.Errorf("Load(%q) succeeded", eqn)
+		}
+		if _, err := LoadVM(src); err == nil {
+			t.Errorf("LoadVM(%q) succeeded", eqn)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, src := range []string{
+		"",
+		"main model {",
+		"other model {\n}\n",
+		"main model {\n        a = 1 +\n}\n",
+		"main model {\n        a = b\n}\n",
+		"main model {\n        a = smooth(1)\n}\n",
+		"main model {\n        a = nope(1)\n}\n",
+		"main model {\n        s stock = {\n                inflow: f\n        }\n}\n",
+		"main model {\n        s stock = {\n                color: 1\n        }\n}\n",
+		"main model {\n        t table = [(0, 1)]\n}\n",
+	} {
+		if _, err := Load(src); err == nil {
+			t.Errorf("Load(%q) succeeded", src)
+		}
+		if _, err := LoadVM(src); err == nil {
+			t.Errorf("LoadVM(%q) succeeded", src)
+		}
+	}
+}
+
+// benchmarkRun runs a sim of m to the end b.N times, saving only
+// its stock, so that the benchmark measures evaluation.
+func benchmarkRun(b *testing.B, m runtime.Model) {
+	opts := &runtime.Options{Save: []string{"main.level"}}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s := m.NewSim("main", coord, opts)
+		if err := s.RunToEnd(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkInterp(b *testing.B) {
+	m, err := Load(readModel(b, "testdata/chain.osm"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkRun(b, m)
+}
+
+func BenchmarkVM(b *testing.B) {
+	p, err := LoadVM(readModel(b, "testdata/chain.osm"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkRun(b, p)
+}
+
+// speedEnd is where the speed benchmarks stop the speed.osm model,
+// saving only its first and last values.
+const speedEnd = "1000000"
+
+// BenchmarkSpeedCompiled runs speed.osm compiled b.N times,
+// including the start of each process, to compare with
+// BenchmarkSpeedVM.
+func BenchmarkSpeedCompiled(b *testing.B) {
+	dir := build(b, readModel(b, "../models/speed.osm"))
+	defer os.RemoveAll(dir)
+	bin := filepath.Join(dir, "speed")
+	if out, err := exec.Command("go", "build", "-o", bin, "./"+dir).CombinedOutput(); err != nil {
+		b.Fatalf("go build: %s\n%s", err, out)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cmd := exec.Command(bin, "-end", speedEnd, "-save_step", speedEnd)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			b.Fatalf("%s: %s\n%s", bin, err, out)
+		}
+	}
+}
+
+func BenchmarkSpeedVM(b *testing.B) {
+	p, err := LoadVM(readModel(b, "../models/speed.osm"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	end, _ := strconv.ParseFloat(speedEnd, 64)
+	opts := &runtime.Options{
+		Timespec: map[string]float64{"end": end, "save_step": end},
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s := p.NewSim("main", coord, opts)
+		if err := s.RunToEnd(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
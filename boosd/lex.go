@@ -65,6 +65,12 @@ type boosdLex struct {
 	semi  bool
 
 	file *File
+
+	// comments are grouped as they are scanned; ntoks counts the
+	// tokens emitted, so a group ends at any token.
+	comments  []*CommentGroup
+	ntoks     int
+	groupToks int
 }
 
 func (l *boosdLex) Lex(lval *boosdSymType) int {
@@ -91,12 +97,12 @@ func newBoosdLex(input string, file *token.File, result *File) *boosdLex {
 }
 
 func (l *boosdLex) getLine(pos token.Position) string {
-	p := pos.Offset - pos.Column
+	p := pos.Offset - (pos.Column - 1)
 	if p < 0 || p >= len(l.s) {
 		return fmt.Sprintf("getLine: o%d c%d, len%d",
 			pos.Offset, pos.Column, len(l.s))
 	}
-	result := l.s[pos.Offset-pos.Column:]
+	result := l.s[p:]
 	if newline := strings.IndexRune(result, '\n'); newline != -1 {
 		result = result[:newline]
 	}
@@ -107,8 +113,16 @@ func (l *boosdLex) Error(s string) {
 	pos := l.f.Position(l.last.pos)
 	line := l.getLine(pos)
 	// we want the number of spaces (taking into account tabs)
-	// before the problematic token
-	prefixLen := pos.Column + strings.Count(line[:pos.Column], "\t")*7 - 1
+	// before the problematic token, which is past the end of the
+	// line at the end of the input.
+	col := pos.Column - 1
+	if col > len(line) {
+		col = len(line)
+	}
+	prefixLen := col + strings.Count(line[:col], "\t")*7 - 1
+	if prefixLen < 0 {
+		prefixLen = 0
+	}
 	prefix := strings.Repeat(" ", prefixLen)
 
 	line = strings.Replace(line, "\t", "        ", -1)
@@ -121,14 +135,16 @@ func (l *boosdLex) Error(s string) {
 
 func (l *boosdLex) next() rune {
 	if l.pos >= len(l.s) {
-		return 0
+		// so that backing up from the end stays there
+		l.width = 0
+		return eof
 	}
 	r, width := utf8.DecodeRuneInString(l.s[l.pos:])
 	l.pos += width
 	l.width = width
 
 	if r == '\n' {
-		l.f.AddLine(l.pos + 1)
+		l.f.AddLine(l.pos)
 	}
 	return r
 }
@@ -170,6 +186,7 @@ func (l *boosdLex) emit(yyTy rune, ty itemType) {
 	}
 	//log.Printf("t: %#v\n", t)
 	l.last = t
+	l.ntoks++
 	l.items <- t
 	l.ignore()
 
@@ -183,10 +200,19 @@ func (l *boosdLex) emit(yyTy rune, ty itemType) {
 	}
 }
 
+// errorf reports an error in the input, which it counts in the
+// File's NErrors, and ends the input there.
 func (l *boosdLex) errorf(format string, args ...interface{}) stateFn {
 	log.Printf(format, args...)
+	l.file.NErrors++
+	return lexEOF
+}
+
+// lexEOF ends the input, however many more tokens the parser asks
+// for.
+func lexEOF(l *boosdLex) stateFn {
 	l.emit(eof, itemEOF)
-	return nil
+	return lexEOF
 }
 
 func lexStatement(l *boosdLex) stateFn {
@@ -234,9 +260,27 @@ func lexStatement(l *boosdLex) stateFn {
 	return lexStatement
 }
 
+// twoCharOps maps the operators spelled with two characters to
+// their tokens.
+var twoCharOps = map[string]int{
+	"<=": YLE,
+	">=": YGE,
+	"==": YEQ,
+	"!=": YNE,
+	"&&": YAND,
+	"||": YOR,
+}
+
 func lexOperator(l *boosdLex) stateFn {
 	ty := itemOperator
 	r := l.next()
+	if r2 := l.next(); r2 != eof {
+		if yy, ok := twoCharOps[string([]rune{r, r2})]; ok {
+			l.emit(rune(yy), ty)
+			return lexStatement
+		}
+		l.backup()
+	}
 	switch {
 	case r == '{':
 		ty = itemLBracket
@@ -262,6 +306,7 @@ func lexComment(l *boosdLex) stateFn {
 	}
 	l.backup()
 	//	log.Print("2 ignoring:", l.s[l.start:l.pos])
+	l.comment()
 	l.ignore()
 	return lexStatement
 }
@@ -283,10 +328,106 @@ func lexMultiComment(l *boosdLex) stateFn {
 		}
 	}
 	//	log.Print("2 ignoring:", l.s[l.start:l.pos])
+	l.comment()
 	l.ignore()
 	return lexStatement
 }
 
+// comment records the comment just scanned, adding it to the
+// previous group if nothing but whitespace, with no blank lines,
+// separates them.
+func (l *boosdLex) comment() {
+	c := &Comment{Slash: l.f.Pos(l.start), Text: l.s[l.start:l.pos]}
+	if n := len(l.comments); n > 0 && l.groupToks == l.ntoks {
+		g := l.comments[n-1]
+		if l.line(g.End()) == l.line(c.Pos())-1 {
+			g.List = append(g.List, c)
+			return
+		}
+	}
+	l.comments = append(l.comments, &CommentGroup{List: []*Comment{c}})
+	l.groupToks = l.ntoks
+}
+
+// offset returns the offset in the source of pos.
+func (l *boosdLex) offset(pos token.Pos) int {
+	return int(pos) - l.f.Base()
+}
+
+// line returns the line of the source pos is on, counting from 0.
+func (l *boosdLex) line(pos token.Pos) int {
+	off := l.offset(pos)
+	if off > len(l.s) {
+		off = len(l.s)
+	}
+	return strings.Count(l.s[:off], "\n")
+}
+
+// text returns the source between the end of the token ending at
+// from and the start of the token ending at to, with comments
+// removed and whitespace collapsed.
+func (l *boosdLex) text(from, to token.Pos) string {
+	start, end := l.offset(from), l.offset(to)-1
+	if start < 0 || end > len(l.s) || start > end {
+		return ""
+	}
+	src := []byte(l.s[start:end])
+	for _, g := range l.comments {
+		for _, c := range g.List {
+			off := l.offset(c.Pos())
+			if off < start || off >= end {
+				continue
+			}
+			for i := off; i < off+len(c.Text) && i < end; i++ {
+				src[i-start] = ' '
+			}
+		}
+	}
+	return strings.Join(strings.Fields(string(src)), " ")
+}
+
+// attachDocs sets the Doc of each variable declared in a model or
+// interface of f to the comments, if any, on the lines directly
+// above it.
+func (l *boosdLex) attachDocs(f *File) {
+	docs := map[int]*CommentGroup{}
+	for _, g := range l.comments {
+		// a comment after a statement on the same line isn't
+		// documentation.
+		off := l.offset(g.Pos())
+		lineStart := strings.LastIndex(l.s[:off], "\n") + 1
+		if strings.TrimSpace(l.s[lineStart:off]) != "" {
+			continue
+		}
+		docs[l.line(g.End())+1] = g
+	}
+
+	for _, d := range f.Decls {
+		var body *BlockStmt
+		switch d := d.(type) {
+		case *ModelDecl:
+			body = d.Body
+		case *InterfaceDecl:
+			body = d.Body
+		}
+		if body == nil {
+			continue
+		}
+		for _, s := range body.List {
+			var vd *VarDecl
+			switch s := s.(type) {
+			case *AssignStmt:
+				vd = s.Lhs
+			case *DeclStmt:
+				vd = s.Decl
+			}
+			if vd != nil && vd.Name.NamePos.IsValid() {
+				vd.Doc = docs[l.line(vd.Name.NamePos)]
+			}
+		}
+	}
+}
+
 func lexType(l *boosdLex) stateFn {
 	l.ignore()
 	for r := l.next(); r != '`' && r != eof; r = l.next() {
@@ -358,7 +499,7 @@ func isLiteralStart(r rune) bool {
 }
 
 func isOperator(r rune) bool {
-	return bytes.IndexRune([]byte(",+-*/|&=(){}[]:"), r) > -1
+	return bytes.IndexRune([]byte(",+-*/|&=<>!(){}[]:"), r) > -1
 }
 
 func isIdentifierStart(r rune) bool {
@@ -367,5 +508,5 @@ func isIdentifierStart(r rune) bool {
 
 // isAlphaNumeric reports whether r is an alphabetic, digit, or underscore.
 func isAlphaNumeric(r rune) bool {
-	return !(unicode.IsSpace(r) || isOperator(r) || r == ';')
+	return r != eof && !(unicode.IsSpace(r) || isOperator(r) || r == ';')
 }
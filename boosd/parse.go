@@ -1,9 +1,13 @@
+// Code generated by goyacc -o parse.go -p boosd parse.y. DO NOT EDIT.
+
 //line parse.y:6
+
 package boosd
 
 import __yyfmt__ "fmt"
 
 //line parse.y:7
+
 import (
 	"fmt"
 	"go/token"
@@ -39,10 +43,19 @@ const YMODEL = 57352
 const YIDENT = 57353
 const YLITERAL = 57354
 const YNUMBER = 57355
-const UMINUS = 57356
-const FN_CALL = 57357
+const YLE = 57356
+const YGE = 57357
+const YEQ = 57358
+const YNE = 57359
+const YAND = 57360
+const YOR = 57361
+const UMINUS = 57362
+const FN_CALL = 57363
 
-var boosdToknames = []string{
+var boosdToknames = [...]string{
+	"$end",
+	"error",
+	"$unk",
 	"YIMPORT",
 	"YKIND",
 	"YKIND_DECL",
@@ -53,22 +66,40 @@ var boosdToknames = []string{
 	"YIDENT",
 	"YLITERAL",
 	"YNUMBER",
-	" +",
-	" -",
-	" *",
-	" /",
-	" ^",
+	"YLE",
+	"YGE",
+	"YEQ",
+	"YNE",
+	"YAND",
+	"YOR",
+	"'<'",
+	"'>'",
+	"'+'",
+	"'-'",
+	"'*'",
+	"'/'",
+	"'^'",
 	"UMINUS",
 	"FN_CALL",
+	"';'",
+	"','",
+	"'{'",
+	"'}'",
+	"'='",
+	"':'",
+	"'('",
+	"')'",
+	"'['",
+	"']'",
 }
-var boosdStatenames = []string{}
+
+var boosdStatenames = [...]string{}
 
 const boosdEofCode = 1
 const boosdErrCode = 2
-const boosdMaxDepth = 200
-
-//line parse.y:342
+const boosdInitialStackSize = 16
 
+//line parse.y:395
 /* start of programs */
 
 func Parse(f *token.File, str string) (*File, error) {
@@ -76,156 +107,251 @@ func Parse(f *token.File, str string) (*File, error) {
 	// result object, there isn't another good way to keep the
 	// parser and lexer reentrant.
 	result := &File{}
-	err := boosdParse(newBoosdLex(str, f, result))
+	l := newBoosdLex(str, f, result)
+	err := boosdParse(l)
 	if err != 0 {
 		return nil, fmt.Errorf("%d parse errors", err)
 	}
+	result.Filename = f.Name()
+	result.Comments = l.comments
+	l.attachDocs(result)
+	if err := checkDecls(f, result); err != nil {
+		return nil, err
+	}
 
 	return result, nil
 }
 
 //line yacctab:1
-var boosdExca = []int{
+var boosdExca = [...]int8{
 	-1, 1,
 	1, -1,
 	-2, 0,
+	-1, 94,
+	14, 0,
+	15, 0,
+	16, 0,
+	17, 0,
+	20, 0,
+	21, 0,
+	-2, 38,
+	-1, 95,
+	14, 0,
+	15, 0,
+	16, 0,
+	17, 0,
+	20, 0,
+	21, 0,
+	-2, 39,
+	-1, 96,
+	14, 0,
+	15, 0,
+	16, 0,
+	17, 0,
+	20, 0,
+	21, 0,
+	-2, 40,
+	-1, 97,
+	14, 0,
+	15, 0,
+	16, 0,
+	17, 0,
+	20, 0,
+	21, 0,
+	-2, 41,
+	-1, 98,
+	14, 0,
+	15, 0,
+	16, 0,
+	17, 0,
+	20, 0,
+	21, 0,
+	-2, 42,
+	-1, 99,
+	14, 0,
+	15, 0,
+	16, 0,
+	17, 0,
+	20, 0,
+	21, 0,
+	-2, 43,
 }
 
-const boosdNprod = 56
 const boosdPrivate = 57344
 
-var boosdTokenNames []string
-var boosdStates []string
-
-const boosdLast = 143
-
-var boosdAct = []int{
-
-	46, 51, 44, 70, 66, 20, 9, 68, 57, 55,
-	58, 12, 101, 15, 60, 61, 62, 63, 64, 87,
-	13, 71, 53, 23, 48, 56, 25, 86, 92, 57,
-	94, 58, 29, 89, 91, 34, 47, 36, 52, 40,
-	39, 38, 28, 43, 96, 45, 54, 100, 65, 67,
-	41, 35, 59, 60, 61, 62, 63, 64, 77, 78,
-	74, 79, 80, 81, 82, 83, 75, 84, 13, 85,
-	22, 24, 16, 88, 60, 61, 62, 63, 64, 64,
-	74, 90, 53, 13, 10, 53, 21, 48, 10, 13,
-	93, 95, 97, 98, 13, 42, 13, 27, 99, 47,
-	22, 52, 72, 62, 63, 64, 22, 31, 60, 61,
-	62, 63, 64, 60, 61, 62, 63, 64, 22, 19,
-	18, 8, 5, 13, 7, 3, 6, 11, 33, 69,
-	76, 50, 37, 73, 49, 32, 30, 17, 26, 4,
-	1, 14, 2,
+const boosdLast = 237
+
+var boosdAct = [...]int8{
+	80, 50, 78, 43, 81, 20, 13, 10, 53, 13,
+	10, 53, 77, 13, 10, 53, 104, 56, 46, 125,
+	75, 46, 104, 23, 124, 46, 41, 12, 111, 15,
+	45, 117, 52, 45, 109, 52, 107, 82, 44, 52,
+	38, 104, 25, 28, 106, 54, 74, 76, 29, 105,
+	60, 34, 58, 57, 59, 39, 118, 58, 42, 59,
+	88, 87, 89, 90, 91, 92, 93, 94, 95, 96,
+	97, 98, 99, 100, 101, 86, 36, 85, 103, 13,
+	37, 13, 22, 74, 108, 68, 69, 70, 71, 72,
+	73, 66, 67, 61, 62, 63, 64, 65, 123, 65,
+	110, 113, 83, 55, 35, 115, 21, 85, 24, 112,
+	44, 16, 116, 119, 13, 53, 120, 10, 27, 121,
+	122, 68, 69, 70, 71, 72, 73, 66, 67, 61,
+	62, 63, 64, 65, 13, 31, 61, 62, 63, 64,
+	65, 63, 64, 65, 22, 114, 68, 69, 70, 71,
+	72, 73, 66, 67, 61, 62, 63, 64, 65, 22,
+	7, 22, 8, 5, 13, 19, 18, 3, 102, 68,
+	69, 70, 71, 72, 73, 66, 67, 61, 62, 63,
+	64, 65, 68, 69, 70, 71, 72, 73, 66, 67,
+	61, 62, 63, 64, 65, 68, 69, 70, 71, 72,
+	6, 66, 67, 61, 62, 63, 64, 65, 68, 69,
+	70, 71, 11, 33, 66, 67, 61, 62, 63, 64,
+	65, 51, 79, 49, 40, 84, 48, 9, 47, 32,
+	30, 17, 26, 4, 1, 14, 2,
 }
-var boosdPact = []int{
-
-	-1000, -1000, 118, 116, -1000, 76, 85, -1000, 85, 51,
-	-1000, -1000, 110, -1000, 64, -1000, -1000, 100, -1000, -1000,
-	50, 85, -1000, 89, -1000, -1000, 19, 85, -1000, -1000,
-	83, 30, -1000, 16, 112, -1000, -1000, 29, 72, -1000,
-	100, -1000, -1000, 2, -1000, -1000, 94, 9, 9, -22,
-	-1000, -1000, -6, -1000, -1000, 78, -1000, 9, 9, -1000,
-	9, 9, 9, 9, 9, 39, -19, -1000, 9, -3,
-	-1000, 69, -1000, -1000, 7, 57, 6, 99, 60, 87,
-	87, 61, 61, -1000, -1000, 0, -1000, -6, 22, 9,
-	-1000, -1000, 9, -1000, -1000, -1000, 69, 26, 99, -16,
-	-1000, -1000,
+
+var boosdPact = [...]int16{
+	-1000, -1000, 159, 157, -1000, 105, 123, -1000, 123, 82,
+	-1000, -1000, 156, -1000, 76, -1000, -1000, 138, -1000, -1000,
+	79, 123, -1000, 110, -1000, -1000, 12, 123, -1000, -1000,
+	103, 75, -1000, 47, 153, -1000, -1000, -5, -1000, 138,
+	74, -1000, 22, -1000, 155, -2, -2, -25, -1000, -1000,
+	-1000, -1000, 2, -1000, -1000, -1000, 70, -1000, -2, -2,
+	-1000, -2, -2, -2, -2, -2, -2, -2, -2, -2,
+	-2, -2, -2, -2, 132, 17, -1000, -2, 11, 6,
+	168, -1000, -2, -1000, -1000, 0, 68, -8, 71, 117,
+	117, 73, 73, -1000, 114, 114, 114, 114, 114, 114,
+	194, 181, -1000, 107, -2, -1000, -1000, -4, 26, -2,
+	-1000, -1000, -1000, -2, -1000, 168, -1000, 102, 102, 69,
+	-14, 26, -17, -1000, -1000, -1000,
 }
-var boosdPgo = []int{
 
-	0, 142, 141, 140, 139, 4, 138, 137, 136, 135,
-	0, 1, 3, 134, 2, 5, 133, 132, 131, 130,
-	9, 129, 128, 127, 126, 6, 125, 124,
+var boosdPgo = [...]uint8{
+	0, 236, 235, 234, 233, 20, 232, 231, 230, 229,
+	0, 1, 4, 228, 226, 3, 5, 225, 224, 223,
+	2, 17, 222, 213, 212, 200, 221, 167, 160,
 }
-var boosdR1 = []int{
-
-	0, 3, 1, 1, 4, 26, 26, 27, 15, 15,
-	2, 2, 24, 24, 23, 7, 7, 6, 6, 8,
-	8, 9, 9, 22, 22, 17, 17, 17, 17, 20,
-	20, 16, 14, 10, 10, 10, 10, 10, 10, 10,
-	10, 10, 10, 10, 10, 10, 18, 5, 25, 11,
-	19, 19, 13, 21, 21, 12,
+
+var boosdR1 = [...]int8{
+	0, 3, 1, 1, 4, 27, 27, 28, 16, 16,
+	2, 2, 25, 25, 24, 7, 7, 6, 6, 8,
+	8, 9, 9, 23, 23, 18, 18, 18, 21, 21,
+	17, 15, 10, 10, 10, 10, 10, 10, 10, 10,
+	10, 10, 10, 10, 10, 10, 10, 10, 10, 10,
+	10, 10, 10, 10, 10, 10, 19, 5, 26, 11,
+	20, 20, 14, 13, 22, 22, 12,
 }
-var boosdR2 = []int{
 
+var boosdR2 = [...]int8{
 	0, 3, 0, 2, 3, 0, 2, 4, 0, 1,
 	1, 3, 0, 2, 8, 1, 1, 0, 2, 0,
-	2, 2, 3, 2, 3, 4, 5, 2, 2, 0,
-	2, 4, 2, 3, 3, 3, 3, 3, 3, 2,
-	4, 4, 4, 1, 1, 1, 1, 1, 1, 1,
-	1, 3, 3, 1, 3, 5,
+	2, 2, 4, 2, 3, 3, 4, 1, 0, 2,
+	4, 2, 3, 3, 3, 3, 3, 3, 3, 3,
+	3, 3, 3, 3, 3, 3, 2, 4, 4, 4,
+	6, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+	1, 3, 3, 3, 1, 3, 5,
 }
-var boosdChk = []int{
-
-	-1000, -3, -1, -26, -4, 4, -24, -27, 5, -25,
-	12, -23, -5, 11, -2, -5, 21, -7, 10, 9,
-	-15, 22, 6, -15, 21, -5, -6, 8, 23, -5,
-	-8, 24, -9, -22, -5, 21, 21, -17, 25, -15,
-	-5, 21, 23, -5, -14, -25, -10, 27, 15, -13,
-	-18, -11, 29, 13, -15, -20, 23, 27, 29, -15,
-	14, 15, 16, 17, 18, -10, -5, -10, 29, -21,
-	-12, 27, 24, -16, -5, -20, -19, -10, -10, -10,
-	-10, -10, -10, -10, 28, -10, 30, 22, -11, 26,
-	24, 28, 22, 30, 30, -12, 22, -14, -10, -11,
-	21, 28,
+
+var boosdChk = [...]int16{
+	-1000, -3, -1, -27, -4, 4, -25, -28, 5, -26,
+	12, -24, -5, 11, -2, -5, 29, -7, 10, 9,
+	-16, 30, 6, -16, 29, -5, -6, 8, 31, -5,
+	-8, 32, -9, -23, -5, 29, 29, 33, -16, -5,
+	-18, 31, -5, -15, -10, 35, 23, -13, -14, -19,
+	-11, -26, 37, 13, -16, 29, -21, 31, 35, 37,
+	-16, 22, 23, 24, 25, 26, 20, 21, 14, 15,
+	16, 17, 18, 19, -10, -5, -10, 37, -20, -22,
+	-10, -12, 35, 32, -17, -5, -21, -20, -10, -10,
+	-10, -10, -10, -10, -10, -10, -10, -10, -10, -10,
+	-10, -10, 36, -10, 30, 38, 38, 30, -11, 34,
+	32, 36, 38, 30, 38, -10, -12, 35, 30, -15,
+	-20, -11, -11, 29, 38, 36,
 }
-var boosdDef = []int{
 
+var boosdDef = [...]int8{
 	2, -2, 5, 12, 3, 0, 1, 6, 0, 0,
-	48, 13, 0, 47, 8, 10, 4, 8, 15, 16,
+	58, 13, 0, 57, 8, 10, 4, 8, 15, 16,
 	0, 0, 9, 17, 7, 11, 0, 0, 19, 18,
-	0, 0, 20, 0, 8, 14, 21, 0, 0, 23,
-	8, 22, 29, 46, 27, 28, 8, 0, 0, 43,
-	44, 45, 0, 49, 24, 0, 29, 0, 0, 32,
-	0, 0, 0, 0, 0, 0, 46, 39, 0, 0,
-	53, 0, 25, 30, 0, 0, 0, 50, 0, 34,
-	35, 36, 37, 38, 33, 0, 52, 0, 0, 0,
-	26, 40, 0, 42, 41, 54, 0, 0, 51, 0,
-	31, 55,
+	0, 0, 20, 0, 8, 14, 21, 0, 23, 8,
+	0, 28, 56, 27, 8, 0, 0, 51, 52, 53,
+	54, 55, 0, 59, 24, 22, 0, 28, 0, 0,
+	31, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 56, 46, 0, 0, 0,
+	60, 64, 0, 25, 29, 0, 0, 0, 0, 33,
+	34, 35, 36, 37, -2, -2, -2, -2, -2, -2,
+	44, 45, 32, 0, 0, 62, 63, 0, 54, 0,
+	26, 47, 49, 0, 48, 61, 65, 0, 0, 0,
+	0, 0, 0, 30, 50, 66,
 }
-var boosdTok1 = []int{
 
+var boosdTok1 = [...]int8{
 	1, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	27, 28, 16, 14, 22, 15, 3, 17, 3, 3,
-	3, 3, 3, 3, 3, 3, 3, 3, 26, 21,
-	3, 25, 3, 3, 3, 3, 3, 3, 3, 3,
+	35, 36, 24, 22, 30, 23, 3, 25, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 34, 29,
+	20, 33, 21, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 29, 3, 30, 18, 3, 3, 3, 3, 3,
+	3, 37, 3, 38, 26, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 23, 3, 24,
+	3, 3, 3, 31, 3, 32,
 }
-var boosdTok2 = []int{
 
+var boosdTok2 = [...]int8{
 	2, 3, 4, 5, 6, 7, 8, 9, 10, 11,
-	12, 13, 19, 20,
+	12, 13, 14, 15, 16, 17, 18, 19, 27, 28,
 }
-var boosdTok3 = []int{
+
+var boosdTok3 = [...]int8{
 	0,
 }
 
+var boosdErrorMessages = [...]struct {
+	state int
+	token int
+	msg   string
+}{}
+
 //line yaccpar:1
 
 /*	parser for yacc output	*/
 
-var boosdDebug = 0
+var (
+	boosdDebug        = 0
+	boosdErrorVerbose = false
+)
 
 type boosdLexer interface {
 	Lex(lval *boosdSymType) int
 	Error(s string)
 }
 
+type boosdParser interface {
+	Parse(boosdLexer) int
+	Lookahead() int
+}
+
+type boosdParserImpl struct {
+	lval  boosdSymType
+	stack [boosdInitialStackSize]boosdSymType
+	char  int
+}
+
+func (p *boosdParserImpl) Lookahead() int {
+	return p.char
+}
+
+func boosdNewParser() boosdParser {
+	return &boosdParserImpl{}
+}
+
 const boosdFlag = -1000
 
 func boosdTokname(c int) string {
-	// 4 is TOKSTART above
-	if c >= 4 && c-4 < len(boosdToknames) {
-		if boosdToknames[c-4] != "" {
-			return boosdToknames[c-4]
+	if c >= 1 && c-1 < len(boosdToknames) {
+		if boosdToknames[c-1] != "" {
+			return boosdToknames[c-1]
 		}
 	}
 	return __yyfmt__.Sprintf("tok-%v", c)
@@ -240,51 +366,127 @@ func boosdStatname(s int) string {
 	return __yyfmt__.Sprintf("state-%v", s)
 }
 
-func boosdlex1(lex boosdLexer, lval *boosdSymType) int {
-	c := 0
-	char := lex.Lex(lval)
+func boosdErrorMessage(state, lookAhead int) string {
+	const TOKSTART = 4
+
+	if !boosdErrorVerbose {
+		return "syntax error"
+	}
+
+	for _, e := range boosdErrorMessages {
+		if e.state == state && e.token == lookAhead {
+			return "syntax error: " + e.msg
+		}
+	}
+
+	res := "syntax error: unexpected " + boosdTokname(lookAhead)
+
+	// To match Bison, suggest at most four expected tokens.
+	expected := make([]int, 0, 4)
+
+	// Look for shiftable tokens.
+	base := int(boosdPact[state])
+	for tok := TOKSTART; tok-1 < len(boosdToknames); tok++ {
+		if n := base + tok; n >= 0 && n < boosdLast && int(boosdChk[int(boosdAct[n])]) == tok {
+			if len(expected) == cap(expected) {
+				return res
+			}
+			expected = append(expected, tok)
+		}
+	}
+
+	if boosdDef[state] == -2 {
+		i := 0
+		for boosdExca[i] != -1 || int(boosdExca[i+1]) != state {
+			i += 2
+		}
+
+		// Look for tokens that we accept or reduce.
+		for i += 2; boosdExca[i] >= 0; i += 2 {
+			tok := int(boosdExca[i])
+			if tok < TOKSTART || boosdExca[i+1] == 0 {
+				continue
+			}
+			if len(expected) == cap(expected) {
+				return res
+			}
+			expected = append(expected, tok)
+		}
+
+		// If the default action is to accept or reduce, give up.
+		if boosdExca[i+1] != 0 {
+			return res
+		}
+	}
+
+	for i, tok := range expected {
+		if i == 0 {
+			res += ", expecting "
+		} else {
+			res += " or "
+		}
+		res += boosdTokname(tok)
+	}
+	return res
+}
+
+func boosdlex1(lex boosdLexer, lval *boosdSymType) (char, token int) {
+	token = 0
+	char = lex.Lex(lval)
 	if char <= 0 {
-		c = boosdTok1[0]
+		token = int(boosdTok1[0])
 		goto out
 	}
 	if char < len(boosdTok1) {
-		c = boosdTok1[char]
+		token = int(boosdTok1[char])
 		goto out
 	}
 	if char >= boosdPrivate {
 		if char < boosdPrivate+len(boosdTok2) {
-			c = boosdTok2[char-boosdPrivate]
+			token = int(boosdTok2[char-boosdPrivate])
 			goto out
 		}
 	}
 	for i := 0; i < len(boosdTok3); i += 2 {
-		c = boosdTok3[i+0]
-		if c == char {
-			c = boosdTok3[i+1]
+		token = int(boosdTok3[i+0])
+		if token == char {
+			token = int(boosdTok3[i+1])
 			goto out
 		}
 	}
 
 out:
-	if c == 0 {
-		c = boosdTok2[1] /* unknown char */
+	if token == 0 {
+		token = int(boosdTok2[1]) /* unknown char */
 	}
 	if boosdDebug >= 3 {
-		__yyfmt__.Printf("lex %s(%d)\n", boosdTokname(c), uint(char))
+		__yyfmt__.Printf("lex %s(%d)\n", boosdTokname(token), uint(char))
 	}
-	return c
+	return char, token
 }
 
 func boosdParse(boosdlex boosdLexer) int {
+	return boosdNewParser().Parse(boosdlex)
+}
+
+func (boosdrcvr *boosdParserImpl) Parse(boosdlex boosdLexer) int {
 	var boosdn int
-	var boosdlval boosdSymType
 	var boosdVAL boosdSymType
-	boosdS := make([]boosdSymType, boosdMaxDepth)
+	var boosdDollar []boosdSymType
+	_ = boosdDollar // silence set and not used
+	boosdS := boosdrcvr.stack[:]
 
 	Nerrs := 0   /* number of errors */
 	Errflag := 0 /* error recovery flag */
 	boosdstate := 0
-	boosdchar := -1
+	boosdrcvr.char = -1
+	boosdtoken := -1 // boosdrcvr.char translated into internal numbering
+	defer func() {
+		// Make sure we report no lookahead when not parsing.
+		boosdstate = -1
+		boosdrcvr.char = -1
+		boosdtoken = -1
+	}()
 	boosdp := -1
 	goto boosdstack
 
@@ -297,7 +499,7 @@ ret1:
 boosdstack:
 	/* put a state and value onto the stack */
 	if boosdDebug >= 4 {
-		__yyfmt__.Printf("char %v in %v\n", boosdTokname(boosdchar), boosdStatname(boosdstate))
+		__yyfmt__.Printf("char %v in %v\n", boosdTokname(boosdtoken), boosdStatname(boosdstate))
 	}
 
 	boosdp++
@@ -310,21 +512,22 @@ boosdstack:
 	boosdS[boosdp].yys = boosdstate
 
 boosdnewstate:
-	boosdn = boosdPact[boosdstate]
+	boosdn = int(boosdPact[boosdstate])
 	if boosdn <= boosdFlag {
 		goto boosddefault /* simple state */
 	}
-	if boosdchar < 0 {
-		boosdchar = boosdlex1(boosdlex, &boosdlval)
+	if boosdrcvr.char < 0 {
+		boosdrcvr.char, boosdtoken = boosdlex1(boosdlex, &boosdrcvr.lval)
 	}
-	boosdn += boosdchar
+	boosdn += boosdtoken
 	if boosdn < 0 || boosdn >= boosdLast {
 		goto boosddefault
 	}
-	boosdn = boosdAct[boosdn]
-	if boosdChk[boosdn] == boosdchar { /* valid shift */
-		boosdchar = -1
-		boosdVAL = boosdlval
+	boosdn = int(boosdAct[boosdn])
+	if int(boosdChk[boosdn]) == boosdtoken { /* valid shift */
+		boosdrcvr.char = -1
+		boosdtoken = -1
+		boosdVAL = boosdrcvr.lval
 		boosdstate = boosdn
 		if Errflag > 0 {
 			Errflag--
@@ -334,27 +537,27 @@ boosdnewstate:
 
 boosddefault:
 	/* default state action */
-	boosdn = boosdDef[boosdstate]
+	boosdn = int(boosdDef[boosdstate])
 	if boosdn == -2 {
-		if boosdchar < 0 {
-			boosdchar = boosdlex1(boosdlex, &boosdlval)
+		if boosdrcvr.char < 0 {
+			boosdrcvr.char, boosdtoken = boosdlex1(boosdlex, &boosdrcvr.lval)
 		}
 
 		/* look through exception table */
 		xi := 0
 		for {
-			if boosdExca[xi+0] == -1 && boosdExca[xi+1] == boosdstate {
+			if boosdExca[xi+0] == -1 && int(boosdExca[xi+1]) == boosdstate {
 				break
 			}
 			xi += 2
 		}
 		for xi += 2; ; xi += 2 {
-			boosdn = boosdExca[xi+0]
-			if boosdn < 0 || boosdn == boosdchar {
+			boosdn = int(boosdExca[xi+0])
+			if boosdn < 0 || boosdn == boosdtoken {
 				break
 			}
 		}
-		boosdn = boosdExca[xi+1]
+		boosdn = int(boosdExca[xi+1])
 		if boosdn < 0 {
 			goto ret0
 		}
@@ -363,11 +566,11 @@ boosddefault:
 		/* error ... attempt to resume parsing */
 		switch Errflag {
 		case 0: /* brand new error */
-			boosdlex.Error("syntax error")
+			boosdlex.Error(boosdErrorMessage(boosdstate, boosdtoken))
 			Nerrs++
 			if boosdDebug >= 1 {
 				__yyfmt__.Printf("%s", boosdStatname(boosdstate))
-				__yyfmt__.Printf(" saw %s\n", boosdTokname(boosdchar))
+				__yyfmt__.Printf(" saw %s\n", boosdTokname(boosdtoken))
 			}
 			fallthrough
 
@@ -376,10 +579,10 @@ boosddefault:
 
 			/* find a state where "error" is a legal shift action */
 			for boosdp >= 0 {
-				boosdn = boosdPact[boosdS[boosdp].yys] + boosdErrCode
+				boosdn = int(boosdPact[boosdS[boosdp].yys]) + boosdErrCode
 				if boosdn >= 0 && boosdn < boosdLast {
-					boosdstate = boosdAct[boosdn] /* simulate a shift of "error" */
-					if boosdChk[boosdstate] == boosdErrCode {
+					boosdstate = int(boosdAct[boosdn]) /* simulate a shift of "error" */
+					if int(boosdChk[boosdstate]) == boosdErrCode {
 						goto boosdstack
 					}
 				}
@@ -395,12 +598,13 @@ boosddefault:
 
 		case 3: /* no shift yet; clobber input char */
 			if boosdDebug >= 2 {
-				__yyfmt__.Printf("error recovery discards %s\n", boosdTokname(boosdchar))
+				__yyfmt__.Printf("error recovery discards %s\n", boosdTokname(boosdtoken))
 			}
-			if boosdchar == boosdEofCode {
+			if boosdtoken == boosdEofCode {
 				goto ret1
 			}
-			boosdchar = -1
+			boosdrcvr.char = -1
+			boosdtoken = -1
 			goto boosdnewstate /* try again in the same state */
 		}
 	}
@@ -414,308 +618,439 @@ boosddefault:
 	boosdpt := boosdp
 	_ = boosdpt // guard against "declared and not used"
 
-	boosdp -= boosdR2[boosdn]
+	boosdp -= int(boosdR2[boosdn])
+	// boosdp is now the index of $0. Perform the default action. Iff the
+	// reduced production is ε, $1 is possibly out of range.
+	if boosdp+1 >= len(boosdS) {
+		nyys := make([]boosdSymType, len(boosdS)*2)
+		copy(nyys, boosdS)
+		boosdS = nyys
+	}
 	boosdVAL = boosdS[boosdp+1]
 
 	/* consult goto table to find next state */
-	boosdn = boosdR1[boosdn]
-	boosdg := boosdPgo[boosdn]
+	boosdn = int(boosdR1[boosdn])
+	boosdg := int(boosdPgo[boosdn])
 	boosdj := boosdg + boosdS[boosdp].yys + 1
 
 	if boosdj >= boosdLast {
-		boosdstate = boosdAct[boosdg]
+		boosdstate = int(boosdAct[boosdg])
 	} else {
-		boosdstate = boosdAct[boosdj]
-		if boosdChk[boosdstate] != -boosdn {
-			boosdstate = boosdAct[boosdg]
+		boosdstate = int(boosdAct[boosdj])
+		if int(boosdChk[boosdstate]) != -boosdn {
+			boosdstate = int(boosdAct[boosdg])
 		}
 	}
 	// dummy call; replaced with literal code
 	switch boosdnt {
 
 	case 1:
-		//line parse.y:70
+		boosdDollar = boosdS[boosdpt-3 : boosdpt+1]
+//line parse.y:74
 		{
-			boosdVAL.file.Decls = boosdS[boosdpt-0].decls
+			boosdVAL.file.Decls = boosdDollar[3].decls
 			*boosdlex.(*boosdLex).file = boosdVAL.file
 		}
 	case 2:
-		//line parse.y:76
+		boosdDollar = boosdS[boosdpt-0 : boosdpt+1]
+//line parse.y:80
 		{
 		}
 	case 3:
-		//line parse.y:78
+		boosdDollar = boosdS[boosdpt-2 : boosdpt+1]
+//line parse.y:82
 		{
-			boosdVAL.strs = append(boosdS[boosdpt-1].strs, boosdS[boosdpt-0].str)
+			boosdVAL.strs = append(boosdDollar[1].strs, boosdDollar[2].str)
 		}
 	case 4:
-		//line parse.y:84
+		boosdDollar = boosdS[boosdpt-3 : boosdpt+1]
+//line parse.y:88
 		{
 		}
 	case 5:
-		//line parse.y:88
+		boosdDollar = boosdS[boosdpt-0 : boosdpt+1]
+//line parse.y:92
 		{
 		}
 	case 6:
-		//line parse.y:90
+		boosdDollar = boosdS[boosdpt-2 : boosdpt+1]
+//line parse.y:94
 		{
 		}
 	case 7:
-		//line parse.y:95
+		boosdDollar = boosdS[boosdpt-4 : boosdpt+1]
+//line parse.y:99
 		{
 		}
 	case 8:
-		//line parse.y:99
+		boosdDollar = boosdS[boosdpt-0 : boosdpt+1]
+//line parse.y:103
 		{
 			boosdVAL.expr = nil
 		}
 	case 9:
-		//line parse.y:103
+		boosdDollar = boosdS[boosdpt-1 : boosdpt+1]
+//line parse.y:107
 		{
-			boosdVAL.expr = &BasicLit{Kind: token.STRING, Value: boosdS[boosdpt-0].tok.val}
+			boosdVAL.expr = &BasicLit{Kind: token.STRING, Value: boosdDollar[1].tok.val}
 		}
 	case 10:
-		//line parse.y:109
+		boosdDollar = boosdS[boosdpt-1 : boosdpt+1]
+//line parse.y:113
 		{
-			boosdVAL.ids = []*Ident{boosdS[boosdpt-0].id}
+			boosdVAL.ids = []*Ident{boosdDollar[1].id}
 		}
 	case 11:
-		//line parse.y:113
+		boosdDollar = boosdS[boosdpt-3 : boosdpt+1]
+//line parse.y:117
 		{
-			boosdVAL.ids = append(boosdS[boosdpt-2].ids, boosdS[boosdpt-0].id)
+			boosdVAL.ids = append(boosdDollar[1].ids, boosdDollar[3].id)
 		}
 	case 12:
-		//line parse.y:118
+		boosdDollar = boosdS[boosdpt-0 : boosdpt+1]
+//line parse.y:122
 		{
 		}
 	case 13:
-		//line parse.y:120
+		boosdDollar = boosdS[boosdpt-2 : boosdpt+1]
+//line parse.y:124
 		{
-			boosdVAL.decls = append(boosdS[boosdpt-1].decls, boosdS[boosdpt-0].tlDecl)
+			boosdVAL.decls = append(boosdDollar[1].decls, boosdDollar[2].tlDecl)
 		}
 	case 14:
-		//line parse.y:126
+		boosdDollar = boosdS[boosdpt-8 : boosdpt+1]
+//line parse.y:130
 		{
-			if boosdS[boosdpt-6].tok.val == "model" {
-				boosdVAL.tlDecl = &ModelDecl{Name: boosdS[boosdpt-7].id, Body: boosdS[boosdpt-2].block}
+			if boosdDollar[2].tok.val == "model" {
+				boosdVAL.tlDecl = &ModelDecl{Name: boosdDollar[1].id, Body: boosdDollar[6].block}
 			} else {
-				boosdVAL.tlDecl = &InterfaceDecl{Name: boosdS[boosdpt-7].id, Body: boosdS[boosdpt-2].block}
+				boosdVAL.tlDecl = &InterfaceDecl{Name: boosdDollar[1].id, Body: boosdDollar[6].block}
 			}
 		}
 	case 15:
-		//line parse.y:136
+		boosdDollar = boosdS[boosdpt-1 : boosdpt+1]
+//line parse.y:140
 		{
-			boosdVAL.tok = boosdS[boosdpt-0].tok
+			boosdVAL.tok = boosdDollar[1].tok
 		}
 	case 16:
-		//line parse.y:140
+		boosdDollar = boosdS[boosdpt-1 : boosdpt+1]
+//line parse.y:144
 		{
-			boosdVAL.tok = boosdS[boosdpt-0].tok
+			boosdVAL.tok = boosdDollar[1].tok
 		}
 	case 17:
-		//line parse.y:145
+		boosdDollar = boosdS[boosdpt-0 : boosdpt+1]
+//line parse.y:149
 		{
 		}
 	case 18:
-		//line parse.y:147
+		boosdDollar = boosdS[boosdpt-2 : boosdpt+1]
+//line parse.y:151
 		{
-			boosdVAL.id = boosdS[boosdpt-0].id
+			boosdVAL.id = boosdDollar[2].id
 		}
 	case 19:
-		//line parse.y:153
+		boosdDollar = boosdS[boosdpt-0 : boosdpt+1]
+//line parse.y:157
 		{
 			boosdVAL.block = &BlockStmt{List: []Stmt{}}
 		}
 	case 20:
-		//line parse.y:157
+		boosdDollar = boosdS[boosdpt-2 : boosdpt+1]
+//line parse.y:161
 		{
-			boosdVAL.block = boosdS[boosdpt-1].block
-			boosdVAL.block.List = append(boosdS[boosdpt-1].block.List, boosdS[boosdpt-0].stmt)
+			boosdVAL.block = boosdDollar[1].block
+			boosdVAL.block.List = append(boosdDollar[1].block.List, boosdDollar[2].stmt)
 		}
 	case 21:
-		//line parse.y:164
+		boosdDollar = boosdS[boosdpt-2 : boosdpt+1]
+//line parse.y:168
 		{
-			boosdVAL.stmt = &DeclStmt{boosdS[boosdpt-1].decl}
+			boosdVAL.stmt = &DeclStmt{boosdDollar[1].decl}
 		}
 	case 22:
-		//line parse.y:168
+		boosdDollar = boosdS[boosdpt-4 : boosdpt+1]
+//line parse.y:172
 		{
-			boosdVAL.stmt = &AssignStmt{Lhs: boosdS[boosdpt-2].decl, Rhs: boosdS[boosdpt-1].expr}
+			l := boosdlex.(*boosdLex)
+			boosdVAL.stmt = &AssignStmt{Lhs: boosdDollar[1].decl, TokPos: boosdDollar[2].tok.pos - 1, Rhs: boosdDollar[3].expr,
+				Eqn: l.text(boosdDollar[2].tok.pos, boosdDollar[4].tok.pos)}
 		}
 	case 23:
-		//line parse.y:175
+		boosdDollar = boosdS[boosdpt-2 : boosdpt+1]
+//line parse.y:181
 		{
-			boosdVAL.decl = &VarDecl{Name: boosdS[boosdpt-1].id, Type: NewIdent("aux"), Units: boosdS[boosdpt-0].expr}
+			boosdVAL.decl = &VarDecl{Name: boosdDollar[1].id, Type: NewIdent("aux"), Units: boosdDollar[2].expr}
 		}
 	case 24:
-		//line parse.y:179
+		boosdDollar = boosdS[boosdpt-3 : boosdpt+1]
+//line parse.y:185
 		{
-			boosdVAL.decl = &VarDecl{Name: boosdS[boosdpt-2].id, Type: boosdS[boosdpt-1].id, Units: boosdS[boosdpt-0].expr}
+			boosdVAL.decl = &VarDecl{Name: boosdDollar[1].id, Type: boosdDollar[2].id, Units: boosdDollar[3].expr}
 		}
 	case 25:
-		//line parse.y:185
+		boosdDollar = boosdS[boosdpt-3 : boosdpt+1]
+//line parse.y:191
 		{
-			boosdVAL.expr = &CompositeLit{Type: NewIdent("stock"), Elts: boosdS[boosdpt-1].exprs}
+			boosdVAL.expr = &CompositeLit{Type: NewIdent("stock"), Elts: boosdDollar[2].exprs}
 		}
 	case 26:
-		//line parse.y:189
+		boosdDollar = boosdS[boosdpt-4 : boosdpt+1]
+//line parse.y:195
 		{
-			boosdVAL.expr = &CompositeLit{Type: boosdS[boosdpt-3].id, Elts: boosdS[boosdpt-1].exprs}
+			boosdVAL.expr = &CompositeLit{Type: boosdDollar[1].id, Elts: boosdDollar[3].exprs}
 		}
 	case 27:
-		//line parse.y:193
+		boosdDollar = boosdS[boosdpt-1 : boosdpt+1]
+//line parse.y:199
 		{
-			boosdVAL.expr = boosdS[boosdpt-0].expr
+			boosdVAL.expr = boosdDollar[1].expr
 		}
 	case 28:
-		//line parse.y:197
+		boosdDollar = boosdS[boosdpt-0 : boosdpt+1]
+//line parse.y:204
 		{
-			boosdVAL.expr = boosdS[boosdpt-0].lit
+			boosdVAL.exprs = []Expr{}
 		}
 	case 29:
-		//line parse.y:202
+		boosdDollar = boosdS[boosdpt-2 : boosdpt+1]
+//line parse.y:208
 		{
-			boosdVAL.exprs = []Expr{}
+			boosdVAL.exprs = append(boosdDollar[1].exprs, boosdDollar[2].expr)
 		}
 	case 30:
-		//line parse.y:206
+		boosdDollar = boosdS[boosdpt-4 : boosdpt+1]
+//line parse.y:214
 		{
-			boosdVAL.exprs = append(boosdS[boosdpt-1].exprs, boosdS[boosdpt-0].expr)
+			boosdVAL.expr = &KeyValueExpr{Key: boosdDollar[1].id, Value: boosdDollar[3].expr}
 		}
 	case 31:
-		//line parse.y:212
+		boosdDollar = boosdS[boosdpt-2 : boosdpt+1]
+//line parse.y:220
 		{
-			boosdVAL.expr = &KeyValueExpr{Key: boosdS[boosdpt-3].id, Value: boosdS[boosdpt-1].expr}
+			boosdVAL.expr = &UnitExpr{boosdDollar[1].expr, boosdDollar[2].expr}
 		}
 	case 32:
-		//line parse.y:218
+		boosdDollar = boosdS[boosdpt-3 : boosdpt+1]
+//line parse.y:226
 		{
-			boosdVAL.expr = &UnitExpr{boosdS[boosdpt-1].expr, boosdS[boosdpt-0].expr}
+			boosdVAL.expr = boosdDollar[2].expr
 		}
 	case 33:
-		//line parse.y:224
+		boosdDollar = boosdS[boosdpt-3 : boosdpt+1]
+//line parse.y:230
 		{
-			boosdVAL.expr = boosdS[boosdpt-1].expr
+			boosdVAL.expr = &BinaryExpr{X: boosdDollar[1].expr, Y: boosdDollar[3].expr, Op: token.ADD}
 		}
 	case 34:
-		//line parse.y:228
+		boosdDollar = boosdS[boosdpt-3 : boosdpt+1]
+//line parse.y:234
 		{
-			boosdVAL.expr = &BinaryExpr{X: boosdS[boosdpt-2].expr, Y: boosdS[boosdpt-0].expr, Op: token.ADD}
+			boosdVAL.expr = &BinaryExpr{X: boosdDollar[1].expr, Y: boosdDollar[3].expr, Op: token.SUB}
 		}
 	case 35:
-		//line parse.y:232
+		boosdDollar = boosdS[boosdpt-3 : boosdpt+1]
+//line parse.y:238
 		{
-			boosdVAL.expr = &BinaryExpr{X: boosdS[boosdpt-2].expr, Y: boosdS[boosdpt-0].expr, Op: token.SUB}
+			boosdVAL.expr = &BinaryExpr{X: boosdDollar[1].expr, Y: boosdDollar[3].expr, Op: token.MUL}
 		}
 	case 36:
-		//line parse.y:236
+		boosdDollar = boosdS[boosdpt-3 : boosdpt+1]
+//line parse.y:242
 		{
-			boosdVAL.expr = &BinaryExpr{X: boosdS[boosdpt-2].expr, Y: boosdS[boosdpt-0].expr, Op: token.MUL}
+			boosdVAL.expr = &BinaryExpr{X: boosdDollar[1].expr, Y: boosdDollar[3].expr, Op: token.QUO}
 		}
 	case 37:
-		//line parse.y:240
+		boosdDollar = boosdS[boosdpt-3 : boosdpt+1]
+//line parse.y:246
 		{
-			boosdVAL.expr = &BinaryExpr{X: boosdS[boosdpt-2].expr, Y: boosdS[boosdpt-0].expr, Op: token.QUO}
+			boosdVAL.expr = &BinaryExpr{X: boosdDollar[1].expr, Y: boosdDollar[3].expr, Op: token.XOR}
 		}
 	case 38:
-		//line parse.y:244
+		boosdDollar = boosdS[boosdpt-3 : boosdpt+1]
+//line parse.y:250
 		{
-			boosdVAL.expr = &BinaryExpr{X: boosdS[boosdpt-2].expr, Y: boosdS[boosdpt-0].expr, Op: token.XOR}
+			boosdVAL.expr = &BinaryExpr{X: boosdDollar[1].expr, Y: boosdDollar[3].expr, Op: token.LSS}
 		}
 	case 39:
-		//line parse.y:248
+		boosdDollar = boosdS[boosdpt-3 : boosdpt+1]
+//line parse.y:254
 		{
-			boosdVAL.expr = &UnaryExpr{X: boosdS[boosdpt-0].expr, Op: token.SUB}
+			boosdVAL.expr = &BinaryExpr{X: boosdDollar[1].expr, Y: boosdDollar[3].expr, Op: token.GTR}
 		}
 	case 40:
-		//line parse.y:252
+		boosdDollar = boosdS[boosdpt-3 : boosdpt+1]
+//line parse.y:258
 		{
-			boosdVAL.expr = &CallExpr{Fun: boosdS[boosdpt-3].id, Args: boosdS[boosdpt-1].exprs}
+			boosdVAL.expr = &BinaryExpr{X: boosdDollar[1].expr, Y: boosdDollar[3].expr, Op: token.LEQ}
 		}
 	case 41:
-		//line parse.y:256
+		boosdDollar = boosdS[boosdpt-3 : boosdpt+1]
+//line parse.y:262
 		{
-			boosdVAL.expr = &IndexExpr{X: boosdS[boosdpt-3].expr, Index: boosdS[boosdpt-1].expr}
+			boosdVAL.expr = &BinaryExpr{X: boosdDollar[1].expr, Y: boosdDollar[3].expr, Op: token.GEQ}
 		}
 	case 42:
-		//line parse.y:260
+		boosdDollar = boosdS[boosdpt-3 : boosdpt+1]
+//line parse.y:266
 		{
-			boosdVAL.expr = &IndexExpr{X: boosdS[boosdpt-3].id, Index: boosdS[boosdpt-1].expr}
+			boosdVAL.expr = &BinaryExpr{X: boosdDollar[1].expr, Y: boosdDollar[3].expr, Op: token.EQL}
 		}
 	case 43:
-		//line parse.y:264
+		boosdDollar = boosdS[boosdpt-3 : boosdpt+1]
+//line parse.y:270
 		{
-			boosdVAL.expr = boosdS[boosdpt-0].expr
+			boosdVAL.expr = &BinaryExpr{X: boosdDollar[1].expr, Y: boosdDollar[3].expr, Op: token.NEQ}
 		}
 	case 44:
-		//line parse.y:268
+		boosdDollar = boosdS[boosdpt-3 : boosdpt+1]
+//line parse.y:274
 		{
-			boosdVAL.expr = boosdS[boosdpt-0].expr
+			boosdVAL.expr = &BinaryExpr{X: boosdDollar[1].expr, Y: boosdDollar[3].expr, Op: token.LAND}
 		}
 	case 45:
-		//line parse.y:272
+		boosdDollar = boosdS[boosdpt-3 : boosdpt+1]
+//line parse.y:278
 		{
-			boosdVAL.expr = boosdS[boosdpt-0].expr
+			boosdVAL.expr = &BinaryExpr{X: boosdDollar[1].expr, Y: boosdDollar[3].expr, Op: token.LOR}
 		}
 	case 46:
-		//line parse.y:278
+		boosdDollar = boosdS[boosdpt-2 : boosdpt+1]
+//line parse.y:282
 		{
-			boosdVAL.expr = &RefExpr{*boosdS[boosdpt-0].id}
+			boosdVAL.expr = &UnaryExpr{X: boosdDollar[2].expr, Op: token.SUB}
 		}
 	case 47:
-		//line parse.y:283
+		boosdDollar = boosdS[boosdpt-4 : boosdpt+1]
+//line parse.y:286
 		{
-			boosdVAL.id = &Ident{Name: boosdS[boosdpt-0].tok.val}
+			boosdVAL.expr = &CallExpr{Fun: boosdDollar[1].id, Args: boosdDollar[3].exprs}
 		}
 	case 48:
-		//line parse.y:289
+		boosdDollar = boosdS[boosdpt-4 : boosdpt+1]
+//line parse.y:290
 		{
-			boosdVAL.lit = &BasicLit{Kind: token.STRING, Value: boosdS[boosdpt-0].tok.val}
+			boosdVAL.expr = &IndexExpr{X: boosdDollar[1].expr, Index: boosdDollar[3].expr}
 		}
 	case 49:
-		//line parse.y:295
+		boosdDollar = boosdS[boosdpt-4 : boosdpt+1]
+//line parse.y:294
 		{
-			boosdVAL.expr = &BasicLit{Kind: token.FLOAT, Value: boosdS[boosdpt-0].tok.val}
+			boosdVAL.expr = &IndexExpr{X: boosdDollar[1].id, Index: boosdDollar[3].expr}
 		}
 	case 50:
-		//line parse.y:301
+		boosdDollar = boosdS[boosdpt-6 : boosdpt+1]
+//line parse.y:298
 		{
-			boosdVAL.exprs = make([]Expr, 1, 16)
-			boosdVAL.exprs[0] = boosdS[boosdpt-0].expr
+			boosdVAL.expr = &IndexListExpr{X: boosdDollar[1].id, Indices: append([]Expr{boosdDollar[3].expr}, boosdDollar[5].exprs...)}
 		}
 	case 51:
-		//line parse.y:306
+		boosdDollar = boosdS[boosdpt-1 : boosdpt+1]
+//line parse.y:302
 		{
-			boosdVAL.exprs = append(boosdS[boosdpt-2].exprs, boosdS[boosdpt-0].expr)
+			boosdVAL.expr = boosdDollar[1].expr
 		}
 	case 52:
-		//line parse.y:312
+		boosdDollar = boosdS[boosdpt-1 : boosdpt+1]
+//line parse.y:306
 		{
-			boosdVAL.expr = &TableExpr{Pairs: boosdS[boosdpt-1].pexprs}
+			boosdVAL.expr = boosdDollar[1].expr
 		}
 	case 53:
-		//line parse.y:318
+		boosdDollar = boosdS[boosdpt-1 : boosdpt+1]
+//line parse.y:310
+		{
+			boosdVAL.expr = boosdDollar[1].expr
+		}
+	case 54:
+		boosdDollar = boosdS[boosdpt-1 : boosdpt+1]
+//line parse.y:314
+		{
+			boosdVAL.expr = boosdDollar[1].expr
+		}
+	case 55:
+		boosdDollar = boosdS[boosdpt-1 : boosdpt+1]
+//line parse.y:318
+		{
+			boosdVAL.expr = boosdDollar[1].lit
+		}
+	case 56:
+		boosdDollar = boosdS[boosdpt-1 : boosdpt+1]
+//line parse.y:324
+		{
+			boosdVAL.expr = &RefExpr{*boosdDollar[1].id}
+		}
+	case 57:
+		boosdDollar = boosdS[boosdpt-1 : boosdpt+1]
+//line parse.y:329
+		{
+			// token positions are of their end
+			boosdVAL.id = &Ident{NamePos: boosdDollar[1].tok.pos - token.Pos(len(boosdDollar[1].tok.val)), Name: boosdDollar[1].tok.val}
+		}
+	case 58:
+		boosdDollar = boosdS[boosdpt-1 : boosdpt+1]
+//line parse.y:336
+		{
+			boosdVAL.lit = &BasicLit{Kind: token.STRING, Value: boosdDollar[1].tok.val}
+		}
+	case 59:
+		boosdDollar = boosdS[boosdpt-1 : boosdpt+1]
+//line parse.y:342
+		{
+			boosdVAL.expr = &BasicLit{Kind: token.FLOAT, Value: boosdDollar[1].tok.val}
+		}
+	case 60:
+		boosdDollar = boosdS[boosdpt-1 : boosdpt+1]
+//line parse.y:348
+		{
+			boosdVAL.exprs = make([]Expr, 1, 16)
+			boosdVAL.exprs[0] = boosdDollar[1].expr
+		}
+	case 61:
+		boosdDollar = boosdS[boosdpt-3 : boosdpt+1]
+//line parse.y:353
+		{
+			boosdVAL.exprs = append(boosdDollar[1].exprs, boosdDollar[3].expr)
+		}
+	case 62:
+		boosdDollar = boosdS[boosdpt-3 : boosdpt+1]
+//line parse.y:359
+		{
+			boosdVAL.expr = &ListExpr{Elts: boosdDollar[2].exprs}
+		}
+	case 63:
+		boosdDollar = boosdS[boosdpt-3 : boosdpt+1]
+//line parse.y:365
+		{
+			boosdVAL.expr = &TableExpr{Pairs: boosdDollar[2].pexprs}
+		}
+	case 64:
+		boosdDollar = boosdS[boosdpt-1 : boosdpt+1]
+//line parse.y:371
 		{
 			boosdVAL.pexprs = make([]*PairExpr, 1, 8)
-			pe, ok := boosdS[boosdpt-0].expr.(*PairExpr)
+			pe, ok := boosdDollar[1].expr.(*PairExpr)
 			if !ok {
-				panic(fmt.Sprintf("not PairExpr 1: %#v", boosdS[boosdpt-0].expr))
+				panic(fmt.Sprintf("not PairExpr 1: %#v", boosdDollar[1].expr))
 			}
 			boosdVAL.pexprs[0] = pe
 		}
-	case 54:
-		//line parse.y:327
+	case 65:
+		boosdDollar = boosdS[boosdpt-3 : boosdpt+1]
+//line parse.y:380
 		{
-			pe, ok := boosdS[boosdpt-0].expr.(*PairExpr)
+			pe, ok := boosdDollar[3].expr.(*PairExpr)
 			if !ok {
-				panic(fmt.Sprintf("not PairExpr 1: %#v", boosdS[boosdpt-0].expr))
+				panic(fmt.Sprintf("not PairExpr 1: %#v", boosdDollar[3].expr))
 			}
-			boosdVAL.pexprs = append(boosdS[boosdpt-2].pexprs, pe)
+			boosdVAL.pexprs = append(boosdDollar[1].pexprs, pe)
 		}
-	case 55:
-		//line parse.y:337
+	case 66:
+		boosdDollar = boosdS[boosdpt-5 : boosdpt+1]
+//line parse.y:390
 		{
-			boosdVAL.expr = &PairExpr{boosdS[boosdpt-3].expr, boosdS[boosdpt-1].expr}
+			boosdVAL.expr = &PairExpr{boosdDollar[2].expr, boosdDollar[4].expr}
 		}
 	}
 	goto boosdstack /* stack new state and value */
@@ -43,7 +43,7 @@ import (
 %type <tok>    top_type
 %type <block>  stmts
 %type <stmt>   stmt
-%type <expr>   expr number pair table expr_w_unit opt_kind initializer assignment ref
+%type <expr>   expr number pair table list expr_w_unit opt_kind initializer assignment ref
 %type <exprs>  expr_list initializers
 %type <pexprs> pairs
 %type <decl>   var_decl
@@ -55,7 +55,11 @@ import (
 %token <tok> YIMPORT YKIND YKIND_DECL YPACKAGE
 %token <tok> YSPECIALIZES YINTERFACE YMODEL
 %token <tok> YIDENT YLITERAL YNUMBER
+%token <tok> YLE YGE YEQ YNE YAND YOR
 
+%left YOR
+%left YAND
+%nonassoc YEQ YNE '<' '>' YLE YGE
 %left '+'  '-'
 %left '*'  '/'
 %left '^'
@@ -164,9 +168,11 @@ stmt:	var_decl ';'
 	{
 		$$ = &DeclStmt{$1}
 	}
-|	var_decl assignment ';'
+|	var_decl '=' assignment ';'
 	{
-		$$ = &AssignStmt{Lhs:$1, Rhs:$2}
+		l := boosdlex.(*boosdLex)
+		$$ = &AssignStmt{Lhs:$1, TokPos:$<tok>2.pos-1, Rhs:$3,
+			Eqn:l.text($<tok>2.pos, $<tok>4.pos)}
 	}
 ;
 
@@ -181,21 +187,17 @@ var_decl:	ident opt_kind
 	}
 ;
 
-assignment: '=' '{' initializers '}'
+assignment: '{' initializers '}'
 	{
-		$$ = &CompositeLit{Type:NewIdent("stock"), Elts:$3}
+		$$ = &CompositeLit{Type:NewIdent("stock"), Elts:$2}
 	}
-|	'=' ident '{' initializers '}'
+|	ident '{' initializers '}'
 	{
-		$$ = &CompositeLit{Type:$2, Elts:$4}
+		$$ = &CompositeLit{Type:$1, Elts:$3}
 	}
-|	'=' expr_w_unit
+|	expr_w_unit
 	{
-		$$ = $2
-	}
-|	'=' lit
-	{
-		$$ = $2
+		$$ = $1
 	}
 ;
 
@@ -244,6 +246,38 @@ expr:	'(' expr ')'
 	{
 		$$ = &BinaryExpr{X:$1, Y:$3, Op:token.XOR}
 	}
+|	expr '<' expr
+	{
+		$$ = &BinaryExpr{X:$1, Y:$3, Op:token.LSS}
+	}
+|	expr '>' expr
+	{
+		$$ = &BinaryExpr{X:$1, Y:$3, Op:token.GTR}
+	}
+|	expr YLE expr
+	{
+		$$ = &BinaryExpr{X:$1, Y:$3, Op:token.LEQ}
+	}
+|	expr YGE expr
+	{
+		$$ = &BinaryExpr{X:$1, Y:$3, Op:token.GEQ}
+	}
+|	expr YEQ expr
+	{
+		$$ = &BinaryExpr{X:$1, Y:$3, Op:token.EQL}
+	}
+|	expr YNE expr
+	{
+		$$ = &BinaryExpr{X:$1, Y:$3, Op:token.NEQ}
+	}
+|	expr YAND expr
+	{
+		$$ = &BinaryExpr{X:$1, Y:$3, Op:token.LAND}
+	}
+|	expr YOR expr
+	{
+		$$ = &BinaryExpr{X:$1, Y:$3, Op:token.LOR}
+	}
 |	'-' expr %prec UMINUS
 	{
 		$$ = &UnaryExpr{X:$2, Op:token.SUB}
@@ -260,10 +294,18 @@ expr:	'(' expr ')'
 	{
 		$$ = &IndexExpr{X:$1, Index:$3}
 	}
+|	ident '[' expr ',' expr_list ']' %prec FN_CALL
+	{
+		$$ = &IndexListExpr{X:$1, Indices:append([]Expr{$3}, $5...)}
+	}
 |	table
 	{
 		$$ = $1
 	}
+|	list
+	{
+		$$ = $1
+	}
 |	ref
 	{
 		$$ = $1
@@ -272,6 +314,10 @@ expr:	'(' expr ')'
 	{
 		$$ = $1
 	}
+|	lit
+	{
+		$$ = $1
+	}
 ;
 
 ref: ident
@@ -281,7 +327,8 @@ ref: ident
 
 ident:	YIDENT
 	{
-		$$ = &Ident{Name:$1.val}
+		// token positions are of their end
+		$$ = &Ident{NamePos:$1.pos-token.Pos(len($1.val)), Name:$1.val}
 	}
 ;
 
@@ -308,6 +355,12 @@ expr_list: expr
 	}
 ;
 
+list:	'[' expr_list ']'
+	{
+		$$ = &ListExpr{Elts: $2}
+	}
+;
+
 table:	'[' pairs ']'
 	{
 		$$ = &TableExpr{Pairs: $2}
@@ -346,10 +399,17 @@ func Parse(f *token.File, str string) (*File, error) {
 	// result object, there isn't another good way to keep the
 	// parser and lexer reentrant.
 	result := &File{}
-	err := boosdParse(newBoosdLex(str, f, result))
+	l := newBoosdLex(str, f, result)
+	err := boosdParse(l)
 	if err != 0 {
 		return nil, fmt.Errorf("%d parse errors", err)
 	}
+	result.Filename = f.Name()
+	result.Comments = l.comments
+	l.attachDocs(result)
+	if err := checkDecls(f, result); err != nil {
+		return nil, err
+	}
 
 	return result, nil
 }
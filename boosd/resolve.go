@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"go/token"
 	"strconv"
+	"strings"
 )
 
 type pkgBuilder struct {
@@ -40,6 +41,32 @@ func (p *pkgBuilder) declare(scope, altScope *Scope, obj *Object) {
 	}
 }
 
+// predeclared reports whether name is one of the identifiers the
+// language predeclares: a simulation-time intrinsic, like time or
+// dt, or a builtin function, like smooth or previous.  Like the
+// builtins themselves, these are matched without regard to case, and
+// can't be redeclared by a model.
+func predeclared(name string) bool {
+	name = strings.ToLower(name)
+	_, isIntrinsic := intrinsics[name]
+	_, isBuiltin := builtinFuncs[name]
+	return isIntrinsic || isBuiltin
+}
+
+// checkDecls reports each variable declared in f with a predeclared
+// name, at its position in tf, the file f was parsed from.
+func checkDecls(tf *token.File, f *File) error {
+	var errs ErrorVector
+	Inspect(f, func(n Node) bool {
+		if d, ok := n.(*VarDecl); ok && predeclared(d.Name.Name) {
+			errs.Error(tf.Position(d.Name.Pos()),
+				fmt.Sprintf("%s is predeclared and can't be redeclared; rename the variable", d.Name.Name))
+		}
+		return true
+	})
+	return errs.GetError(Sorted)
+}
+
 func resolve(scope *Scope, ident *Ident) bool {
 	for ; scope != nil; scope = scope.Outer {
 		if obj := scope.Lookup(ident.Name); obj != nil {
@@ -97,6 +97,9 @@ func Walk(v Visitor, node Node) {
 	case *TableExpr:
 		walkPairExprList(v, n.Pairs)
 
+	case *ListExpr:
+		walkExprList(v, n.Elts)
+
 	case *PairExpr:
 		Walk(v, n.X)
 		Walk(v, n.Y)
@@ -112,6 +115,10 @@ func Walk(v Visitor, node Node) {
 		Walk(v, n.X)
 		Walk(v, n.Index)
 
+	case *IndexListExpr:
+		Walk(v, n.X)
+		walkExprList(v, n.Indices)
+
 	case *CallExpr:
 		Walk(v, n.Fun)
 		walkExprList(v, n.Args)
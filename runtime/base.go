@@ -5,7 +5,10 @@
 package runtime
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"strings"
 )
 
 type Timespec struct {
@@ -15,49 +18,14 @@ type Timespec struct {
 	SaveStep float64
 }
 
-// TODO: define useful methods on table
-type Table [2][]float64
-type Data map[string]float64
+// Data is the state of a sim at one point in time, indexed by
+// slot.  Slot 0 is always time.
+type Data []float64
+
 type ModelMap map[string]map[string]string
 type VarMap map[string]Var
 type DefaultMap map[string]float64
 
-func (t Table) Lookup(index float64) float64 {
-	size := len(t[0])
-	if size == 0 {
-		return 0
-	}
-
-	x := t[0]
-	y := t[1]
-
-	if index < x[0] {
-		return y[0]
-	} else if index > x[size-1] {
-		return y[size-1]
-	}
-
-	// binary search
-	low, mid, high := 0, 0, size
-	for low < high {
-		mid = low + (high-low)/2
-		if x[mid] < index {
-			low = mid + 1
-		} else {
-			high = mid
-		}
-	}
-
-	// at this point low == high, so use 'i' for readability
-	i := low
-	if x[i] == index {
-		return y[i]
-	}
-
-	slope := (y[i] - y[i-1]) / (x[i] - x[i-1])
-	return (index-x[i-1])*slope + y[i-1]
-}
-
 type BaseSim struct {
 	Parent Model
 	Time   Timespec
@@ -68,21 +36,93 @@ type BaseSim struct {
 	VarNames     map[string]string
 	SubSims      map[string]BaseSim
 
-	Series []Data
+	// Results holds the values saved every SaveStep, unless
+	// they are streamed to a ResultSink given in the sim's
+	// Options.
+	Results *Results
 
-	Tables map[string]Table
-	Curr   Data
-	Next   Data
-
-	timeSeries []float64
+	Tables   map[string]Table
+	Tables2D map[string]Table2D
+
+	// Slots maps variable names to their index in Curr and Next,
+	// which are swapped at the end of each time step.
+	Slots map[string]int
+	Curr  Data
+	Next  Data
+
+	// last holds the values of the most recently computed time
+	// step, as Curr only has the stocks of the step to come.
+	last Data
+
+	// flows holds, by slot, the flow slots of stocks whose flows
+	// are limited.
+	flows []*stockFlows
+
+	// per-instance state for conveyor and queue stocks, created
+	// by CalcInitial.
+	Conveyors map[string]*Conveyor
+	Queues    map[string]*Queue
+
+	// Rand is the source of randomness for the random builtins.
+	Rand *Rand
+
+	// overrides are this sim's values for model constants,
+	// and err any problem with the sim's Options, which are
+	// kept for forks.
+	overrides map[string]float64
+	err       error
+	opts      *Options
+
+	// initial are stock values set before the sim starts, which
+	// replace the stocks' initial values.
+	initial map[int]float64
+
+	// events are the sim's scheduled events, if any.
+	events *Schedule
+
+	// stopWhen ends the run early once it is true, and stopped
+	// records when it did.
+	stopWhen *Condition
+	stopped  *Stop
+
+	// check is true if each step is checked for non-finite
+	// values, and progress is called every PollEvery steps.
+	check    bool
+	progress func(t, frac float64)
+
+	// integrator takes the time steps for Runge-Kutta methods,
+	// and is nil for Euler.  Steps are step long, and clock
+	// tracks the one being taken.
+	method     string
+	integrator Integrator
+	step       float64
+	clock      Clock
+
+	sink     ResultSink
+	patterns []string
+	saved    []int
+	row      []float64
+	begun    bool
+	ended    bool
 
 	saveEvery int64
 	stepNum   int64
 
+	// Initializing is true during the first evaluation of the
+	// flows, when builtins like smooth and delay set the initial
+	// values of their internal stocks.
+	Initializing bool
+
 	// Calc{Flows,Stocks} are used by the RunTo* functions
 	CalcInitial func(dt float64)
 	CalcFlows   func(dt float64)
 	CalcStocks  func(dt float64)
+
+	// ConstsChanged, if set, is called when the sim's values for
+	// model constants change after Init: when SetValue sets one,
+	// and on Restore.  Sims that cache constants refresh them
+	// here.
+	ConstsChanged func()
 }
 
 // max returns the int64 max of two numbers
@@ -94,75 +134,466 @@ func max(a, b int64) int64 {
 	}
 }
 
-func (s *BaseSim) Init(m Model, ts Timespec, tables map[string]Table) {
+// Init prepares s to run the model m, whose slots and tables are
+// given by base.
+func (s *BaseSim) Init(m Model, base *BaseModel, ts Timespec, opts *Options) {
 	s.Parent = m
+	s.opts = opts
+
+	seed := int64(DefaultSeed)
+	if opts != nil && opts.Seed != nil {
+		seed = *opts.Seed
+	}
+	s.Rand = NewRand(seed)
+
+	// bad overrides are reported by RunTo, as NewSim can't
+	// return an error.
+	if opts != nil && opts.Timespec != nil {
+		if t, err := ts.Override(opts.Timespec); err != nil {
+			s.err = err
+		} else {
+			ts = t
+		}
+	}
+	if opts != nil && s.err == nil {
+		s.overrides, s.err = ResolveOverrides(m, s.InstanceName, opts.Set)
+	}
+	if opts != nil && s.err == nil {
+		s.events, s.err = NewSchedule(opts.Events, m, s.InstanceName)
+	}
+	if s.err == nil {
+		s.stopWhen, s.err = StopCondition(m, s.InstanceName, opts)
+	}
+	if err := ts.Check(); err != nil && s.err == nil {
+		s.err = err
+	}
 	s.Time = ts
 
-	if ts.SaveStep == 0 {
-		panic("can't have 0 SaveStep")
+	if opts != nil {
+		s.patterns = opts.Save
+		s.check = opts.CheckFinite
+		s.progress = opts.Progress
+	}
+	if opts != nil && opts.Sink != nil {
+		s.sink = opts.Sink
+	} else {
+		s.Results = NewResults(ts.Rows())
+		s.sink = s.Results
 	}
 
-	capSeries := int((ts.End-ts.Start)/ts.SaveStep) + 1
-	s.Series = make([]Data, 0, capSeries)
-	s.timeSeries = make([]float64, 0, capSeries)
+	s.Tables = base.Tables
+	s.Tables2D = base.Tables2D
 
-	s.Tables = tables
+	s.Slots = base.Slots
+	s.Curr = make(Data, len(base.Slots))
+	s.Next = make(Data, len(base.Slots))
+	s.last = make(Data, len(base.Slots))
+	s.flows = stockFlowSlots(base)
+
+	s.method = Euler
+	if s.err == nil {
+		if s.method, s.err = Method(m, opts); s.err == nil {
+			s.integrator, s.err = NewIntegrator(s.method, m, s.Slots, ts, opts)
+		}
+	}
+	s.step = StepSize(s.method, ts)
 
 	// round to the nearest integer, but make sure we're non-zero
-	s.saveEvery = max(int64(ts.SaveStep/ts.DT+.5), 1)
+	s.saveEvery = max(int64(ts.SaveStep/s.step+.5), 1)
+
+	s.Conveyors = map[string]*Conveyor{}
+	s.Queues = map[string]*Queue{}
+
+	s.Curr[0] = ts.Start
+}
 
-	s.Curr = Data{}
-	s.Next = Data{}
+// Now returns the current simulation time.
+func (s *BaseSim) Now() float64 {
+	return s.Curr[0]
+}
 
-	s.Curr["time"] = ts.Start
+// Override returns the value this sim was given for the model
+// constant name, if any, in place of the model's default.
+func (s *BaseSim) Override(name string) (float64, bool) {
+	v, ok := s.overrides[name]
+	return v, ok
+}
+
+// Pulse implements the pulse builtin; see Clock.Pulse.
+func (s *BaseSim) Pulse(volume, first, interval float64) float64 {
+	return s.clock.Pulse(s.Time, volume, first, interval)
+}
+
+// Timespec returns the times s runs over.
+func (s *BaseSim) Timespec() Timespec {
+	return s.Time
 }
 
 func (s *BaseSim) Model() Model {
 	return s.Parent
 }
 
-// RunTo currently implements the Euler method
+// RunTo runs the sim until time t, with Euler's method or the
+// Runge-Kutta method given by the model or the sim's Options.
+// Adaptive methods step from one SaveStep to the next.
 func (s *BaseSim) RunTo(t float64) error {
-	if s.Curr["time"] == s.Time.Start {
+	return s.RunToContext(context.Background(), t)
+}
+
+func (s *BaseSim) RunToContext(ctx context.Context, t float64) error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.Curr[0] == s.Time.Start {
 		s.CalcInitial(s.Time.DT)
+		for slot, v := range s.initial {
+			s.Curr[slot] = v
+		}
+		s.Initializing = true
 	}
 
-	for s.Curr["time"] <= t {
+	flows := func() {
 		s.CalcFlows(s.Time.DT)
+		s.Initializing = false
+	}
+	stocks := func() {
 		s.CalcStocks(s.Time.DT)
+	}
+	// Runge-Kutta methods evaluate the flows at every stage of a
+	// step, and adaptive ones again for each step they retry.
+	// Each evaluation draws the random numbers the first did, so
+	// that noise is drawn once per time step, as with Euler's
+	// method.
+	var randStart, randEnd uint64
+	var drawn bool
+	stageFlows := func() {
+		s.Rand.State = randStart
+		flows()
+		if !drawn {
+			randEnd, drawn = s.Rand.State, true
+		}
+	}
+
+	for s.Curr[0] <= t && s.stopped == nil {
+		if s.stepNum%PollEvery == 0 {
+			if err := Poll(ctx, s.progress, s.Time, s.Curr[0]); err != nil {
+				return err
+			}
+		}
+		if s.events != nil {
+			if err := s.events.Run(s, s.Curr[0], s.step); err != nil {
+				return err
+			}
+		}
+		s.clock.Begin(s.Curr[0])
+		if s.integrator != nil {
+			randStart, drawn = s.Rand.State, false
+			if err := s.integrator.Step(s.Curr, s.Next, s.step, &s.clock, stageFlows, stocks); err != nil {
+				return err
+			}
+			s.Rand.State = randEnd
+		} else {
+			flows()
+			stocks()
+		}
+		if s.check {
+			if err := CheckFinite(s.Parent, s.InstanceName, s.Slots, s.Curr, s.Next); err != nil {
+				return err
+			}
+		}
+		stop, err := Stopped(s.stopWhen, s.Slots, s.Curr)
+		if err != nil {
+			return err
+		}
 
-		if s.stepNum%s.saveEvery == 0 {
-			s.timeSeries = append(s.timeSeries, s.Curr["time"])
-			s.Series = append(s.Series, s.Curr)
+		if s.stepNum%s.saveEvery == 0 || stop != nil {
+			if err := s.save(); err != nil {
+				return err
+			}
 		}
 		s.stepNum++
+		s.stopped = stop
+
+		s.Next[0] = s.Curr[0] + s.step
+		s.last, s.Curr, s.Next = s.Curr, s.Next, s.last
+		// variables are only carried over to the next step if
+		// they are written to Next, like stocks.
+		for i := range s.Next {
+			s.Next[i] = 0
+		}
+	}
 
-		s.Next["time"] = s.Curr["time"] + s.Time.DT
-		s.Curr = s.Next
-		s.Next = Data{}
+	if (s.Curr[0] > s.Time.End || s.stopped != nil) && s.begun && !s.ended {
+		s.ended = true
+		if s.progress != nil {
+			end := s.Time.End
+			if s.stopped != nil {
+				end = s.stopped.Time
+			}
+			s.progress(end, 1)
+		}
+		return s.sink.End()
 	}
 	return nil
 }
 
+// columns sets the slots the sim saves, and returns their names.
+func (s *BaseSim) columns() ([]string, error) {
+	var err error
+	if s.saved, err = SaveSlots(s.patterns, s.InstanceName, s.Slots); err != nil {
+		return nil, err
+	}
+	names := SlotNames(s.Slots)
+	cols := make([]string, len(s.saved))
+	for i, slot := range s.saved {
+		cols[i] = names[slot]
+	}
+	s.row = make([]float64, len(s.saved))
+	return cols, nil
+}
+
+// save sends the saved variables' current values to the sim's
+// sink.
+func (s *BaseSim) save() error {
+	if !s.begun {
+		cols, err := s.columns()
+		if err != nil {
+			return err
+		}
+		s.begun = true
+		if err := s.sink.Begin(cols); err != nil {
+			return err
+		}
+	}
+	for i, slot := range s.saved {
+		s.row[i] = s.Curr[slot]
+	}
+	return s.sink.Row(s.row)
+}
+
 func (s *BaseSim) RunToEnd() error {
-	return s.RunTo(s.Time.End + .5*s.Time.DT)
+	return s.RunToEndContext(context.Background())
+}
+
+func (s *BaseSim) RunToEndContext(ctx context.Context) error {
+	return s.RunToContext(ctx, s.Time.End+.5*s.step)
 }
 
+// Checkpoint writes the state of s to w, so that a new sim of the
+// same model can resume the run from here with Restore.
+func (s *BaseSim) Checkpoint(w io.Writer) error {
+	if s.err != nil {
+		return s.err
+	}
+	cp := &Checkpoint{
+		Model:     s.Parent.Name(),
+		Hash:      StructureHash(s.Parent, s.Slots),
+		Time:      s.Time,
+		Method:    s.method,
+		StepNum:   s.stepNum,
+		Ended:     s.ended,
+		Stopped:   s.stopped,
+		Curr:      s.Curr,
+		Last:      s.last,
+		Initial:   s.initial,
+		Overrides: s.overrides,
+		Rand:      s.Rand.State,
+		Conveyors: s.Conveyors,
+		Queues:    s.Queues,
+	}
+	if s.integrator != nil {
+		cp.Integrator = s.integrator.State()
+	}
+	if s.events != nil {
+		st := s.events.State()
+		cp.Events = &st
+	}
+	if s.Results != nil && s.begun {
+		cp.Names, cp.Cols = s.Results.Names, s.Results.Cols
+	}
+	return WriteCheckpoint(w, cp)
+}
+
+// Restore replaces the state of s, which must not have started,
+// with that of the checkpoint read from r.  s keeps its own
+// timespec, which must have the checkpoint's save grid; see
+// Checkpoint.Check.  The checkpoint's results
+// are kept if s stores its results, and it must then save the same
+// variables; a sink given in the sim's Options only gets the rows
+// saved after the checkpoint.
+func (s *BaseSim) Restore(r io.Reader) error {
+	if s.err != nil {
+		return s.err
+	}
+	cp, err := ReadCheckpoint(r, s.Parent, s.Slots)
+	if err != nil {
+		return err
+	}
+	if err := cp.Check(s.method, s.Time); err != nil {
+		return err
+	}
+
+	if s.integrator != nil {
+		s.integrator.SetState(cp.Integrator)
+	}
+	s.stepNum = cp.StepNum
+	s.ended = cp.Ended
+	s.stopped = cp.Stopped
+	copy(s.Curr, cp.Curr)
+	copy(s.last, cp.Last)
+	s.initial = cp.Initial
+	s.overrides = cp.Overrides
+	if s.ConstsChanged != nil {
+		s.ConstsChanged()
+	}
+	s.Rand.State = cp.Rand
+	s.Conveyors = cp.Conveyors
+	s.Queues = cp.Queues
+	if s.Conveyors == nil {
+		s.Conveyors = map[string]*Conveyor{}
+	}
+	if s.Queues == nil {
+		s.Queues = map[string]*Queue{}
+	}
+	s.Initializing = false
+	if err := cp.RestoreEvents(s.events); err != nil {
+		return err
+	}
+
+	s.begun = false
+	if s.Results != nil && s.sink == s.Results && cp.Names != nil {
+		cols, err := s.columns()
+		if err != nil {
+			return err
+		}
+		if err := cp.RestoreResults(s.Results, cols); err != nil {
+			return err
+		}
+		s.begun = true
+	}
+	return nil
+}
+
+func (s *BaseSim) Fork() (Sim, error) {
+	return Fork(s, s.Parent, s.InstanceName, s.Coord, s.opts)
+}
+
+// Events returns the events that have happened so far.
+func (s *BaseSim) Events() []FiredEvent {
+	return s.events.Fired()
+}
+
+// Stopped returns when and why the run ended early, or nil if it
+// hasn't.
+func (s *BaseSim) Stopped() *Stop {
+	return s.stopped
+}
+
+// Stats returns the steps s has taken so far.
+func (s *BaseSim) Stats() Stats {
+	if s.integrator != nil {
+		return s.integrator.Stats()
+	}
+	return Stats{Accepted: s.stepNum}
+}
+
+// unqualify returns name without the sim's instance name, if it
+// is qualified by it.
+func (s *BaseSim) unqualify(name string) string {
+	return strings.TrimPrefix(name, s.InstanceName+".")
+}
+
+// isStock returns true if the variable name carries its value from
+// one time step to the next.
+func isStock(m Model, name string) bool {
+	v, ok := m.Var(name)
+	return name == "time" || (ok && v.Type == TyStock)
+}
+
+// Value returns the current value of the variable name, which may
+// be qualified with the sim's instance name.  Stocks and time have
+// the values the next time step starts from; other variables have
+// the values computed in the last time step.  Before the sim has
+// run, constants and stocks with constant initial values have
+// their defaults, unless they've been set.
 func (s *BaseSim) Value(name string) (v float64, err error) {
-	err = fmt.Errorf("unknown var %s", name)
-	return
+	name = s.unqualify(name)
+	slot, ok := s.Slots[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown var %s", name)
+	}
+	if s.stepNum == 0 {
+		if v, ok := s.Override(name); ok {
+			return v, nil
+		}
+		if _, ok := s.initial[slot]; ok {
+			return s.Curr[slot], nil
+		}
+		if v, ok := s.Parent.Default(name); ok {
+			return v, nil
+		}
+	}
+	if s.stepNum == 0 || isStock(s.Parent, name) {
+		return s.Curr[slot], nil
+	}
+	return s.last[slot], nil
 }
 
 func (s *BaseSim) ValueSeries(name string) (r [2][]float64, err error) {
-	r[0] = s.timeSeries
-	r[1] = make([]float64, len(s.Series))
-	for i, d := range s.Series {
-		r[1][i] = d[name]
+	name = s.unqualify(name)
+	if _, ok := s.Slots[name]; !ok {
+		err = fmt.Errorf("unknown var %s", name)
+		return
 	}
-	return
+	if s.Results == nil {
+		err = fmt.Errorf("results for %s were sent to a sink", name)
+		return
+	}
+	if _, ok := s.Results.Column(name); s.begun && !ok {
+		err = fmt.Errorf("%s not saved", name)
+		return
+	}
+	return s.Results.Series(name), nil
 }
 
+// SetValue sets the variable name, which may be qualified with the
+// sim's instance name, to val.  Constants keep the new value for
+// the rest of the run.  Stocks take it as their value from the next
+// time step, or as their initial value if the sim hasn't started.
+// Other variables are computed from their equations, and can't be
+// set.
 func (s *BaseSim) SetValue(name string, val float64) error {
+	name = s.unqualify(name)
+	v, ok := s.Parent.Var(name)
+	if !ok {
+		return fmt.Errorf("unknown var %s", name)
+	}
+
+	if v.Type == TyStock {
+		if v.Flavor != StockReservoir {
+			return fmt.Errorf("%s is a %s, whose contents can't be set", name, v.Flavor.Name())
+		}
+		slot := s.Slots[name]
+		if s.stepNum == 0 {
+			if s.initial == nil {
+				s.initial = map[int]float64{}
+			}
+			s.initial[slot] = val
+		}
+		s.Curr[slot] = val
+		return nil
+	}
+
+	if _, ok := s.Parent.Default(name); !ok {
+		return fmt.Errorf("%s is computed by its equation, and can't be set", name)
+	}
+	if s.overrides == nil {
+		s.overrides = map[string]float64{}
+	}
+	s.overrides[name] = val
+	if s.ConstsChanged != nil {
+		s.ConstsChanged()
+	}
 	return nil
 }
 
@@ -171,6 +602,12 @@ type BaseModel struct {
 	Vars     VarMap
 	Defaults DefaultMap
 	Tables   map[string]Table
+	Tables2D map[string]Table2D
+	Attrs    map[string]interface{}
+
+	// Slots gives the index of each variable in a sim's Data.
+	// Slot 0 is always time.
+	Slots map[string]int
 }
 
 func (m *BaseModel) Default(name string) (v float64, ok bool) {
@@ -182,8 +619,11 @@ func (m *BaseModel) Name() string {
 	return m.MName
 }
 
+// Attr returns the model attribute name, or nil if it isn't set.
+// The compiler sets "stochastic" to true for models that use the
+// random builtins.
 func (m *BaseModel) Attr(name string) interface{} {
-	return nil
+	return m.Attrs[name]
 }
 
 func (m *BaseModel) Var(name string) (Var, bool) {
@@ -199,8 +639,46 @@ func (m *BaseModel) VarNames() []string {
 	return names
 }
 
+// VarInfo describes the variable name, or returns nil if there is
+// no such variable.  The keys are name, type (as declared in
+// models, like "stock"), units, equation, doc and deps, along with
+// inflows, outflows and flavor for stocks that have them.  Keys
+// whose values would be empty are left out.
 func (m *BaseModel) VarInfo(name string) map[string]interface{} {
-	return nil
+	v, ok := m.Vars[name]
+	if !ok {
+		return nil
+	}
+	info := map[string]interface{}{
+		"name": v.Name,
+		"type": v.Type.String(),
+	}
+	for n, ty := range tyNames {
+		if ty == v.Type {
+			info["type"] = n
+		}
+	}
+	set := func(key, val string) {
+		if val != "" {
+			info[key] = val
+		}
+	}
+	set("units", v.Units)
+	set("equation", v.Eqn)
+	set("doc", v.Doc)
+	if len(v.Deps) > 0 {
+		info["deps"] = v.Deps
+	}
+	if len(v.Inflows) > 0 {
+		info["inflows"] = v.Inflows
+	}
+	if len(v.Outflows) > 0 {
+		info["outflows"] = v.Outflows
+	}
+	if v.Flavor != StockReservoir {
+		info["flavor"] = v.Flavor.Name()
+	}
+	return info
 }
 
 type VarType int
@@ -215,8 +693,41 @@ const (
 )
 
 type Var struct {
-	Name string
-	Type VarType
+	Name  string
+	Type  VarType
+	Units string
+
+	// Inflows and Outflows name every flow attached to a stock,
+	// in declaration order.  biflows are recorded as inflows, as
+	// positive values add to the stock.  Both are nil for
+	// non-stocks.
+	Inflows  []string
+	Outflows []string
+
+	// NonNegative stocks have their outflows scaled back so that
+	// they never drop below zero.  See BaseSim.LimitOutflows.
+	NonNegative bool
+
+	// Flavor distinguishes conveyors and queues from ordinary,
+	// well-mixed stocks.  Capacity, if non-zero, is the most a
+	// conveyor or queue can hold; see BaseSim.LimitInflows.
+	Flavor   StockFlavor
+	Capacity float64
+
+	// Uniflow flows are clamped so they never take negative
+	// values.
+	Uniflow bool
+
+	// Internal is true for variables synthesized by the compiler
+	// rather than declared in the model, such as flows written
+	// inline in a stock's initializer.
+	Internal bool
+
+	// Eqn is the source of the variable's equation and Doc its
+	// doc comment.  Deps are the names the equation refers to.
+	Eqn  string
+	Doc  string
+	Deps []string
 }
 
 var tyPretty = map[VarType]string{
@@ -0,0 +1,117 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// A testModel is a model whose equations are Go functions, so that
+// sims can be tested without the compiler.  The functions are given
+// the sim and a function returning slots by name.
+type testModel struct {
+	BaseModel
+	time Timespec
+
+	initial func(s *BaseSim, slot func(string) int)
+	flows   func(s *BaseSim, slot func(string) int, dt float64)
+	stocks  func(s *BaseSim, slot func(string) int, dt float64)
+}
+
+// newTestModel returns a model of vars, with slots given in name
+// order after time.
+func newTestModel(ts Timespec, vars ...Var) *testModel {
+	m := &testModel{time: ts}
+	m.MName = "test"
+	m.Vars = VarMap{}
+	m.Defaults = DefaultMap{}
+	m.Attrs = map[string]interface{}{}
+	m.Slots = map[string]int{"time": 0}
+	var names []string
+	for _, v := range vars {
+		m.Vars[v.Name] = v
+		names = append(names, v.Name)
+	}
+	sort.Strings(names)
+	for i, n := range names {
+		m.Slots[n] = i + 1
+	}
+	return m
+}
+
+func (m *testModel) NewSim(name string, c Coordinator, opts *Options) Sim {
+	s := &BaseSim{InstanceName: name, Coord: c}
+	s.Init(m, &m.BaseModel, m.time, opts)
+	slot := func(n string) int { return m.Slots[n] }
+	s.CalcInitial = func(dt float64) {
+		if m.initial != nil {
+			m.initial(s, slot)
+		}
+	}
+	s.CalcFlows = func(dt float64) {
+		if m.flows != nil {
+			m.flows(s, slot, dt)
+		}
+	}
+	s.CalcStocks = func(dt float64) {
+		if m.stocks != nil {
+			m.stocks(s, slot, dt)
+		}
+	}
+	return s
+}
+
+// constant returns the sim's value for the constant name, as the
+// coordinator gives generated sims.
+func constant(s *BaseSim, name string) float64 {
+	if v, ok := s.Override(name); ok {
+		return v
+	}
+	v, _ := s.Parent.Default(name)
+	return v
+}
+
+// newTestSim returns a sim of m, failing t if it can't run.
+func newTestSim(t *testing.T, m Model, opts *Options) *BaseSim {
+	s := m.NewSim("main", nil, opts).(*BaseSim)
+	if s.err != nil {
+		t.Fatalf("NewSim: %s", s.err)
+	}
+	return s
+}
+
+func TestSlotNames(t *testing.T) {
+	slots := map[string]int{"time": 0, "b": 2, "a": 1, "c": 3}
+	if got, want := SlotNames(slots), []string{"time", "a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SlotNames = %v, want %v", got, want)
+	}
+}
+
+// discard is a ResultSink that keeps nothing.
+type discard struct{}
+
+func (discard) Begin(names []string) error { return nil }
+func (discard) Row(values []float64) error { return nil }
+func (discard) End() error                 { return nil }
+
+// TestStepAllocs checks that the state of a sim is reused from one
+// time step to the next, so the number of steps doesn't change the
+// number of allocations.
+func TestStepAllocs(t *testing.T) {
+	allocs := func(end float64) float64 {
+		m := drainModel(Timespec{Start: 0, End: end, DT: 1, SaveStep: 1})
+		return testing.AllocsPerRun(10, func() {
+			s := newTestSim(t, m, &Options{Sink: discard{}})
+			if err := s.RunToEnd(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	if short, long := allocs(10), allocs(10000); long != short {
+		t.Errorf("%g allocations for 10 steps, but %g for 10000", short, long)
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"math"
+)
+
+// The functions in this file implement the stateless builtin
+// functions callable from model equations.
+
+func Abs(x float64) float64  { return math.Abs(x) }
+func Exp(x float64) float64  { return math.Exp(x) }
+func Ln(x float64) float64   { return math.Log(x) }
+func Sqrt(x float64) float64 { return math.Sqrt(x) }
+
+func Min(a, b float64) float64 { return math.Min(a, b) }
+func Max(a, b float64) float64 { return math.Max(a, b) }
+
+// The comparison and logical operators of equations, like those of
+// a model's stop_when, are true if not 0, and give 1 for true and 0
+// for false.
+
+func Less(a, b float64) float64      { return truth(a < b) }
+func LessEq(a, b float64) float64    { return truth(a <= b) }
+func Greater(a, b float64) float64   { return truth(a > b) }
+func GreaterEq(a, b float64) float64 { return truth(a >= b) }
+func Equal(a, b float64) float64     { return truth(a == b) }
+func NotEqual(a, b float64) float64  { return truth(a != b) }
+func And(a, b float64) float64       { return truth(a != 0 && b != 0) }
+func Or(a, b float64) float64        { return truth(a != 0 || b != 0) }
+
+func truth(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,174 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A NonFiniteError reports a variable whose value became NaN or
+// infinite, as found by CheckFinite.
+type NonFiniteError struct {
+	// Time is the time of the step the value was computed in,
+	// and Name the variable, qualified with the sim's instance
+	// name.
+	Time  float64
+	Name  string
+	Value float64
+
+	// Eqn is the variable's equation, and Inputs the values of
+	// the variables it refers to, by name.  A stock's inputs are
+	// its own value and its flows.
+	Eqn    string
+	Inputs map[string]float64
+}
+
+func (e *NonFiniteError) Error() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s is %s at time %s", e.Name, strconv.FormatFloat(e.Value, 'g', -1, 64),
+		strconv.FormatFloat(e.Time, 'g', -1, 64))
+	if e.Eqn != "" {
+		fmt.Fprintf(&buf, ": %s = %s", e.Name[strings.LastIndex(e.Name, ".")+1:], e.Eqn)
+	}
+	names := make([]string, 0, len(e.Inputs))
+	for n := range e.Inputs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for i, n := range names {
+		if i == 0 {
+			buf.WriteString(", with ")
+		} else {
+			buf.WriteString(", ")
+		}
+		fmt.Fprintf(&buf, "%s = %s", n, strconv.FormatFloat(e.Inputs[n], 'g', -1, 64))
+	}
+	return buf.String()
+}
+
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// inputs returns the names of the variables v is computed from.
+func inputs(v Var) []string {
+	names := append([]string{}, v.Deps...)
+	if v.Type == TyStock {
+		names = append(names, v.Name)
+		names = append(names, v.Inflows...)
+		names = append(names, v.Outflows...)
+	}
+	return names
+}
+
+// CheckFinite returns a *NonFiniteError if a variable of the sim
+// instance of m isn't a finite number after a time step: any of
+// curr, or a stock in next.  As a non-finite value spreads to
+// everything computed from it, the variable reported is the one it
+// starts at, whose own inputs are all finite.
+func CheckFinite(m Model, instance string, slots map[string]int, curr, next []float64) error {
+	ok := true
+	for i := 1; i < len(curr); i++ {
+		if !finite(curr[i]) || !finite(next[i]) {
+			ok = false
+			break
+		}
+	}
+	if ok {
+		return nil
+	}
+
+	var bad []string
+	for n, i := range slots {
+		if i != 0 && !finite(curr[i]) {
+			bad = append(bad, n)
+		}
+	}
+	sort.Strings(bad)
+	isBad := map[string]bool{}
+	for _, n := range bad {
+		isBad[n] = true
+	}
+	for _, n := range bad {
+		v, _ := m.Var(n)
+		root := true
+		for _, in := range inputs(v) {
+			if isBad[in] && in != n {
+				root = false
+				break
+			}
+		}
+		if root {
+			return nonFinite(m, instance, slots, curr, n, curr[slots[n]])
+		}
+	}
+	if len(bad) > 0 {
+		// every one depends on another, as in a cycle
+		// through stocks
+		return nonFinite(m, instance, slots, curr, bad[0], curr[slots[bad[0]]])
+	}
+
+	// everything this step is finite, so the stock's new value
+	// overflowed.
+	var stocks []string
+	for n, i := range slots {
+		if v, _ := m.Var(n); v.Type == TyStock && !finite(next[i]) {
+			stocks = append(stocks, n)
+		}
+	}
+	if len(stocks) > 0 {
+		sort.Strings(stocks)
+		return nonFinite(m, instance, slots, curr, stocks[0], next[slots[stocks[0]]])
+	}
+	return nil
+}
+
+func nonFinite(m Model, instance string, slots map[string]int, curr []float64, name string, val float64) error {
+	v, _ := m.Var(name)
+	e := &NonFiniteError{
+		Time:   curr[0],
+		Name:   instance + "." + displayName(m, name),
+		Value:  val,
+		Eqn:    v.Eqn,
+		Inputs: map[string]float64{},
+	}
+	for _, in := range inputs(v) {
+		if i, ok := slots[in]; ok {
+			e.Inputs[displayName(m, in)] = curr[i]
+		}
+	}
+	return e
+}
+
+// displayName returns the name errors give the variable name of m.
+// Flows written inline in a stock's initializer only have internal
+// names, so are named for the stock and the key they were given
+// with, like "tank outflow".
+func displayName(m Model, name string) string {
+	if v, ok := m.Var(name); !ok || !v.Internal || v.Type != TyFlow {
+		return name
+	}
+	stocks := m.VarNames()
+	sort.Strings(stocks)
+	for _, sn := range stocks {
+		sv, _ := m.Var(sn)
+		for _, f := range sv.Inflows {
+			if f == name {
+				return sn + " inflow"
+			}
+		}
+		for _, f := range sv.Outflows {
+			if f == name {
+				return sn + " outflow"
+			}
+		}
+	}
+	return name
+}
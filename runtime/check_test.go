@@ -0,0 +1,51 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCheckFinite(t *testing.T) {
+	m := newTestModel(stockTime,
+		Var{Name: "a", Type: TyAux, Eqn: "b * 2", Deps: []string{"b"}},
+		Var{Name: "b", Type: TyAux, Eqn: "1 / c", Deps: []string{"c"}},
+		Var{Name: "c", Type: TyAux},
+		Var{Name: "s", Type: TyStock, Inflows: []string{"a"}})
+	slot := m.Slots
+	inf := math.Inf(1)
+	for _, c := range []struct {
+		curr, next map[string]float64
+		want       string
+	}{
+		{nil, nil, ""},
+		// the variable reported is where it started
+		{map[string]float64{"a": inf, "b": inf}, nil,
+			"main.b is +Inf at time 2: b = 1 / c, with c = 0"},
+		{map[string]float64{"a": math.NaN(), "c": 4}, nil,
+			"main.a is NaN at time 2: a = b * 2, with b = 0"},
+		// a stock overflowing is found in next
+		{map[string]float64{"s": 1e308}, map[string]float64{"s": inf},
+			"main.s is +Inf at time 2, with a = 0, s = 1e+308"},
+	} {
+		curr := make([]float64, len(slot))
+		next := make([]float64, len(slot))
+		curr[0] = 2
+		for n, v := range c.curr {
+			curr[slot[n]] = v
+		}
+		for n, v := range c.next {
+			next[slot[n]] = v
+		}
+		got := ""
+		if err := CheckFinite(m, "main", slot, curr, next); err != nil {
+			got = err.Error()
+		}
+		if got != c.want {
+			t.Errorf("CheckFinite = %q, want %q", got, c.want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// CheckpointVersion is the version of the checkpoint format
+// written by WriteCheckpoint.  Checkpoints of other versions can't
+// be restored.
+const CheckpointVersion = 1
+
+// A Checkpoint is the state of a sim between time steps: enough to
+// resume the run in a new sim of the same model, in this process or
+// another, and with any of the model's backends.  Submodels' state
+// is in the sim's own slots, so is part of Curr and Last.
+type Checkpoint struct {
+	Version int
+	Model   string
+	// Hash is the StructureHash of the model, which the sim a
+	// checkpoint is restored to must match.
+	Hash string
+
+	Time       Timespec
+	Method     string
+	Integrator IntegratorState
+	StepNum    int64
+	Ended      bool
+	Stopped    *Stop
+
+	// Curr and Last are the sims' values by slot, and Initial
+	// the stock values set before the sim started.
+	Curr    []float64
+	Last    []float64
+	Initial map[int]float64
+
+	// Overrides are the values of constants that differ from
+	// the model's defaults.
+	Overrides map[string]float64
+
+	Rand      uint64
+	Conveyors map[string]*Conveyor
+	Queues    map[string]*Queue
+
+	// Events is the state of the sim's event schedule, if it
+	// has one.
+	Events *ScheduleState
+
+	// Names and Cols are the results saved so far, unless they
+	// were streamed to a sink.
+	Names []string
+	Cols  [][]float64
+}
+
+// StructureHash returns a hash of the variables of m and their
+// slots: everything a checkpoint's state is laid out by.  It
+// doesn't cover equations, so a checkpoint can be restored to a
+// model whose equations have been changed.
+func StructureHash(m Model, slots map[string]int) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", m.Name())
+	for i, n := range SlotNames(slots) {
+		v, _ := m.Var(n)
+		fmt.Fprintf(h, "%d %s %s %s %t %v %v\n", i, n, v.Type, v.Flavor.Name(),
+			v.NonNegative, v.Inflows, v.Outflows)
+	}
+	names := m.VarNames()
+	sort.Strings(names)
+	for _, n := range names {
+		if v, _ := m.Var(n); v.Type == TyTable {
+			fmt.Fprintf(h, "table %s\n", n)
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)[:16])
+}
+
+// WriteCheckpoint writes cp to w, with the current version.
+func WriteCheckpoint(w io.Writer, cp *Checkpoint) error {
+	cp.Version = CheckpointVersion
+	return gob.NewEncoder(w).Encode(cp)
+}
+
+// ReadCheckpoint reads a checkpoint from r, and checks that it is
+// of the current version and was taken of a sim of m, with the
+// given slots.
+func ReadCheckpoint(r io.Reader, m Model, slots map[string]int) (*Checkpoint, error) {
+	cp := new(Checkpoint)
+	if err := gob.NewDecoder(r).Decode(cp); err != nil {
+		return nil, fmt.Errorf("reading checkpoint: %s", err)
+	}
+	if cp.Version != CheckpointVersion {
+		return nil, fmt.Errorf("checkpoint is version %d, not %d", cp.Version, CheckpointVersion)
+	}
+	if cp.Model != m.Name() {
+		return nil, fmt.Errorf("checkpoint is of model %s, not %s", cp.Model, m.Name())
+	}
+	if cp.Hash != StructureHash(m, slots) {
+		return nil, fmt.Errorf("checkpoint of model %s doesn't match its structure", cp.Model)
+	}
+	if len(cp.Curr) != len(slots) || len(cp.Last) != len(slots) {
+		return nil, fmt.Errorf("checkpoint has %d slots, not %d", len(cp.Curr), len(slots))
+	}
+	return cp, nil
+}
+
+// RestoreResults replaces the rows of r with the results saved in
+// cp, whose columns must be names.
+func (cp *Checkpoint) RestoreResults(r *Results, names []string) error {
+	if len(names) != len(cp.Names) {
+		return fmt.Errorf("checkpoint saved %d variables, not %d", len(cp.Names), len(names))
+	}
+	for i, n := range names {
+		if cp.Names[i] != n {
+			return fmt.Errorf("checkpoint saved %s, not %s", cp.Names[i], n)
+		}
+	}
+	if err := r.Begin(names); err != nil {
+		return err
+	}
+	for i, col := range cp.Cols {
+		r.Cols[i] = append(r.Cols[i], col...)
+	}
+	return nil
+}
+
+// RestoreEvents restores the state of the event schedule sc from
+// cp, if both have events.
+func (cp *Checkpoint) RestoreEvents(sc *Schedule) error {
+	if sc == nil || cp.Events == nil {
+		return nil
+	}
+	return sc.SetState(*cp.Events)
+}
+
+// Check returns an error if cp can't be restored to a sim that
+// integrates with method over ts.  The sim must take the same steps
+// and save results at the same times, so its start, dt and
+// save_step must match; its end may differ, so that a restored run
+// can be extended or cut short.
+func (cp *Checkpoint) Check(method string, ts Timespec) error {
+	if cp.Method != method {
+		return fmt.Errorf("checkpoint was taken with the %s method, not %s", cp.Method, method)
+	}
+	if cp.Time.Start != ts.Start {
+		return fmt.Errorf("checkpoint has start %g, not %g", cp.Time.Start, ts.Start)
+	}
+	if cp.Time.DT != ts.DT {
+		return fmt.Errorf("checkpoint has dt %g, not %g", cp.Time.DT, ts.DT)
+	}
+	if cp.Time.SaveStep != ts.SaveStep {
+		return fmt.Errorf("checkpoint has save_step %g, not %g", cp.Time.SaveStep, ts.SaveStep)
+	}
+	return nil
+}
+
+// Fork returns a new sim of m, named name, resumed from a
+// checkpoint of s.  The fork has nothing in common with s but the
+// coordinator c, so each can be changed and run on its own, in
+// parallel.  It is created with opts, without their Sink and
+// Progress, so it stores its results, starting with those s has
+// stored so far.
+func Fork(s Sim, m Model, name string, c Coordinator, opts *Options) (Sim, error) {
+	var buf bytes.Buffer
+	if err := s.Checkpoint(&buf); err != nil {
+		return nil, err
+	}
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	o.Sink = nil
+	o.Progress = nil
+	f := m.NewSim(name, c, &o)
+	if err := f.Restore(&buf); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+)
+
+func TestStructureHash(t *testing.T) {
+	m := drainModel(stockTime)
+	h := StructureHash(m, m.Slots)
+
+	// equations aren't covered
+	v := m.Vars["out"]
+	v.Eqn = "level * 2"
+	m.Vars["out"] = v
+	if StructureHash(m, m.Slots) != h {
+		t.Error("hash changed with an equation")
+	}
+
+	for i, change := range []func(m *testModel){
+		func(m *testModel) { m.MName = "other" },
+		func(m *testModel) {
+			v := m.Vars["level"]
+			v.NonNegative = false
+			m.Vars["level"] = v
+		},
+		func(m *testModel) { m.Slots["level"], m.Slots["out"] = m.Slots["out"], m.Slots["level"] },
+	} {
+		m := drainModel(stockTime)
+		change(m)
+		if StructureHash(m, m.Slots) == h {
+			t.Errorf("hash unchanged by change %d", i)
+		}
+	}
+}
+
+func TestReadCheckpoint(t *testing.T) {
+	m := drainModel(stockTime)
+	good := func() *Checkpoint {
+		return &Checkpoint{
+			Model: m.Name(),
+			Hash:  StructureHash(m, m.Slots),
+			Curr:  make([]float64, len(m.Slots)),
+			Last:  make([]float64, len(m.Slots)),
+		}
+	}
+	var buf bytes.Buffer
+	if err := WriteCheckpoint(&buf, good()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadCheckpoint(&buf, m, m.Slots); err != nil {
+		t.Errorf("ReadCheckpoint: %s", err)
+	}
+
+	for _, change := range []func(cp *Checkpoint){
+		func(cp *Checkpoint) { cp.Version = CheckpointVersion + 1 },
+		func(cp *Checkpoint) { cp.Model = "other" },
+		func(cp *Checkpoint) { cp.Hash = "" },
+		func(cp *Checkpoint) { cp.Curr = cp.Curr[1:] },
+	} {
+		cp := good()
+		cp.Version = CheckpointVersion
+		change(cp)
+		buf.Reset()
+		// written directly, to keep the version
+		if err := gob.NewEncoder(&buf).Encode(cp); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadCheckpoint(&buf, m, m.Slots); err == nil {
+			t.Errorf("ReadCheckpoint of %+v succeeded", cp)
+		}
+	}
+}
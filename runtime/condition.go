@@ -0,0 +1,144 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A Condition is a boolean expression of comparisons between
+// variables and numbers, like "inventory < 100", joined by && and
+// ||, with && binding tighter.
+type Condition struct {
+	src string
+	// or is a list of comparisons joined by &&, for each of
+	// the expression's terms joined by ||.
+	or [][]*comparison
+}
+
+// A comparison compares the variable lhs to the variable rhs, or to
+// the number num if rhs is empty.
+type comparison struct {
+	lhs, op, rhs string
+	num          float64
+}
+
+var comparisonRE = regexp.MustCompile(`^\s*([\w.]+)\s*(<=|>=|==|!=|<|>)\s*(\S+)\s*$`)
+
+// ParseCondition parses the condition src.
+func ParseCondition(src string) (*Condition, error) {
+	c := &Condition{src: strings.TrimSpace(src)}
+	for _, term := range strings.Split(src, "||") {
+		var and []*comparison
+		for _, part := range strings.Split(term, "&&") {
+			match := comparisonRE.FindStringSubmatch(part)
+			if match == nil {
+				return nil, fmt.Errorf("bad condition %q: %q isn't a comparison", src, strings.TrimSpace(part))
+			}
+			cmp := &comparison{lhs: match[1], op: match[2]}
+			if v, err := strconv.ParseFloat(match[3], 64); err == nil {
+				cmp.num = v
+			} else {
+				cmp.rhs = match[3]
+			}
+			and = append(and, cmp)
+		}
+		c.or = append(c.or, and)
+	}
+	return c, nil
+}
+
+func (c *Condition) String() string {
+	return c.src
+}
+
+// Resolve checks that the variables c refers to are in m, and
+// strips the sim's instance name from them.
+func (c *Condition) Resolve(m Model, instance string) error {
+	resolve := func(name *string) error {
+		*name = strings.TrimPrefix(*name, instance+".")
+		if _, ok := m.Var(*name); !ok && *name != "time" {
+			return fmt.Errorf("condition %q: unknown var %s", c.src, *name)
+		}
+		return nil
+	}
+	for _, and := range c.or {
+		for _, cmp := range and {
+			if err := resolve(&cmp.lhs); err != nil {
+				return err
+			}
+			if cmp.rhs == "" {
+				continue
+			}
+			if err := resolve(&cmp.rhs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Eval returns the value of c, with value giving the values of
+// variables.
+func (c *Condition) Eval(value func(name string) (float64, error)) (bool, error) {
+	for _, and := range c.or {
+		all := true
+		for _, cmp := range and {
+			ok, err := cmp.eval(value)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (cmp *comparison) eval(value func(name string) (float64, error)) (bool, error) {
+	l, err := value(cmp.lhs)
+	if err != nil {
+		return false, err
+	}
+	r := cmp.num
+	if cmp.rhs != "" {
+		if r, err = value(cmp.rhs); err != nil {
+			return false, err
+		}
+	}
+	switch cmp.op {
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	case ">=":
+		return l >= r, nil
+	case "==":
+		return l == r, nil
+	}
+	return l != r, nil
+}
+
+// SlotValue returns a function giving the values of variables in
+// data, which is laid out by slots, for Condition.Eval.
+func SlotValue(slots map[string]int, data []float64) func(name string) (float64, error) {
+	return func(name string) (float64, error) {
+		i, ok := slots[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown var %s", name)
+		}
+		return data[i], nil
+	}
+}
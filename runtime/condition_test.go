@@ -0,0 +1,143 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCondition(t *testing.T) {
+	vars := map[string]float64{"a": 1, "b": 2, "main.c": 3}
+	value := func(name string) (float64, error) {
+		v, ok := vars[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown var %s", name)
+		}
+		return v, nil
+	}
+	for _, c := range []struct {
+		src  string
+		want bool
+	}{
+		{"a < 2", true},
+		{"a<2", true},
+		{"a > 2", false},
+		{"a <= 1", true},
+		{"a >= 1.5", false},
+		{"a == 1", true},
+		{"a != 1", false},
+		{"a < b", true},
+		{"b < a", false},
+		{"main.c == 3", true},
+		{"a < -1e3", false},
+		{"a < 2 && b > 1", true},
+		{"a < 2 && b > 2", false},
+		{"a > 2 || b > 1", true},
+		{"a > 2 || b > 2", false},
+		// && binds tighter than ||
+		{"a > 2 && b > 1 || a == 1", true},
+		{"a == 1 || a > 2 && b > 2", true},
+		{"a > 2 && b > 1 || b > 2", false},
+	} {
+		cond, err := ParseCondition(c.src)
+		if err != nil {
+			t.Errorf("ParseCondition(%q): %s", c.src, err)
+			continue
+		}
+		got, err := cond.Eval(value)
+		if err != nil {
+			t.Errorf("%q: %s", c.src, err)
+		} else if got != c.want {
+			t.Errorf("%q = %v, want %v", c.src, got, c.want)
+		}
+	}
+
+	cond, err := ParseCondition("a < d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cond.Eval(value); err == nil {
+		t.Error("evaluating a comparison to an unknown var succeeded")
+	}
+}
+
+func TestConditionErrors(t *testing.T) {
+	for _, src := range []string{
+		"",
+		"a",
+		"a <",
+		"< 1",
+		"a = 1",
+		"a < 1 &&",
+		"|| a < 1",
+		"a < 1 & b < 2",
+		"a + 1 < 2",
+		"(a < 1)",
+	} {
+		if _, err := ParseCondition(src); err == nil {
+			t.Errorf("ParseCondition(%q) succeeded", src)
+		}
+	}
+}
+
+func TestStopCondition(t *testing.T) {
+	m := newTestModel(stockTime, Var{Name: "a"}, Var{Name: "b"})
+	if c, err := StopCondition(m, "main", nil); c != nil || err != nil {
+		t.Errorf("StopCondition with none = %v, %v", c, err)
+	}
+
+	// the model's condition is computed into StopVar
+	m.Attrs["stop_when"] = "a > 1"
+	if _, err := StopCondition(m, "main", nil); err == nil {
+		t.Errorf("StopCondition without %s succeeded", StopVar)
+	}
+	m = newTestModel(stockTime, Var{Name: "a"}, Var{Name: "b"}, Var{Name: StopVar, Internal: true})
+	m.Attrs["stop_when"] = "a > 1"
+	c, err := StopCondition(m, "main", &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.String(); got != "a > 1" {
+		t.Errorf("condition %q, want the model's", got)
+	}
+	// StopVar sorts first, in slot 1
+	for v, want := range map[float64]bool{0: false, 1: true} {
+		stop, err := Stopped(c, m.Slots, []float64{2, v, 0, 0})
+		if err != nil || (stop != nil) != want {
+			t.Errorf("Stopped with %s %g = %+v, %v", StopVar, v, stop, err)
+		}
+	}
+
+	c, err = StopCondition(m, "main", &Options{StopWhen: "main.b > a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.String(); got != "main.b > a" {
+		t.Errorf("condition %q, want the options'", got)
+	}
+
+	curr := []float64{4, 0, 1, 2}
+	stop, err := Stopped(c, m.Slots, curr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stop == nil || stop.Time != 4 || stop.Reason != "main.b > a" {
+		t.Errorf("Stopped = %+v, want at 4 for main.b > a", stop)
+	}
+	curr[3] = 0
+	if stop, err := Stopped(c, m.Slots, curr); stop != nil || err != nil {
+		t.Errorf("Stopped when false = %+v, %v", stop, err)
+	}
+	if stop, err := Stopped(nil, m.Slots, curr); stop != nil || err != nil {
+		t.Errorf("Stopped with no condition = %+v, %v", stop, err)
+	}
+
+	for _, src := range []string{"a >", "c > 1", "a > other.b", "main.a > c"} {
+		if _, err := StopCondition(m, "main", &Options{StopWhen: src}); err == nil {
+			t.Errorf("StopCondition(%q) succeeded", src)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An Event changes constants or stocks between time steps, with
+// SetValue: at a given time, or when a condition becomes true.
+type Event struct {
+	// Name labels the event where it is recorded; by default it
+	// is named by its time or condition.
+	Name string `json:"name,omitempty"`
+
+	// At is the time of a timed event.  When is the condition of
+	// a conditional event, like "inventory < 100"; see
+	// Condition.  Conditional events happen each time their
+	// condition becomes true, unless Once is set.
+	At   *float64 `json:"at,omitempty"`
+	When string   `json:"when,omitempty"`
+	Once bool     `json:"once,omitempty"`
+
+	// Set gives variables new values, and Add adds to them, by
+	// name or qualified name.
+	Set map[string]float64 `json:"set,omitempty"`
+	Add map[string]float64 `json:"add,omitempty"`
+}
+
+// A FiredEvent records an event that happened during a run, and the
+// values it gave variables, by qualified name.
+type FiredEvent struct {
+	Time   float64            `json:"time"`
+	Name   string             `json:"name"`
+	Values map[string]float64 `json:"values"`
+}
+
+// ReadEvents reads a JSON array of events.
+func ReadEvents(path string) ([]Event, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var events []Event
+	if err := json.Unmarshal(buf, &events); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return events, nil
+}
+
+// A Schedule runs a sim's events.
+type Schedule struct {
+	events   []Event
+	conds    []*Condition
+	instance string
+
+	// done is, by event, whether a timed or Once event has
+	// happened, and was whether a conditional event's condition
+	// was true when last checked.
+	done  []bool
+	was   []bool
+	fired []FiredEvent
+}
+
+// NewSchedule checks that events apply to variables of m that can
+// be set, and returns a Schedule of them for the sim instance, or
+// nil if there are none.
+func NewSchedule(events []Event, m Model, instance string) (*Schedule, error) {
+	if len(events) == 0 {
+		return nil, nil
+	}
+	known := func(name string) (string, bool) {
+		name = strings.TrimPrefix(name, instance+".")
+		_, ok := m.Var(name)
+		return name, ok || name == "time"
+	}
+	sc := &Schedule{
+		events:   events,
+		conds:    make([]*Condition, len(events)),
+		instance: instance,
+		done:     make([]bool, len(events)),
+		was:      make([]bool, len(events)),
+	}
+	for i, e := range events {
+		if (e.At == nil) == (e.When == "") {
+			return nil, fmt.Errorf("event %d: needs one of at or when", i)
+		}
+		if e.When != "" {
+			c, err := ParseCondition(e.When)
+			if err == nil {
+				err = c.Resolve(m, instance)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("event %d: %s", i, err)
+			}
+			sc.conds[i] = c
+		}
+		if len(e.Set)+len(e.Add) == 0 {
+			return nil, fmt.Errorf("event %d: sets nothing", i)
+		}
+		for _, set := range []map[string]float64{e.Set, e.Add} {
+			for n := range set {
+				name, ok := known(n)
+				if !ok {
+					return nil, fmt.Errorf("event %d: unknown var %s", i, n)
+				}
+				_, isConst := m.Default(name)
+				if v, _ := m.Var(name); !isConst && (v.Type != TyStock || v.Flavor != StockReservoir) {
+					return nil, fmt.Errorf("event %d: %s can't be set", i, n)
+				}
+			}
+		}
+	}
+	return sc, nil
+}
+
+// Run makes the events due at the start of the step of s from t,
+// of length step, happen.
+func (sc *Schedule) Run(s Sim, t, step float64) error {
+	for i, e := range sc.events {
+		if sc.done[i] {
+			continue
+		}
+		var happen bool
+		if e.At != nil {
+			happen = *e.At < t+step/2
+			sc.done[i] = happen
+		} else {
+			now, err := sc.conds[i].Eval(s.Value)
+			if err != nil {
+				return err
+			}
+			happen = now && !sc.was[i]
+			sc.was[i] = now
+			sc.done[i] = happen && e.Once
+		}
+		if !happen {
+			continue
+		}
+
+		fe := FiredEvent{Time: t, Name: e.Name, Values: map[string]float64{}}
+		if fe.Name == "" {
+			if e.At != nil {
+				fe.Name = "at " + strconv.FormatFloat(*e.At, 'g', -1, 64)
+			} else {
+				fe.Name = "when " + e.When
+			}
+		}
+		for _, n := range sortedKeys(e.Set) {
+			if err := s.SetValue(n, e.Set[n]); err != nil {
+				return err
+			}
+			fe.Values[sc.qualify(n)] = e.Set[n]
+		}
+		for _, n := range sortedKeys(e.Add) {
+			v, err := s.Value(n)
+			if err != nil {
+				return err
+			}
+			if err := s.SetValue(n, v+e.Add[n]); err != nil {
+				return err
+			}
+			fe.Values[sc.qualify(n)] = v + e.Add[n]
+		}
+		sc.fired = append(sc.fired, fe)
+	}
+	return nil
+}
+
+func (sc *Schedule) qualify(name string) string {
+	return sc.instance + "." + strings.TrimPrefix(name, sc.instance+".")
+}
+
+// Fired returns the events that have happened so far, in order.
+func (sc *Schedule) Fired() []FiredEvent {
+	if sc == nil {
+		return nil
+	}
+	return sc.fired
+}
+
+// ScheduleState is what a Schedule carries from one step to the
+// next, for checkpoints.
+type ScheduleState struct {
+	Done  []bool
+	Was   []bool
+	Fired []FiredEvent
+}
+
+// State returns a copy of the schedule's state.
+func (sc *Schedule) State() ScheduleState {
+	return ScheduleState{
+		Done:  append([]bool(nil), sc.done...),
+		Was:   append([]bool(nil), sc.was...),
+		Fired: copyFired(sc.fired),
+	}
+}
+
+// SetState replaces the schedule's state with a copy of st, which
+// must be of the same events.
+func (sc *Schedule) SetState(st ScheduleState) error {
+	if len(st.Done) != len(sc.events) || len(st.Was) != len(sc.events) {
+		return fmt.Errorf("checkpoint has state for %d events, not %d", len(st.Done), len(sc.events))
+	}
+	sc.done = append([]bool(nil), st.Done...)
+	sc.was = append([]bool(nil), st.Was...)
+	sc.fired = copyFired(st.Fired)
+	return nil
+}
+
+// copyFired returns a copy of fired that shares nothing with it.
+func copyFired(fired []FiredEvent) []FiredEvent {
+	if fired == nil {
+		return nil
+	}
+	c := make([]FiredEvent, len(fired))
+	for i, fe := range fired {
+		c[i] = fe
+		c[i].Values = make(map[string]float64, len(fe.Values))
+		for n, v := range fe.Values {
+			c[i].Values[n] = v
+		}
+	}
+	return c
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,503 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"fmt"
+	"math"
+)
+
+// The integration methods, as named by a model's
+// integration_method or by Options.Method.
+const (
+	Euler = "euler"
+	RK2   = "rk2"
+	RK4   = "rk4"
+	RK45  = "rk45"
+)
+
+// The tolerances adaptive methods use unless Options sets them.
+const (
+	DefaultRelTol = 1e-6
+	DefaultAbsTol = 1e-6
+)
+
+// A tableau is the Butcher tableau of an explicit Runge-Kutta
+// method: stage i is evaluated at time t + c[i]*dt, with the stocks
+// at y0 + dt*sum(a[i][j]*k[j]), and the step is y0 + dt*sum(b[i]*k[i]).
+// Embedded methods also have e, the weights of the step's error
+// estimate, and order, the order of the error estimate's method.
+type tableau struct {
+	a     [][]float64
+	b     []float64
+	c     []float64
+	e     []float64
+	order int
+}
+
+var tableaus = map[string]*tableau{
+	// Heun's method
+	RK2: {
+		a: [][]float64{{}, {1}},
+		b: []float64{1. / 2, 1. / 2},
+		c: []float64{0, 1},
+	},
+	// the classic fourth order method
+	RK4: {
+		a: [][]float64{{}, {1. / 2}, {0, 1. / 2}, {0, 0, 1}},
+		b: []float64{1. / 6, 1. / 3, 1. / 3, 1. / 6},
+		c: []float64{0, 1. / 2, 1. / 2, 1},
+	},
+	// Dormand-Prince 5(4).  The last stage is evaluated at the
+	// end of the step, so it is also the first stage of the next.
+	RK45: {
+		a: [][]float64{
+			{},
+			{1. / 5},
+			{3. / 40, 9. / 40},
+			{44. / 45, -56. / 15, 32. / 9},
+			{19372. / 6561, -25360. / 2187, 64448. / 6561, -212. / 729},
+			{9017. / 3168, -355. / 33, 46732. / 5247, 49. / 176, -5103. / 18656},
+			{35. / 384, 0, 500. / 1113, 125. / 192, -2187. / 6784, 11. / 84},
+		},
+		b: []float64{35. / 384, 0, 500. / 1113, 125. / 192, -2187. / 6784, 11. / 84, 0},
+		c: []float64{0, 1. / 5, 3. / 10, 4. / 5, 8. / 9, 1, 1},
+		e: []float64{
+			35./384 - 5179./57600, 0, 500./1113 - 7571./16695,
+			125./192 - 393./640, -2187./6784 + 92097./339200,
+			11./84 - 187./2100, -1. / 40,
+		},
+		order: 5,
+	},
+}
+
+// Method returns the integration method for a sim of m created with
+// opts: opts.Method if set, otherwise the model's
+// integration_method attribute, otherwise Euler.
+func Method(m Model, opts *Options) (string, error) {
+	method := Euler
+	if name, ok := m.Attr("integration_method").(string); ok && name != "" {
+		method = name
+	}
+	if opts != nil && opts.Method != "" {
+		method = opts.Method
+	}
+	if _, ok := tableaus[method]; !ok && method != Euler {
+		return "", fmt.Errorf("unknown integration method %q (want euler, rk2, rk4 or rk45)", method)
+	}
+	return method, nil
+}
+
+// StepSize returns the length of the time steps a sim takes with
+// method.  Adaptive methods choose their own steps within each
+// SaveStep, so that results are saved at exactly the same times as
+// with fixed steps.
+func StepSize(method string, ts Timespec) float64 {
+	if method == RK45 {
+		return ts.SaveStep
+	}
+	return ts.DT
+}
+
+// Stats are counts of the work a sim has done: the steps it took,
+// including each of an adaptive method's substeps, and the
+// adaptive steps it rejected and retried with a smaller step.
+type Stats struct {
+	Accepted int64 `json:"accepted"`
+	Rejected int64 `json:"rejected"`
+}
+
+// An Integrator takes a sim's time steps with a method other than
+// Euler's.
+type Integrator interface {
+	// Step takes a time step of h, with flows and stocks
+	// computing the model into curr and next as for an Euler
+	// step.  Afterwards curr holds the values at the start of
+	// the step, and next the state at its end, except for
+	// next's time.  clock has been begun at the start of the
+	// step; adaptive methods begin it again at any breaks
+	// within it.
+	Step(curr, next []float64, h float64, clock *Clock, flows, stocks func()) error
+	// Stats returns the steps the integrator has taken.
+	Stats() Stats
+	// State and SetState get and replace what the integrator
+	// carries from one step to the next, for checkpoints.
+	State() IntegratorState
+	SetState(st IntegratorState)
+}
+
+// IntegratorState is what an Integrator carries from one step to
+// the next.
+type IntegratorState struct {
+	Stats Stats
+	// H is the step an adaptive method tries next.
+	H float64
+}
+
+// NewIntegrator returns an Integrator for method, or nil for Euler,
+// for a sim of m with the given slots, timespec and options.
+// Conveyors and queues move material in discrete steps, so can't
+// be integrated with Runge-Kutta methods.
+func NewIntegrator(method string, m Model, slots map[string]int, ts Timespec, opts *Options) (Integrator, error) {
+	if method == Euler {
+		return nil, nil
+	}
+	tab, ok := tableaus[method]
+	if !ok {
+		return nil, fmt.Errorf("unknown integration method %q", method)
+	}
+	st, err := newStages(method, tab, m, slots, ts.DT)
+	if err != nil {
+		return nil, err
+	}
+	if tab.e == nil {
+		return &RK{stages: st}, nil
+	}
+
+	ad := &Adaptive{
+		stages: st,
+		rtol:   DefaultRelTol,
+		atol:   DefaultAbsTol,
+		h:      math.Min(ts.DT, ts.SaveStep),
+		y1:     make([]float64, len(slots)),
+	}
+	if opts != nil && opts.RelTol != 0 {
+		ad.rtol = opts.RelTol
+	}
+	if opts != nil && opts.AbsTol != 0 {
+		ad.atol = opts.AbsTol
+	}
+	if ad.rtol < 0 || ad.atol < 0 || ad.rtol+ad.atol == 0 {
+		return nil, fmt.Errorf("bad tolerances: relative %g, absolute %g", ad.rtol, ad.atol)
+	}
+	return ad, nil
+}
+
+// stages evaluates the stages of Runge-Kutta methods.  Each stage
+// evaluates the model's flows and stocks as an Euler step would, at
+// the stage's time and stock values, and the stocks' derivatives
+// are read from the change the step would make.  Values carried
+// from one step to the next that aren't integrated, like those of
+// previous(), are taken from the first stage.
+//
+// LimitOutflows only keeps non-negative stocks from going below
+// zero over an Euler step, so the stocks in nonNeg are clamped at
+// zero at the end of each step.
+type stages struct {
+	tab *tableau
+
+	// stocks are the slots that are integrated, and dt the dt
+	// flows and stocks compute with.
+	stocks []int
+	nonNeg []int
+	dt     float64
+
+	y0, row, next []float64
+	k             [][]float64
+}
+
+func newStages(method string, tab *tableau, m Model, slots map[string]int, dt float64) (*stages, error) {
+	st := &stages{tab: tab, dt: dt}
+	for _, name := range m.VarNames() {
+		v, _ := m.Var(name)
+		if v.Type != TyStock {
+			continue
+		}
+		if v.Flavor != StockReservoir {
+			return nil, fmt.Errorf("%s: %s stocks can't be integrated with %s",
+				name, v.Flavor.Name(), method)
+		}
+		if slot, ok := slots[name]; ok {
+			st.stocks = append(st.stocks, slot)
+			if v.NonNegative {
+				st.nonNeg = append(st.nonNeg, slot)
+			}
+		}
+	}
+
+	n := len(slots)
+	st.y0 = make([]float64, n)
+	st.row = make([]float64, n)
+	st.next = make([]float64, n)
+	st.k = make([][]float64, len(tab.b))
+	for i := range st.k {
+		st.k[i] = make([]float64, n)
+	}
+	return st, nil
+}
+
+// first evaluates the first stage, at the values in curr.  It may
+// initialize stocks, so it is where the step starts from.
+func (st *stages) first(curr, next []float64, flows, stocks func()) {
+	flows()
+	stocks()
+	copy(st.y0, curr)
+	copy(st.row, curr)
+	copy(st.next, next)
+	for _, j := range st.stocks {
+		st.k[0][j] = (next[j] - curr[j]) / st.dt
+	}
+}
+
+// eval evaluates stage i of a step of h from time t.
+func (st *stages) eval(i int, curr, next []float64, t, h float64, flows, stocks func()) {
+	copy(curr, st.y0)
+	curr[0] = t + st.tab.c[i]*h
+	for _, j := range st.stocks {
+		var sum float64
+		for l, a := range st.tab.a[i] {
+			sum += a * st.k[l][j]
+		}
+		curr[j] = st.y0[j] + h*sum
+	}
+	for j := range next {
+		next[j] = 0
+	}
+
+	flows()
+	stocks()
+
+	for _, j := range st.stocks {
+		st.k[i][j] = (next[j] - curr[j]) / st.dt
+	}
+}
+
+// clamp sets any non-negative stocks below zero in y to zero.
+func (st *stages) clamp(y []float64) {
+	for _, j := range st.nonNeg {
+		if y[j] < 0 {
+			y[j] = 0
+		}
+	}
+}
+
+// finish restores curr and next to the first stage's values, with
+// the stocks in next at y.
+func (st *stages) finish(curr, next, y []float64) {
+	copy(curr, st.row)
+	copy(next, st.next)
+	for _, j := range st.stocks {
+		next[j] = y[j]
+	}
+}
+
+// An RK takes time steps with an explicit Runge-Kutta method.
+type RK struct {
+	*stages
+	steps int64
+}
+
+func (rk *RK) Step(curr, next []float64, h float64, _ *Clock, flows, stocks func()) error {
+	t := curr[0]
+	rk.first(curr, next, flows, stocks)
+	for i := 1; i < len(rk.tab.b); i++ {
+		rk.eval(i, curr, next, t, h, flows, stocks)
+	}
+
+	y := rk.y0
+	for _, j := range rk.stocks {
+		var sum float64
+		for i, b := range rk.tab.b {
+			sum += b * rk.k[i][j]
+		}
+		y[j] += h * sum
+	}
+	rk.clamp(y)
+	rk.finish(curr, next, y)
+	rk.steps++
+	return nil
+}
+
+func (rk *RK) Stats() Stats {
+	return Stats{Accepted: rk.steps}
+}
+
+func (rk *RK) State() IntegratorState {
+	return IntegratorState{Stats: rk.Stats()}
+}
+
+func (rk *RK) SetState(st IntegratorState) {
+	rk.steps = st.Stats.Accepted
+}
+
+// An Adaptive takes time steps with an embedded Runge-Kutta
+// method, in as many smaller steps as it takes to keep each one's
+// estimated error within its tolerances.  Steps are cut short to
+// end at exactly the end of the time step, and at the clock's
+// breaks.
+type Adaptive struct {
+	*stages
+	rtol, atol float64
+
+	// h is the length of the next step to try
+	h  float64
+	y1 []float64
+
+	accepted, rejected int64
+}
+
+// minStep is the shortest step, relative to the time step, that
+// an Adaptive takes before giving up.
+const minStep = 1e-12
+
+func (ad *Adaptive) Step(curr, next []float64, h float64, clock *Clock, flows, stocks func()) error {
+	tab := ad.tab
+	last := len(tab.b) - 1
+
+	t := curr[0]
+	end := t + h
+	ad.first(curr, next, flows, stocks)
+
+	for end-t > minStep*h {
+		step, to := ad.h, math.Min(end, clock.Break)
+		clipped := t+step >= to
+		if clipped {
+			step = to - t
+		}
+
+		for i := 1; i <= last; i++ {
+			ad.eval(i, curr, next, t, step, flows, stocks)
+		}
+
+		// the error, scaled by the tolerances, as a root mean
+		// square over the stocks.
+		var sum float64
+		for _, j := range ad.stocks {
+			var y1, e float64
+			for i := range tab.b {
+				y1 += tab.b[i] * ad.k[i][j]
+				e += tab.e[i] * ad.k[i][j]
+			}
+			ad.y1[j] = ad.y0[j] + step*y1
+			scale := ad.atol + ad.rtol*math.Max(math.Abs(ad.y0[j]), math.Abs(ad.y1[j]))
+			sum += (step * e / scale) * (step * e / scale)
+		}
+		var err float64
+		if len(ad.stocks) > 0 {
+			err = math.Sqrt(sum / float64(len(ad.stocks)))
+		}
+
+		var factor float64
+		switch {
+		case math.IsNaN(err) || math.IsInf(err, 0):
+			factor = .2
+		case err == 0:
+			factor = 5
+		default:
+			factor = math.Max(.2, math.Min(5, .9*math.Pow(err, -1/float64(tab.order))))
+		}
+
+		if err <= 1 {
+			t += step
+			if clipped {
+				t = to
+			}
+			for _, j := range ad.stocks {
+				ad.y0[j] = ad.y1[j]
+			}
+			ad.clamp(ad.y0)
+			if clipped && to < end {
+				// the last stage was evaluated on
+				// the other side of the break, so
+				// the first of the next step is
+				// evaluated afresh.
+				clock.Begin(t)
+				ad.eval(0, curr, next, t, 0, flows, stocks)
+			} else {
+				// the last stage was evaluated at the
+				// end of the step, so is the first of
+				// the next one.
+				ad.k[0], ad.k[last] = ad.k[last], ad.k[0]
+				clock.Start = t
+			}
+			ad.accepted++
+			// a step cut short says nothing about how
+			// long the next one can be.
+			if !clipped || step*factor > ad.h {
+				ad.h = step * factor
+			}
+		} else {
+			ad.rejected++
+			ad.h = step * math.Min(factor, 1)
+			if ad.h < minStep*h {
+				return fmt.Errorf("step size too small at time %g; the model may be stiff or discontinuous", t)
+			}
+		}
+	}
+
+	ad.finish(curr, next, ad.y0)
+	return nil
+}
+
+func (ad *Adaptive) Stats() Stats {
+	return Stats{Accepted: ad.accepted, Rejected: ad.rejected}
+}
+
+func (ad *Adaptive) State() IntegratorState {
+	return IntegratorState{Stats: ad.Stats(), H: ad.h}
+}
+
+func (ad *Adaptive) SetState(st IntegratorState) {
+	ad.accepted, ad.rejected = st.Stats.Accepted, st.Stats.Rejected
+	if st.H > 0 {
+		ad.h = st.H
+	}
+}
+
+// A Clock tracks the (sub)step a sim's equations are being
+// evaluated for, so that builtins like pulse see the same value at
+// every stage of it.  Start is the time the step started at, and
+// Break the earliest time after Start at which one of those
+// builtins changes abruptly, or +Inf.  Adaptive methods end their
+// substeps at breaks, rather than step over them.
+type Clock struct {
+	Start float64
+	Break float64
+}
+
+// Begin starts a step at time t.
+func (c *Clock) Begin(t float64) {
+	c.Start = t
+	c.Break = math.Inf(1)
+}
+
+// breakAt records a break at time t, if it is the earliest yet.
+func (c *Clock) breakAt(t float64) {
+	if t < c.Break {
+		c.Break = t
+	}
+}
+
+// Pulse returns the flow that moves volume over one time step of
+// the timespec ts, starting at first, and every interval after that
+// if interval is positive.  Pulses start at the step nearest their
+// time, and last dt whatever the integration method, so the whole
+// volume is moved the same way by every method.
+func (c *Clock) Pulse(ts Timespec, volume, first, interval float64) float64 {
+	dt := ts.DT
+	// steps start at multiples of dt from the start, up to
+	// rounding.
+	eps := dt * 1e-6
+	snap := func(t float64) float64 {
+		return ts.Start + dt*math.Floor((t-ts.Start)/dt+.5)
+	}
+
+	p := snap(first)
+	if interval > 0 && c.Start >= p+dt-eps {
+		n := math.Floor((c.Start - first + dt/2) / interval)
+		if p = snap(first + n*interval); c.Start >= p+dt-eps {
+			p = snap(first + (n+1)*interval)
+		}
+	}
+
+	switch {
+	case c.Start < p-eps:
+		c.breakAt(p)
+	case c.Start < p+dt-eps:
+		c.breakAt(p + dt)
+		return volume / dt
+	}
+	return 0
+}
@@ -0,0 +1,199 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"math"
+	"testing"
+)
+
+// drainModel returns a model of a non-negative stock, level,
+// emptied by an outflow that asks for more than it holds.
+func drainModel(ts Timespec) *testModel {
+	m := newTestModel(ts, flowVars(Var{Name: "level", NonNegative: true}, nil, []string{"out"})...)
+	m.initial = func(s *BaseSim, slot func(string) int) {
+		s.Curr[slot("level")] = 100
+	}
+	m.flows = func(s *BaseSim, slot func(string) int, dt float64) {
+		s.Curr[slot("out")] = 30 + .5*s.Curr[slot("level")]
+	}
+	m.stocks = func(s *BaseSim, slot func(string) int, dt float64) {
+		s.LimitOutflows(slot("level"), dt)
+		s.Next[slot("level")] = s.Curr[slot("level")] - s.Curr[slot("out")]*dt
+	}
+	return m
+}
+
+func TestNonNegativeMethods(t *testing.T) {
+	ts := Timespec{Start: 0, End: 20, DT: 1, SaveStep: 5}
+	for _, c := range []struct {
+		method     string
+		rtol, atol float64
+	}{
+		{Euler, 0, 0},
+		{RK2, 0, 0},
+		{RK4, 0, 0},
+		{RK45, 0, 0},
+		// loose enough for a step to overshoot zero
+		{RK45, 10, 100},
+	} {
+		opts := &Options{Method: c.method, RelTol: c.rtol, AbsTol: c.atol}
+		s := newTestSim(t, drainModel(ts), opts)
+		if err := s.RunToEnd(); err != nil {
+			t.Fatalf("%s: %s", c.method, err)
+		}
+		for i, v := range s.Results.Series("level")[1] {
+			if v < 0 {
+				t.Errorf("%s (rtol %g): level is %g at save %d", c.method, c.rtol, v, i)
+			}
+		}
+	}
+}
+
+// expModel returns a model of level, starting at 1 and growing at
+// a rate equal to itself, so that it is exp(time).
+func expModel(ts Timespec) *testModel {
+	m := newTestModel(ts, flowVars(Var{Name: "level"}, []string{"growth"}, nil)...)
+	m.initial = func(s *BaseSim, slot func(string) int) {
+		s.Curr[slot("level")] = 1
+	}
+	m.flows = func(s *BaseSim, slot func(string) int, dt float64) {
+		s.Curr[slot("growth")] = s.Curr[slot("level")]
+	}
+	m.stocks = func(s *BaseSim, slot func(string) int, dt float64) {
+		s.Next[slot("level")] = s.Curr[slot("level")] + s.Curr[slot("growth")]*dt
+	}
+	return m
+}
+
+// expError returns the largest relative error of level at any saved
+// time of a run of expModel.
+func expError(t *testing.T, ts Timespec, opts *Options) float64 {
+	s := newTestSim(t, expModel(ts), opts)
+	if err := s.RunToEnd(); err != nil {
+		t.Fatalf("%s: %s", opts.Method, err)
+	}
+	r := s.Results.Series("level")
+	var worst float64
+	for i, v := range r[1] {
+		worst = math.Max(worst, math.Abs(v/math.Exp(r[0][i])-1))
+	}
+	return worst
+}
+
+func TestMethodOrder(t *testing.T) {
+	for _, c := range []struct {
+		method string
+		order  float64
+	}{
+		{Euler, 1},
+		{RK2, 2},
+		{RK4, 4},
+	} {
+		opts := &Options{Method: c.method}
+		coarse := expError(t, Timespec{Start: 0, End: 1, DT: .1, SaveStep: .5}, opts)
+		fine := expError(t, Timespec{Start: 0, End: 1, DT: .05, SaveStep: .5}, opts)
+		// halving the step divides the error by 2^order
+		want := math.Pow(2, c.order)
+		if ratio := coarse / fine; ratio < want*.8 || ratio > want*1.25 {
+			t.Errorf("%s: errors %g and %g, a ratio of %g, want %g", c.method, coarse, fine, ratio, want)
+		}
+	}
+}
+
+func TestMethod(t *testing.T) {
+	m := expModel(stockTime)
+	for _, c := range []struct {
+		attr, opt, want string
+	}{
+		{"", "", Euler},
+		{"rk4", "", RK4},
+		{"rk4", "rk2", RK2},
+		{"", "rk45", RK45},
+	} {
+		m.Attrs["integration_method"] = c.attr
+		if c.attr == "" {
+			delete(m.Attrs, "integration_method")
+		}
+		if got, err := Method(m, &Options{Method: c.opt}); err != nil || got != c.want {
+			t.Errorf("Method with %q and %q = %s, %v; want %s", c.attr, c.opt, got, err, c.want)
+		}
+	}
+	if _, err := Method(m, &Options{Method: "rk3"}); err == nil {
+		t.Error("Method rk3 succeeded")
+	}
+}
+
+func TestRK45(t *testing.T) {
+	ts := Timespec{Start: 0, End: 2, DT: .1, SaveStep: .25}
+	var accepted []int64
+	for _, tol := range []float64{1e-3, 1e-6, 1e-9} {
+		s := newTestSim(t, expModel(ts), &Options{Method: RK45, RelTol: tol, AbsTol: tol})
+		if err := s.RunToEnd(); err != nil {
+			t.Fatal(err)
+		}
+		r := s.Results.Series("level")
+		// steps end on every save step
+		if len(r[0]) != 9 {
+			t.Fatalf("tolerance %g: %d rows, want 9", tol, len(r[0]))
+		}
+		for i, now := range r[0] {
+			if now != float64(i)*.25 {
+				t.Errorf("tolerance %g: row %d at time %g", tol, i, now)
+			}
+			if err := math.Abs(r[1][i]/math.Exp(now) - 1); err > 100*tol {
+				t.Errorf("tolerance %g: relative error %g at time %g", tol, err, now)
+			}
+		}
+
+		stats := s.Stats()
+		if stats.Accepted < 8 {
+			t.Errorf("tolerance %g: %d steps accepted, want at least 8", tol, stats.Accepted)
+		}
+		accepted = append(accepted, stats.Accepted)
+	}
+	// tighter tolerances take more steps
+	if accepted[0] > accepted[1] || accepted[1] >= accepted[2] {
+		t.Errorf("%v steps accepted with tighter and tighter tolerances", accepted)
+	}
+}
+
+// pulseModel returns a model of a stock filled by a pulse of 10,
+// first at time 1 and then every interval.
+func pulseModel(ts Timespec, interval float64) *testModel {
+	m := newTestModel(ts, flowVars(Var{Name: "level"}, []string{"in"}, nil)...)
+	m.flows = func(s *BaseSim, slot func(string) int, dt float64) {
+		s.Curr[slot("in")] = s.Pulse(10, 1, interval)
+	}
+	m.stocks = func(s *BaseSim, slot func(string) int, dt float64) {
+		s.Next[slot("level")] = s.Curr[slot("level")] + s.Curr[slot("in")]*dt
+	}
+	return m
+}
+
+func TestPulse(t *testing.T) {
+	ts := Timespec{Start: 0, End: 4, DT: .25, SaveStep: .5}
+	for _, c := range []struct {
+		interval float64
+		want     []float64
+	}{
+		{0, []float64{0, 0, 0, 10, 10, 10, 10, 10, 10}},
+		{1, []float64{0, 0, 0, 10, 10, 20, 20, 30, 30}},
+	} {
+		for _, method := range []string{Euler, RK2, RK4, RK45} {
+			s := newTestSim(t, pulseModel(ts, c.interval), &Options{Method: method})
+			if err := s.RunToEnd(); err != nil {
+				t.Fatal(err)
+			}
+			got := s.Results.Series("level")[1]
+			for i := range got {
+				if math.Abs(got[i]-c.want[i]) > 1e-9 {
+					t.Errorf("%s, interval %g: level = %v, want %v", method, c.interval, got, c.want)
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Format is a way of writing results.
+type Format int
+
+const (
+	FormatTSV   Format = iota // tab-separated values
+	FormatCSV                 // comma-separated values
+	FormatJSON                // a single JSON object
+	FormatJSONL               // one JSON object per line
+)
+
+var formatNames = map[string]Format{
+	"tsv":   FormatTSV,
+	"csv":   FormatCSV,
+	"json":  FormatJSON,
+	"jsonl": FormatJSONL,
+}
+
+// ParseFormat returns the Format named name: tsv, csv, json or
+// jsonl.
+func ParseFormat(name string) (Format, error) {
+	f, ok := formatNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown format %q (want tsv, csv, json or jsonl)", name)
+	}
+	return f, nil
+}
+
+// ExactPrecision formats numbers with the fewest digits that parse
+// back to exactly the same float64.
+const ExactPrecision = -1
+
+// Metadata describes a run, and is written as a header by format
+// sinks.  Empty fields are left out.
+type Metadata struct {
+	Model string            `json:"model,omitempty"`
+	Time  *Timespec         `json:"timespec,omitempty"`
+	Seed  *int64            `json:"seed,omitempty"`
+	Units map[string]string `json:"units,omitempty"`
+	Stats *Stats            `json:"stats,omitempty"`
+
+	// Overrides are the constants and timespec fields set for
+	// the run, by qualified name or timespec key, and Events the
+	// events that happened during it.  Stopped is set if the
+	// run ended early, at its stop condition.
+	Overrides map[string]float64 `json:"overrides,omitempty"`
+	Events    []FiredEvent       `json:"events,omitempty"`
+	Stopped   *Stop              `json:"stopped,omitempty"`
+}
+
+// MarshalJSON writes the timespec with the same keys models use.
+func (ts Timespec) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]float64{
+		"start":     ts.Start,
+		"end":       ts.End,
+		"dt":        ts.DT,
+		"save_step": ts.SaveStep,
+	})
+}
+
+type formatSink struct {
+	w         *bufio.Writer
+	format    Format
+	precision int
+	meta      *Metadata
+
+	names []string
+	rows  int
+}
+
+// NewFormatSink returns a ResultSink that writes results to w in
+// format f, with precision digits after the decimal point, or
+// ExactPrecision.  If meta is non-nil, it is written first: as
+// comment lines starting with # for TSV and CSV, and as a "meta"
+// key for JSON and JSON Lines.
+func NewFormatSink(w io.Writer, f Format, precision int, meta *Metadata) ResultSink {
+	return &formatSink{
+		w:         bufio.NewWriter(w),
+		format:    f,
+		precision: precision,
+		meta:      meta,
+	}
+}
+
+// NewWriterSink returns a ResultSink that writes results to w as
+// they are saved, as tab-separated values with a header line of
+// variable names.
+func NewWriterSink(w io.Writer) ResultSink {
+	return NewFormatSink(w, FormatTSV, 6, nil)
+}
+
+// number formats v for the sink's format and precision.  JSON has
+// no NaN or infinities, so they're written as null there.
+func (fs *formatSink) number(v float64) string {
+	if (fs.format == FormatJSON || fs.format == FormatJSONL) &&
+		(math.IsNaN(v) || math.IsInf(v, 0)) {
+		return "null"
+	}
+	if fs.precision == ExactPrecision {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'f', fs.precision, 64)
+}
+
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func (fs *formatSink) Begin(names []string) error {
+	fs.names = names
+
+	switch fs.format {
+	case FormatTSV, FormatCSV:
+		fs.textMeta()
+		sep := "\t"
+		if fs.format == FormatCSV {
+			sep = ","
+			quoted := make([]string, len(names))
+			for i, n := range names {
+				quoted[i] = csvField(n)
+			}
+			names = quoted
+		}
+		fs.w.WriteString(strings.Join(names, sep))
+		fs.w.WriteString("\n")
+	case FormatJSON:
+		fs.w.WriteString("{")
+		if fs.meta != nil {
+			meta, err := json.Marshal(fs.meta)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(fs.w, "%s:%s,", jsonString("meta"), meta)
+		}
+		fmt.Fprintf(fs.w, "%s:[", jsonString("columns"))
+		for i, n := range names {
+			if i > 0 {
+				fs.w.WriteString(",")
+			}
+			fs.w.WriteString(jsonString(n))
+		}
+		fmt.Fprintf(fs.w, "],%s:[", jsonString("rows"))
+	case FormatJSONL:
+		if fs.meta != nil {
+			meta, err := json.Marshal(fs.meta)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(fs.w, "{%s:%s}\n", jsonString("meta"), meta)
+		}
+	}
+	return nil
+}
+
+// textMeta writes the metadata as # comment lines.
+func (fs *formatSink) textMeta() {
+	m := fs.meta
+	if m == nil {
+		return
+	}
+	if m.Model != "" {
+		fmt.Fprintf(fs.w, "# model: %s\n", m.Model)
+	}
+	if m.Time != nil {
+		fmt.Fprintf(fs.w, "# timespec: start=%s end=%s dt=%s save_step=%s\n",
+			fs.number(m.Time.Start), fs.number(m.Time.End),
+			fs.number(m.Time.DT), fs.number(m.Time.SaveStep))
+	}
+	if m.Seed != nil {
+		fmt.Fprintf(fs.w, "# seed: %d\n", *m.Seed)
+	}
+	if m.Stats != nil {
+		fmt.Fprintf(fs.w, "# stats: accepted=%d rejected=%d\n", m.Stats.Accepted, m.Stats.Rejected)
+	}
+	if len(m.Units) > 0 {
+		names := make([]string, 0, len(m.Units))
+		for n := range m.Units {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		fs.w.WriteString("# units:")
+		for _, n := range names {
+			fmt.Fprintf(fs.w, " %s=%s", n, m.Units[n])
+		}
+		fs.w.WriteString("\n")
+	}
+	if len(m.Overrides) > 0 {
+		names := make([]string, 0, len(m.Overrides))
+		for n := range m.Overrides {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		fs.w.WriteString("# overrides:")
+		for _, n := range names {
+			fmt.Fprintf(fs.w, " %s=%s", n, strconv.FormatFloat(m.Overrides[n], 'g', -1, 64))
+		}
+		fs.w.WriteString("\n")
+	}
+	for _, e := range m.Events {
+		fmt.Fprintf(fs.w, "# event: time=%s %q:", strconv.FormatFloat(e.Time, 'g', -1, 64), e.Name)
+		for _, n := range sortedKeys(e.Values) {
+			fmt.Fprintf(fs.w, " %s=%s", n, strconv.FormatFloat(e.Values[n], 'g', -1, 64))
+		}
+		fs.w.WriteString("\n")
+	}
+	if m.Stopped != nil {
+		fmt.Fprintf(fs.w, "# stopped: time=%s %q\n", strconv.FormatFloat(m.Stopped.Time, 'g', -1, 64), m.Stopped.Reason)
+	}
+}
+
+// csvField quotes s if it has any characters special to CSV.
+func csvField(s string) string {
+	if !strings.ContainsAny(s, ",\"\r\n") {
+		return s
+	}
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
+
+func (fs *formatSink) Row(values []float64) error {
+	var err error
+	switch fs.format {
+	case FormatTSV, FormatCSV:
+		sep := byte('\t')
+		if fs.format == FormatCSV {
+			sep = ','
+		}
+		for i, v := range values {
+			if i > 0 {
+				fs.w.WriteByte(sep)
+			}
+			fs.w.WriteString(fs.number(v))
+		}
+		_, err = fs.w.WriteString("\n")
+	case FormatJSON:
+		if fs.rows > 0 {
+			fs.w.WriteString(",")
+		}
+		fs.w.WriteString("[")
+		for i, v := range values {
+			if i > 0 {
+				fs.w.WriteString(",")
+			}
+			fs.w.WriteString(fs.number(v))
+		}
+		_, err = fs.w.WriteString("]")
+	case FormatJSONL:
+		fs.w.WriteString("{")
+		for i, v := range values {
+			if i > 0 {
+				fs.w.WriteString(",")
+			}
+			fmt.Fprintf(fs.w, "%s:%s", jsonString(fs.names[i]), fs.number(v))
+		}
+		_, err = fs.w.WriteString("}\n")
+	}
+	fs.rows++
+	return err
+}
+
+func (fs *formatSink) End() error {
+	if fs.format == FormatJSON {
+		fs.w.WriteString("]}\n")
+	}
+	return fs.w.Flush()
+}
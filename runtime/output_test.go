@@ -0,0 +1,111 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+// format writes rows of the columns names to a new format sink,
+// returning its output.
+func format(t *testing.T, f Format, precision int, meta *Metadata, names []string, rows ...[]float64) string {
+	var buf bytes.Buffer
+	sink := NewFormatSink(&buf, f, precision, meta)
+	if err := sink.Begin(names); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rows {
+		if err := sink.Row(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.End(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestFormatSink(t *testing.T) {
+	names := []string{"time", "main.a", "main.b,c"}
+	rows := [][]float64{{0, 1.5, -2}, {1, 1.0 / 3, math.NaN()}}
+	for _, c := range []struct {
+		f         Format
+		precision int
+		want      string
+	}{
+		{FormatTSV, 2, "time\tmain.a\tmain.b,c\n" +
+			"0.00\t1.50\t-2.00\n" +
+			"1.00\t0.33\tNaN\n"},
+		{FormatCSV, 2, "time,main.a,\"main.b,c\"\n" +
+			"0.00,1.50,-2.00\n" +
+			"1.00,0.33,NaN\n"},
+		{FormatTSV, ExactPrecision, "time\tmain.a\tmain.b,c\n" +
+			"0\t1.5\t-2\n" +
+			"1\t0.3333333333333333\tNaN\n"},
+		{FormatJSON, 1, `{"columns":["time","main.a","main.b,c"],"rows":[` +
+			`[0.0,1.5,-2.0],[1.0,0.3,null]]}` + "\n"},
+		{FormatJSONL, 1, `{"time":0.0,"main.a":1.5,"main.b,c":-2.0}` + "\n" +
+			`{"time":1.0,"main.a":0.3,"main.b,c":null}` + "\n"},
+	} {
+		if got := format(t, c.f, c.precision, nil, names, rows...); got != c.want {
+			t.Errorf("format %d, precision %d:\n%s\nwant:\n%s", c.f, c.precision, got, c.want)
+		}
+	}
+}
+
+func TestFormatMeta(t *testing.T) {
+	seed := int64(3)
+	meta := &Metadata{
+		Model:     "main",
+		Time:      &Timespec{Start: 0, End: 10, DT: .5, SaveStep: 1},
+		Seed:      &seed,
+		Units:     map[string]string{"main.b": "widget", "main.a": "person"},
+		Overrides: map[string]float64{"main.rate": .25},
+		Stopped:   &Stop{Time: 4, Reason: "a < 1"},
+	}
+	names := []string{"time", "main.a"}
+	want := "# model: main\n" +
+		"# timespec: start=0 end=10 dt=0.5 save_step=1\n" +
+		"# seed: 3\n" +
+		"# units: main.a=person main.b=widget\n" +
+		"# overrides: main.rate=0.25\n" +
+		"# stopped: time=4 \"a < 1\"\n" +
+		"time\tmain.a\n" +
+		"0\t1\n"
+	if got := format(t, FormatTSV, ExactPrecision, meta, names, []float64{0, 1}); got != want {
+		t.Errorf("TSV with metadata:\n%s\nwant:\n%s", got, want)
+	}
+
+	var doc struct {
+		Meta    map[string]interface{}
+		Columns []string
+		Rows    [][]float64
+	}
+	out := format(t, FormatJSON, 6, meta, names, []float64{0, 1}, []float64{1, 2})
+	if err := json.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatalf("JSON output %s: %s", out, err)
+	}
+	if doc.Meta["model"] != "main" || doc.Meta["seed"] != 3.0 || len(doc.Rows) != 2 || doc.Rows[1][1] != 2 {
+		t.Errorf("JSON output %s", out)
+	}
+	ts, _ := doc.Meta["timespec"].(map[string]interface{})
+	if ts["dt"] != .5 || ts["save_step"] != 1.0 {
+		t.Errorf("JSON timespec %v", ts)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for name, want := range map[string]Format{"tsv": FormatTSV, "CSV": FormatCSV, "json": FormatJSON, "jsonl": FormatJSONL} {
+		if f, err := ParseFormat(name); err != nil || f != want {
+			t.Errorf("ParseFormat(%s) = %d, %v", name, f, err)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("ParseFormat(xml) succeeded")
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// ResolveOverrides checks that each of the names in set, which may
+// be qualified by the sim's instance name, like main.rate, is a
+// constant of m: a variable whose value the Coordinator supplies
+// from the model's defaults.  It returns the overrides by bare
+// name.
+func ResolveOverrides(m Model, instance string, set map[string]float64) (map[string]float64, error) {
+	if len(set) == 0 {
+		return nil, nil
+	}
+	result := make(map[string]float64, len(set))
+	for qualName, v := range set {
+		name := strings.TrimPrefix(qualName, instance+".")
+		if _, ok := m.Default(name); !ok {
+			if _, ok := m.Var(name); ok {
+				return nil, fmt.Errorf("%s is not a constant", qualName)
+			}
+			return nil, fmt.Errorf("unknown var %s", qualName)
+		}
+		result[name] = v
+	}
+	return result, nil
+}
+
+// Override returns ts with the fields named in set, by the keys
+// models use in their timespec (start, end, dt and save_step),
+// replaced.
+func (ts Timespec) Override(set map[string]float64) (Timespec, error) {
+	for k, v := range set {
+		switch k {
+		case "start":
+			ts.Start = v
+		case "end":
+			ts.End = v
+		case "dt":
+			ts.DT = v
+		case "save_step":
+			ts.SaveStep = v
+		default:
+			return ts, fmt.Errorf("unknown timespec key %s", k)
+		}
+	}
+	return ts, ts.Check()
+}
+
+// Check returns an error if ts can't be simulated over: if its dt or
+// save_step isn't positive, or it ends before it starts.
+func (ts Timespec) Check() error {
+	if !(ts.DT > 0) || !(ts.SaveStep > 0) {
+		return fmt.Errorf("timespec dt and save_step must be positive, not %g and %g", ts.DT, ts.SaveStep)
+	}
+	if ts.End < ts.Start {
+		return fmt.Errorf("timespec end %g before start %g", ts.End, ts.Start)
+	}
+	return nil
+}
+
+// Rows returns the number of rows of results saved over ts, or 0 if
+// ts fails Check.
+func (ts Timespec) Rows() int {
+	if ts.Check() != nil {
+		return 0
+	}
+	return int((ts.End-ts.Start)/ts.SaveStep) + 1
+}
+
+// ReadScenario reads a scenario: a JSON object mapping qualified
+// variable names to the values they should have.
+func ReadScenario(path string) (map[string]float64, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var scenario map[string]float64
+	if err := json.Unmarshal(buf, &scenario); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return scenario, nil
+}
+
+// setFlag collects the name=value pairs given by repeated -set
+// flags.
+type setFlag map[string]float64
+
+func (f setFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for n, v := range f {
+		pairs = append(pairs, fmt.Sprintf("%s=%g", n, v))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f setFlag) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return fmt.Errorf("%q isn't name=value", s)
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(s[i+1:]), 64)
+	if err != nil {
+		return fmt.Errorf("%q: %s", s, err)
+	}
+	f[strings.TrimSpace(s[:i])] = v
+	return nil
+}
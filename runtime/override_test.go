@@ -0,0 +1,131 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// rateModel returns a model with a constant rate and a stock level.
+func rateModel() *testModel {
+	m := newTestModel(stockTime,
+		Var{Name: "rate", Type: TyAux},
+		Var{Name: "level", Type: TyStock})
+	m.Defaults["rate"] = 1
+	return m
+}
+
+func TestResolveOverrides(t *testing.T) {
+	m := rateModel()
+	for _, c := range []struct {
+		set  map[string]float64
+		want map[string]float64
+		err  string
+	}{
+		{nil, nil, ""},
+		{map[string]float64{"rate": 2}, map[string]float64{"rate": 2}, ""},
+		{map[string]float64{"main.rate": 2}, map[string]float64{"rate": 2}, ""},
+		{map[string]float64{"level": 2}, nil, "level is not a constant"},
+		{map[string]float64{"main.speed": 2}, nil, "unknown var main.speed"},
+		{map[string]float64{"other.rate": 2}, nil, "unknown var other.rate"},
+	} {
+		got, err := ResolveOverrides(m, "main", c.set)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("ResolveOverrides(%v): %v, want %q", c.set, err, c.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ResolveOverrides(%v) = %v, %v; want %v", c.set, got, err, c.want)
+		}
+	}
+}
+
+func TestTimespecOverride(t *testing.T) {
+	ts := Timespec{Start: 0, End: 10, DT: 1, SaveStep: 1}
+	got, err := ts.Override(map[string]float64{"start": 2, "end": 20, "dt": .25, "save_step": 2})
+	if want := (Timespec{Start: 2, End: 20, DT: .25, SaveStep: 2}); err != nil || got != want {
+		t.Errorf("Override = %v, %v; want %v", got, err, want)
+	}
+	if got.Rows() != 10 {
+		t.Errorf("Rows() = %d, want 10", got.Rows())
+	}
+	for _, set := range []map[string]float64{
+		{"stop": 5},
+		{"dt": 0},
+		{"save_step": -1},
+		{"end": -1},
+	} {
+		if _, err := ts.Override(set); err == nil {
+			t.Errorf("Override(%v) succeeded", set)
+		}
+	}
+}
+
+func TestSimOverrides(t *testing.T) {
+	m := rateModel()
+	s := newTestSim(t, m, &Options{Set: map[string]float64{"main.rate": 3}})
+	if v := constant(s, "rate"); v != 3 {
+		t.Errorf("rate = %g, want 3", v)
+	}
+
+	// bad overrides are reported when the sim runs
+	for _, opts := range []*Options{
+		{Set: map[string]float64{"level": 1}},
+		{Timespec: map[string]float64{"dt": -1}},
+	} {
+		s := m.NewSim("main", nil, opts)
+		if err := s.RunToEnd(); err == nil {
+			t.Errorf("RunToEnd with %+v succeeded", opts)
+		}
+	}
+}
+
+func TestSetFlag(t *testing.T) {
+	f := setFlag{}
+	for _, s := range []string{"rate=2", " main.x = -1.5 "} {
+		if err := f.Set(s); err != nil {
+			t.Errorf("Set(%q): %s", s, err)
+		}
+	}
+	if want := (setFlag{"rate": 2, "main.x": -1.5}); !reflect.DeepEqual(f, want) {
+		t.Errorf("flags %v, want %v", f, want)
+	}
+	for _, s := range []string{"rate", "rate=fast"} {
+		if err := f.Set(s); err == nil {
+			t.Errorf("Set(%q) succeeded", s)
+		}
+	}
+}
+
+func TestReadScenario(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scenario")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ok.json")
+	ioutil.WriteFile(path, []byte(`{"main.rate": 0.5, "main.level": 10}`), 0644)
+	got, err := ReadScenario(path)
+	if want := map[string]float64{"main.rate": .5, "main.level": 10}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadScenario = %v, %v; want %v", got, err, want)
+	}
+
+	path = filepath.Join(dir, "bad.json")
+	ioutil.WriteFile(path, []byte(`{"main.rate": "fast"}`), 0644)
+	if _, err := ReadScenario(path); err == nil {
+		t.Error("ReadScenario of a string value succeeded")
+	}
+	if _, err := ReadScenario(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("ReadScenario of a missing file succeeded")
+	}
+}
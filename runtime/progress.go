@@ -0,0 +1,37 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"context"
+)
+
+// PollEvery is how many time steps sims take between checks for
+// cancellation and progress reports.
+const PollEvery = 1024
+
+// Poll returns ctx.Err() if ctx is done, and otherwise calls
+// progress, if non-nil, with the time t a sim over ts has reached
+// and the fraction of its run that is complete.
+func Poll(ctx context.Context, progress func(t, frac float64), ts Timespec, t float64) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	if progress != nil {
+		frac := 1.0
+		if ts.End > ts.Start {
+			frac = (t - ts.Start) / (ts.End - ts.Start)
+		}
+		if frac < 0 {
+			frac = 0
+		} else if frac > 1 {
+			frac = 1
+		}
+		progress(t, frac)
+	}
+	return nil
+}
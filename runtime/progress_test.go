@@ -0,0 +1,69 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPoll(t *testing.T) {
+	ts := Timespec{Start: 10, End: 20, DT: 1, SaveStep: 1}
+	for _, c := range []struct{ t, frac float64 }{
+		{10, 0},
+		{15, .5},
+		{20, 1},
+		{25, 1},
+	} {
+		var got float64
+		progress := func(t, frac float64) { got = frac }
+		if err := Poll(context.Background(), progress, ts, c.t); err != nil || got != c.frac {
+			t.Errorf("Poll at %g: %g, %v; want %g", c.t, got, err, c.frac)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Poll(ctx, nil, ts, 15); err != context.Canceled {
+		t.Errorf("Poll after cancel: %v", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	ts := Timespec{Start: 0, End: 10 * PollEvery, DT: 1, SaveStep: 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	var fracs []float64
+	opts := &Options{Progress: func(now, frac float64) {
+		fracs = append(fracs, frac)
+		if now >= 3*PollEvery {
+			cancel()
+		}
+	}}
+	s := newTestSim(t, drainModel(ts), opts)
+	if err := s.RunToEndContext(ctx); err != context.Canceled {
+		t.Fatalf("RunToEndContext: %v, want context.Canceled", err)
+	}
+	// cancelling is noticed at the next poll, and the results up
+	// to it are kept
+	if n := s.Results.Len(); n != 4*PollEvery {
+		t.Errorf("%d rows saved before cancelling, want %d", n, 4*PollEvery)
+	}
+	for i := 1; i < len(fracs); i++ {
+		if fracs[i] <= fracs[i-1] {
+			t.Errorf("progress went from %g to %g", fracs[i-1], fracs[i])
+		}
+	}
+
+	// the run can go on with another context
+	if err := s.RunToEnd(); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.Results.Len(); n != ts.Rows() {
+		t.Errorf("%d rows saved, want %d", n, ts.Rows())
+	}
+	if fracs[len(fracs)-1] != 1 {
+		t.Errorf("last progress %g, want 1", fracs[len(fracs)-1])
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"math"
+)
+
+// DefaultSeed seeds the random number generator of sims created
+// without an explicit seed, so that runs are reproducible by
+// default.
+const DefaultSeed = 1
+
+// Rand is the per-sim random number generator used by the random
+// builtins.  It is a splitmix64 generator: all of its state is the
+// single exported word State, so it can be copied and saved along
+// with the rest of a sim.
+type Rand struct {
+	State uint64
+}
+
+// NewRand returns a generator seeded with seed.  The same seed
+// always produces the same sequence of numbers.
+func NewRand(seed int64) *Rand {
+	return &Rand{State: uint64(seed)}
+}
+
+// Uint64 returns a uniformly distributed 64-bit value.
+func (r *Rand) Uint64() uint64 {
+	r.State += 0x9e3779b97f4a7c15
+	z := r.State
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// Float64 returns a uniformly distributed value in [0, 1).
+func (r *Rand) Float64() float64 {
+	return float64(r.Uint64()>>11) / (1 << 53)
+}
+
+// NormFloat64 returns a normally distributed value with mean 0 and
+// standard deviation 1, using the Box-Muller transform.
+func (r *Rand) NormFloat64() float64 {
+	u1 := 1 - r.Float64() // in (0, 1], so the log is finite
+	u2 := r.Float64()
+	return math.Sqrt(-2*math.Log(u1)) * math.Cos(2*math.Pi*u2)
+}
+
+// Poisson returns a Poisson distributed value with the given mean.
+// Small means use Knuth's multiplication method; for means above 30
+// the normal approximation, rounded and bounded at zero, is used.
+func (r *Rand) Poisson(mean float64) float64 {
+	if mean <= 0 {
+		return 0
+	}
+	if mean > 30 {
+		v := math.Floor(mean + math.Sqrt(mean)*r.NormFloat64() + .5)
+		return math.Max(v, 0)
+	}
+	limit := math.Exp(-mean)
+	k, p := 0.0, r.Float64()
+	for p > limit {
+		k++
+		p *= r.Float64()
+	}
+	return k
+}
+
+// Uniform returns a value uniformly distributed in [min, max).
+func (r *Rand) Uniform(min, max float64) float64 {
+	return min + (max-min)*r.Float64()
+}
+
+// maxNormalDraws bounds the resampling done by TruncNormal.
+const maxNormalDraws = 100
+
+// TruncNormal returns a normally distributed value, truncated to
+// [min, max] by drawing again when a value falls outside of it; if
+// none of maxNormalDraws draws do, the last is clamped.
+func (r *Rand) TruncNormal(min, max, mean, sd float64) float64 {
+	var v float64
+	for i := 0; i < maxNormalDraws; i++ {
+		v = mean + sd*r.NormFloat64()
+		if v >= min && v <= max {
+			return v
+		}
+	}
+	return math.Min(math.Max(v, min), max)
+}
+
+// WhiteNoise returns the white noise driving the pink_noise
+// builtin.  Its variance is scaled for the time step and
+// correlation time so that, once smoothed over the correlation
+// time, the result has standard deviation sd.  Correlation times
+// shorter than dt are taken as dt, giving uncorrelated noise, as
+// the scale is only defined while corr is more than dt/2.
+func (r *Rand) WhiteNoise(mean, sd, corr, dt float64) float64 {
+	corr = math.Max(corr, dt)
+	scale := math.Sqrt((2 - dt/corr) * corr / dt)
+	return mean + sd*scale*r.NormFloat64()
+}
+
+// RandomUniform implements the random_uniform builtin, returning a
+// value uniformly distributed in [min, max).
+func (s *BaseSim) RandomUniform(min, max float64) float64 {
+	return s.Rand.Uniform(min, max)
+}
+
+// RandomNormal implements the random_normal builtin, a normal
+// distribution truncated to [min, max].
+func (s *BaseSim) RandomNormal(min, max, mean, sd float64) float64 {
+	return s.Rand.TruncNormal(min, max, mean, sd)
+}
+
+// RandomPoisson implements the random_poisson builtin.
+func (s *BaseSim) RandomPoisson(mean float64) float64 {
+	return s.Rand.Poisson(mean)
+}
+
+// WhiteNoise implements the white noise driving the pink_noise
+// builtin; see Rand.WhiteNoise.
+func (s *BaseSim) WhiteNoise(mean, sd, corr, dt float64) float64 {
+	return s.Rand.WhiteNoise(mean, sd, corr, dt)
+}
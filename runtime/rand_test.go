@@ -0,0 +1,120 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// draws returns n values drawn from a generator seeded with seed.
+func draws(seed int64, n int) []float64 {
+	r := NewRand(seed)
+	var vals []float64
+	for i := 0; i < n; i++ {
+		vals = append(vals, r.Float64())
+	}
+	return vals
+}
+
+func TestRandSeed(t *testing.T) {
+	if a, b := draws(42, 10), draws(42, 10); !reflect.DeepEqual(a, b) {
+		t.Errorf("seed 42 gave %v, then %v", a, b)
+	}
+	if a, b := draws(42, 10), draws(43, 10); reflect.DeepEqual(a, b) {
+		t.Errorf("seeds 42 and 43 both gave %v", a)
+	}
+	if a, b := draws(0, 10), draws(DefaultSeed, 10); reflect.DeepEqual(a, b) {
+		t.Errorf("seed 0 gave the default seed's %v", a)
+	}
+}
+
+func TestRecordSeed(t *testing.T) {
+	seed := int64(DefaultSeed)
+	m := newTestModel(stockTime)
+	for _, c := range []struct {
+		stochastic bool
+		opts       *Options
+		want       bool
+	}{
+		{false, nil, false},
+		{false, &Options{}, false},
+		// a chosen seed is recorded, even if it's the default
+		{false, &Options{Seed: &seed}, true},
+		{true, nil, true},
+		{true, &Options{Seed: &seed}, true},
+	} {
+		m.Attrs["stochastic"] = c.stochastic
+		if got := recordSeed(m, c.opts); got != c.want {
+			t.Errorf("recordSeed(stochastic %v, %+v) = %v, want %v", c.stochastic, c.opts, got, c.want)
+		}
+	}
+}
+
+func TestRandDistributions(t *testing.T) {
+	const n = 20000
+	r := NewRand(7)
+	var sum, sumSq float64
+	for i := 0; i < n; i++ {
+		v := r.Uniform(2, 4)
+		if v < 2 || v >= 4 {
+			t.Fatalf("Uniform(2, 4) = %g", v)
+		}
+		sum += v
+	}
+	if mean := sum / n; math.Abs(mean-3) > .05 {
+		t.Errorf("Uniform(2, 4) mean %g, want 3", mean)
+	}
+
+	sum = 0
+	for i := 0; i < n; i++ {
+		v := r.TruncNormal(-1, 1, 0, 2)
+		if v < -1 || v > 1 {
+			t.Fatalf("TruncNormal(-1, 1, 0, 2) = %g", v)
+		}
+	}
+	for i := 0; i < n; i++ {
+		v := r.TruncNormal(math.Inf(-1), math.Inf(1), 5, 2)
+		sum += v
+		sumSq += v * v
+	}
+	mean := sum / n
+	if sd := math.Sqrt(sumSq/n - mean*mean); math.Abs(mean-5) > .1 || math.Abs(sd-2) > .1 {
+		t.Errorf("TruncNormal mean %g and sd %g, want 5 and 2", mean, sd)
+	}
+	// with no chance of a draw in range, the value is clamped
+	if v := r.TruncNormal(10, 11, 0, .1); v != 10 {
+		t.Errorf("TruncNormal(10, 11, 0, .1) = %g, want 10", v)
+	}
+
+	for _, want := range []float64{3, 50} {
+		sum = 0
+		for i := 0; i < n; i++ {
+			v := r.Poisson(want)
+			if v < 0 || v != math.Floor(v) {
+				t.Fatalf("Poisson(%g) = %g", want, v)
+			}
+			sum += v
+		}
+		if mean := sum / n; math.Abs(mean-want) > want*.05 {
+			t.Errorf("Poisson(%g) mean %g", want, mean)
+		}
+	}
+	if v := r.Poisson(-1); v != 0 {
+		t.Errorf("Poisson(-1) = %g, want 0", v)
+	}
+}
+
+func TestWhiteNoise(t *testing.T) {
+	r := NewRand(1)
+	for _, corr := range []float64{0, .1, .5, 1, 10} {
+		for i := 0; i < 100; i++ {
+			if v := r.WhiteNoise(0, 1, corr, 1); math.IsNaN(v) || math.IsInf(v, 0) {
+				t.Fatalf("WhiteNoise(0, 1, %g, 1) = %g", corr, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"fmt"
+	"path"
+	"sort"
+)
+
+// A ResultSink receives the values a sim saves, one row every
+// SaveStep, as the sim runs.
+type ResultSink interface {
+	// Begin is called before the first row with the names of
+	// the columns, in the order Row's values are in.  The first
+	// column is always time.
+	Begin(names []string) error
+	// Row is called with the values saved at a time step.  The
+	// slice is reused, so sinks must copy anything they keep.
+	Row(values []float64) error
+	// End is called once the sim has run to the end of its
+	// timespec.
+	End() error
+}
+
+// Results is a ResultSink that stores each column of results in
+// its own slice, so a variable's series can be read without
+// copying.
+type Results struct {
+	Names []string
+	Cols  [][]float64
+
+	index map[string]int
+	rows  int
+}
+
+// NewResults returns an empty Results, with room for rows rows
+// before its columns need to grow.
+func NewResults(rows int) *Results {
+	return &Results{rows: rows}
+}
+
+func (r *Results) Begin(names []string) error {
+	r.Names = names
+	r.Cols = make([][]float64, len(names))
+	r.index = make(map[string]int, len(names))
+	for i, n := range names {
+		r.Cols[i] = make([]float64, 0, r.rows)
+		r.index[n] = i
+	}
+	return nil
+}
+
+func (r *Results) Row(values []float64) error {
+	if len(values) != len(r.Cols) {
+		return fmt.Errorf("row has %d values, not %d", len(values), len(r.Cols))
+	}
+	for i, v := range values {
+		r.Cols[i] = append(r.Cols[i], v)
+	}
+	return nil
+}
+
+func (r *Results) End() error {
+	return nil
+}
+
+// Column returns the saved values of the variable name.
+func (r *Results) Column(name string) ([]float64, bool) {
+	i, ok := r.index[name]
+	if !ok {
+		return nil, false
+	}
+	return r.Cols[i], true
+}
+
+// Len returns the number of rows saved.
+func (r *Results) Len() int {
+	if len(r.Cols) == 0 {
+		return 0
+	}
+	return len(r.Cols[0])
+}
+
+// Series returns the times and values saved for the variable name,
+// in the form Sim.ValueSeries returns.
+func (r *Results) Series(name string) (series [2][]float64) {
+	series[0], _ = r.Column("time")
+	series[1], _ = r.Column(name)
+	return
+}
+
+// Selected returns true if the variable name of the sim instance
+// is matched by any of patterns, which are like those of path.Match
+// and are matched against both the qualified name, like
+// main.rabbits.births, and the bare name.  With no patterns,
+// every variable is selected.
+func Selected(patterns []string, instance, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	qualName := instance + "." + name
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, qualName); ok {
+			return true
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveSlots returns the slots of the variables selected by
+// patterns, in slot order.  Time is always saved, and is always
+// first.  It is an error for a pattern to be malformed or to match
+// nothing, so that typos don't silently drop results.
+func SaveSlots(patterns []string, instance string, slots map[string]int) ([]int, error) {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %s", p, err)
+		}
+		matched := false
+		for n := range slots {
+			if Selected([]string{p}, instance, n) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("no variables match %q", p)
+		}
+	}
+
+	saved := []int{0}
+	for n, i := range slots {
+		if i != 0 && Selected(patterns, instance, n) {
+			saved = append(saved, i)
+		}
+	}
+	sort.Ints(saved)
+	return saved, nil
+}
+
+// SlotNames returns the names of slots in slot order.
+func SlotNames(slots map[string]int) []string {
+	names := make([]string, len(slots))
+	for n, i := range slots {
+		names[i] = n
+	}
+	return names
+}
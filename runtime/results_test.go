@@ -0,0 +1,185 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResults(t *testing.T) {
+	r := NewResults(2)
+	if err := r.Begin([]string{"time", "a"}); err != nil {
+		t.Fatal(err)
+	}
+	row := []float64{0, 10}
+	for i := 0; i < 3; i++ {
+		if err := r.Row(row); err != nil {
+			t.Fatal(err)
+		}
+		// rows are copied, so the slice can be reused
+		row[0]++
+		row[1] *= 2
+	}
+	if err := r.Row([]float64{1}); err == nil {
+		t.Error("Row with too few values succeeded")
+	}
+	if err := r.End(); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", r.Len())
+	}
+	if got, ok := r.Column("a"); !ok || !reflect.DeepEqual(got, []float64{10, 20, 40}) {
+		t.Errorf("Column(a) = %v, %v", got, ok)
+	}
+	if _, ok := r.Column("b"); ok {
+		t.Error("Column(b) found")
+	}
+	want := [2][]float64{{0, 1, 2}, {10, 20, 40}}
+	if got := r.Series("a"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Series(a) = %v, want %v", got, want)
+	}
+}
+
+// recorder is a ResultSink that keeps a copy of everything it's sent.
+type recorder struct {
+	names []string
+	rows  [][]float64
+	ended int
+}
+
+func (r *recorder) Begin(names []string) error {
+	r.names = names
+	return nil
+}
+
+func (r *recorder) Row(values []float64) error {
+	r.rows = append(r.rows, append([]float64{}, values...))
+	return nil
+}
+
+func (r *recorder) End() error {
+	r.ended++
+	return nil
+}
+
+func TestSink(t *testing.T) {
+	m := drainModel(Timespec{Start: 0, End: 4, DT: .5, SaveStep: 1})
+	stored := newTestSim(t, m, nil)
+	if err := stored.RunToEnd(); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &recorder{}
+	s := newTestSim(t, m, &Options{Sink: sink})
+	// results stream as the sim runs
+	if err := s.RunTo(2); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.rows) != 3 || sink.ended != 0 {
+		t.Errorf("%d rows and %d ends by time 2, want 3 and 0", len(sink.rows), sink.ended)
+	}
+	if err := s.RunToEnd(); err != nil {
+		t.Fatal(err)
+	}
+	if sink.ended != 1 {
+		t.Errorf("End called %d times", sink.ended)
+	}
+
+	if !reflect.DeepEqual(sink.names, stored.Results.Names) {
+		t.Fatalf("sink columns %v, stored %v", sink.names, stored.Results.Names)
+	}
+	if len(sink.rows) != stored.Results.Len() {
+		t.Fatalf("%d rows sent, %d stored", len(sink.rows), stored.Results.Len())
+	}
+	for i, row := range sink.rows {
+		for j, v := range row {
+			if stored.Results.Cols[j][i] != v {
+				t.Errorf("row %d, %s: sent %g, stored %g", i, sink.names[j], v, stored.Results.Cols[j][i])
+			}
+		}
+	}
+
+	if _, err := s.ValueSeries("level"); err == nil {
+		t.Error("ValueSeries succeeded with results sent to a sink")
+	}
+	if v, err := s.Value("level"); err != nil || v != stored.Curr[stored.Slots["level"]] {
+		t.Errorf("Value(level) = %g, %v with a sink", v, err)
+	}
+}
+
+func TestSelected(t *testing.T) {
+	for _, c := range []struct {
+		patterns []string
+		name     string
+		want     bool
+	}{
+		{nil, "births", true},
+		{[]string{"births"}, "births", true},
+		{[]string{"main.births"}, "births", true},
+		{[]string{"main.*"}, "births", true},
+		{[]string{"b*"}, "births", true},
+		{[]string{"deaths", "births"}, "births", true},
+		{[]string{"deaths"}, "births", false},
+		{[]string{"other.births"}, "births", false},
+		{[]string{"main.rabbits.*"}, "rabbits.births", true},
+		{[]string{"main.rabbits.*"}, "foxes.births", false},
+	} {
+		if got := Selected(c.patterns, "main", c.name); got != c.want {
+			t.Errorf("Selected(%q, main, %s) = %v", c.patterns, c.name, got)
+		}
+	}
+}
+
+func TestSaveSlots(t *testing.T) {
+	slots := map[string]int{"time": 0, "a": 1, "b": 2, "ab": 3}
+	for _, c := range []struct {
+		patterns []string
+		want     []int
+	}{
+		{nil, []int{0, 1, 2, 3}},
+		{[]string{"a*"}, []int{0, 1, 3}},
+		{[]string{"main.b", "main.a"}, []int{0, 1, 2}},
+		{[]string{"time"}, []int{0}},
+	} {
+		got, err := SaveSlots(c.patterns, "main", slots)
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("SaveSlots(%q) = %v, %v; want %v", c.patterns, got, err, c.want)
+		}
+	}
+	for _, p := range []string{"c", "main.[", "other.*"} {
+		if _, err := SaveSlots([]string{p}, "main", slots); err == nil {
+			t.Errorf("SaveSlots(%q) succeeded", p)
+		}
+	}
+}
+
+func TestSave(t *testing.T) {
+	m := drainModel(Timespec{Start: 0, End: 4, DT: 1, SaveStep: 1})
+	s := newTestSim(t, m, &Options{Save: []string{"main.level"}})
+	if err := s.RunToEnd(); err != nil {
+		t.Fatal(err)
+	}
+	// unselected variables aren't stored at all
+	if want := []string{"time", "level"}; !reflect.DeepEqual(s.Results.Names, want) {
+		t.Errorf("saved %v, want %v", s.Results.Names, want)
+	}
+	if _, err := s.ValueSeries("out"); err == nil {
+		t.Error("ValueSeries(out) succeeded")
+	}
+	if r, err := s.ValueSeries("level"); err != nil || len(r[1]) != 5 {
+		t.Errorf("ValueSeries(level) = %v, %v", r, err)
+	}
+	if _, err := s.Value("out"); err != nil {
+		t.Errorf("Value(out): %s", err)
+	}
+
+	s = newTestSim(t, m, &Options{Save: []string{"nothing"}})
+	if err := s.RunToEnd(); err == nil {
+		t.Error("RunToEnd succeeded saving a pattern matching nothing")
+	}
+}
@@ -5,9 +5,16 @@
 package runtime
 
 import (
+	"bufio"
+	"context"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"os/signal"
 	"sort"
+	"strings"
 )
 
 type chanReq struct {
@@ -33,7 +40,10 @@ outer:
 	for {
 		select {
 		case req := <-c.req:
-			val, _ := req.sim.Model().Default(req.name)
+			val, ok := overridden(req.sim, req.name)
+			if !ok {
+				val, _ = req.sim.Model().Default(req.name)
+			}
 			req.result <- val
 		case <-c.stop:
 			break outer
@@ -41,6 +51,19 @@ outer:
 	}
 }
 
+// an overrider is a Sim with its own values for some of its
+// model's constants.
+type overrider interface {
+	Override(name string) (float64, bool)
+}
+
+func overridden(s Sim, name string) (float64, bool) {
+	if o, ok := s.(overrider); ok {
+		return o.Override(name)
+	}
+	return 0, false
+}
+
 func (c *coordinator) Data(s Sim, name string) float64 {
 	result := make(chan float64)
 	c.req <- chanReq{s, name, result}
@@ -67,15 +90,84 @@ type Sim interface {
 	RunTo(t float64) error
 	RunToEnd() error
 
+	// RunToContext and RunToEndContext are like RunTo and
+	// RunToEnd, but stop with ctx.Err() if ctx is done before
+	// they finish.  The sim's sink isn't ended, so that the run
+	// can be resumed.
+	RunToContext(ctx context.Context, t float64) error
+	RunToEndContext(ctx context.Context) error
+
 	Value(name string) (float64, error)
 	ValueSeries(name string) ([2][]float64, error)
 
 	SetValue(name string, val float64) error
+
+	// Checkpoint writes the sim's state to w between time steps,
+	// and Restore resumes a new sim of the same model from a
+	// checkpoint; see the Checkpoint type.
+	Checkpoint(w io.Writer) error
+	Restore(r io.Reader) error
+
+	// Fork returns an independent copy of the sim, including the
+	// results it has stored; see the Fork function.
+	Fork() (Sim, error)
+}
+
+// Options control how a sim is created.  A nil *Options gives the
+// defaults.
+type Options struct {
+	// Seed for the sim's random number generator, or nil for
+	// DefaultSeed.  Any value, including zero, can be chosen.
+	Seed *int64
+
+	// Sink, if set, receives the sim's results as it runs,
+	// instead of them being stored for ValueSeries.
+	Sink ResultSink
+
+	// Save, if non-empty, limits the variables saved to those
+	// matched by its patterns; see Selected.  Unselected
+	// variables aren't stored at all.
+	Save []string
+
+	// Set overrides the values of model constants, by name or
+	// by name qualified with the sim's instance name.
+	Set map[string]float64
+
+	// Timespec overrides parts of the model's timespec, by the
+	// keys models use: start, end, dt and save_step.
+	Timespec map[string]float64
+
+	// Method is the integration method, Euler, RK2, RK4 or
+	// RK45, overriding the model's integration_method.
+	Method string
+
+	// RelTol and AbsTol are the relative and absolute error
+	// tolerances of adaptive methods; zero means DefaultRelTol
+	// and DefaultAbsTol.
+	RelTol float64
+	AbsTol float64
+
+	// CheckFinite makes RunTo stop with a *NonFiniteError as
+	// soon as a variable is NaN or infinite.
+	CheckFinite bool
+
+	// Events change constants and stocks during the run.
+	Events []Event
+
+	// StopWhen is a condition, like "population < 10", that
+	// ends the run after the first step it is true at,
+	// overriding the model's stop_when; see Condition.
+	StopWhen string
+
+	// Progress, if set, is called every PollEvery time steps
+	// with the time the sim has reached and the fraction of its
+	// run that is complete.
+	Progress func(t, frac float64)
 }
 
 type Model interface {
 	Name() string
-	NewSim(iName string, c Coordinator) Sim
+	NewSim(iName string, c Coordinator, opts *Options) Sim
 	Attr(name string) interface{}
 	VarNames() []string
 	Var(name string) (Var, bool)
@@ -85,12 +177,151 @@ type Model interface {
 
 // Init initializes the boosd runtime.
 func Main(m Model) {
+	// use our own FlagSet so that importing the runtime doesn't
+	// add flags to other programs, like the compiler.
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	internal := flags.Bool("internal", false,
+		"include internal variables, like the stocks of smooth and delay builtins, in the output")
+	seed := flags.Int64("seed", DefaultSeed,
+		"seed for the random number generator")
+	save := flags.String("save", "",
+		"comma-separated patterns, like main.rabbits.*, of the variables to save and print")
+	format := flags.String("format", "tsv",
+		"output format: tsv, csv, json or jsonl")
+	precision := flags.Int("precision", 6,
+		"digits after the decimal point, or -1 for the fewest digits that round-trip exactly")
+	outPath := flags.String("o", "",
+		"write output to this file instead of stdout")
+	meta := flags.Bool("meta", false,
+		"write a header with the model name, timespec, units, seed and run statistics")
+	set := setFlag{}
+	flags.Var(set, "set",
+		"override a constant, as name=value; can be repeated")
+	scenario := flags.String("scenario", "",
+		"JSON file of constants to override, mapping qualified names to values")
+	method := flags.String("method", "",
+		"integration method: euler, rk2, rk4 or rk45 (default the model's integration_method, or euler)")
+	rtol := flags.Float64("rtol", DefaultRelTol,
+		"relative error tolerance of the rk45 method")
+	atol := flags.Float64("atol", DefaultAbsTol,
+		"absolute error tolerance of the rk45 method")
+	check := flags.Bool("check", false,
+		"stop with an error as soon as a variable is NaN or infinite")
+	progress := flags.Bool("progress", false,
+		"report the time reached and the fraction of the run complete on stderr")
+	checkpoint := flags.String("checkpoint", "",
+		"write the sim's state to this file when the run ends or is interrupted")
+	restore := flags.String("restore", "",
+		"resume the run from a file written with -checkpoint")
+	eventsPath := flags.String("events", "",
+		"JSON file of events that set constants and stocks during the run")
+	stopWhen := flags.String("stop_when", "",
+		"end the run early once a condition, like \"population < 10\", is true (default the model's stop_when)")
+	timeFlags := map[string]*float64{}
+	for _, k := range []string{"start", "end", "dt", "save_step"} {
+		timeFlags[k] = flags.Float64(k, 0, "override the model's timespec "+k)
+	}
+	flags.Parse(os.Args[1:])
+
+	// -set takes precedence over the scenario file
+	overrides := map[string]float64{}
+	if *scenario != "" {
+		sc, err := ReadScenario(*scenario)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for n, v := range sc {
+			overrides[n] = v
+		}
+	}
+	for n, v := range set {
+		overrides[n] = v
+	}
+	timespec := map[string]float64{}
+	// the seed is only chosen by -seed, which may give the
+	// default seed's value.
+	var chosenSeed *int64
+	flags.Visit(func(f *flag.Flag) {
+		if p, ok := timeFlags[f.Name]; ok {
+			timespec[f.Name] = *p
+		}
+		if f.Name == "seed" {
+			chosenSeed = seed
+		}
+	})
+
+	f, err := ParseFormat(*format)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *precision < ExactPrecision {
+		log.Fatalf("bad precision %d", *precision)
+	}
+
+	var patterns []string
+	if *save != "" {
+		patterns = strings.Split(*save, ",")
+	}
+
+	var events []Event
+	if *eventsPath != "" {
+		if events, err = ReadEvents(*eventsPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var report func(t, frac float64)
+	if *progress {
+		report = func(t, frac float64) {
+			fmt.Fprintf(os.Stderr, "\rtime %g (%.0f%%)", t, 100*frac)
+		}
+	}
+
 	coord := NewCoordinator()
-	sim := m.NewSim("main", coord)
+	opts := &Options{
+		Seed:        chosenSeed,
+		Save:        patterns,
+		Set:         overrides,
+		Timespec:    timespec,
+		Method:      *method,
+		RelTol:      *rtol,
+		AbsTol:      *atol,
+		CheckFinite: *check,
+		Events:      events,
+		StopWhen:    *stopWhen,
+		Progress:    report,
+	}
+	sim := m.NewSim("main", coord, opts)
+	if *restore != "" {
+		if err := restoreFile(sim, *restore); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	if err := sim.RunToEnd(); err != nil {
+	// the first interrupt stops the run, and the results saved
+	// so far are written out; a second one kills the process.
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		signal.Stop(interrupts)
+		cancel()
+	}()
+
+	err = sim.RunToEndContext(ctx)
+	if *progress {
+		fmt.Fprintln(os.Stderr)
+	}
+	interrupted := err == context.Canceled
+	if err != nil && !interrupted {
 		log.Fatalf("sim.RunToEnd: %s", err)
 	}
+	if *checkpoint != "" {
+		if err := checkpointFile(sim, *checkpoint); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	tsRaw, err := sim.ValueSeries("time")
 	if err != nil {
@@ -99,6 +330,7 @@ func Main(m Model) {
 	timeSeries := tsRaw[1]
 
 	series := map[string][]float64{}
+	units := map[string]string{}
 	orderedVars := sort.StringSlice{}
 
 	for _, v := range sim.Model().VarNames() {
@@ -106,7 +338,10 @@ func Main(m Model) {
 		if !ok {
 			log.Fatalf("sim.Model().Var(%s): not ok", v)
 		}
-		if vv.Type == TyTable {
+		if vv.Type == TyTable || (vv.Internal && !*internal) {
+			continue
+		}
+		if !Selected(patterns, "main", v) {
 			continue
 		}
 
@@ -116,22 +351,152 @@ func Main(m Model) {
 			log.Fatalf("sim.ValueSeries(%s): %s", v, err)
 		}
 		series[qualName] = data[1]
+		if vv.Units != "" {
+			units[qualName] = vv.Units
+		}
 		orderedVars = append(orderedVars, qualName)
 	}
 
 	orderedVars.Sort()
 
-	fmt.Printf("time")
-	for _, v := range orderedVars {
-		fmt.Printf("\t%s", v)
+	// applied overrides are always echoed, so that output can
+	// be traced back to the run that produced it.
+	applied := map[string]float64{}
+	for n, v := range overrides {
+		applied["main."+strings.TrimPrefix(n, "main.")] = v
+	}
+	for k, v := range timespec {
+		applied[k] = v
+	}
+	var fired []FiredEvent
+	if e, ok := sim.(eventer); ok {
+		fired = e.Events()
+	}
+	var stopped *Stop
+	if s, ok := sim.(stopper); ok {
+		stopped = s.Stopped()
 	}
-	fmt.Printf("\n")
 
+	var md *Metadata
+	if *meta {
+		md = &Metadata{Model: m.Name(), Seed: seed, Units: units, Overrides: applied, Events: fired, Stopped: stopped}
+		if ts, ok := sim.(timespecer); ok {
+			t := ts.Timespec()
+			md.Time = &t
+		}
+		if st, ok := sim.(statser); ok {
+			stats := st.Stats()
+			md.Stats = &stats
+		}
+	} else {
+		md = &Metadata{Overrides: applied, Events: fired, Stopped: stopped}
+		if recordSeed(m, opts) {
+			md.Seed = seed
+		}
+		// adaptive methods take as many steps as the model
+		// needs, so how many is always reported
+		if name, _ := Method(m, &Options{Method: *method}); name == RK45 {
+			if st, ok := sim.(statser); ok {
+				stats := st.Stats()
+				md.Stats = &stats
+			}
+		}
+		if md.Seed == nil && md.Stats == nil && len(applied) == 0 && len(fired) == 0 && stopped == nil {
+			md = nil
+		}
+	}
+
+	out := os.Stdout
+	if *outPath != "" {
+		if out, err = os.Create(*outPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	sink := NewFormatSink(out, f, *precision, md)
+	if err := sink.Begin(append([]string{"time"}, orderedVars...)); err != nil {
+		log.Fatal(err)
+	}
+	row := make([]float64, len(orderedVars)+1)
 	for i, t := range timeSeries {
-		fmt.Printf("%f", t)
-		for _, v := range orderedVars {
-			fmt.Printf("\t%f", series[v][i])
+		row[0] = t
+		for j, v := range orderedVars {
+			row[j+1] = series[v][i]
+		}
+		if err := sink.Row(row); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := sink.End(); err != nil {
+		log.Fatal(err)
+	}
+	if *outPath != "" {
+		if err := out.Close(); err != nil {
+			log.Fatal(err)
 		}
-		fmt.Printf("\n")
 	}
+
+	if interrupted {
+		now, _ := sim.Value("time")
+		log.Fatalf("interrupted at time %g", now)
+	}
+}
+
+// recordSeed reports whether the output of a run of m with opts
+// records the seed, even without -meta: whenever opts choose it, and
+// for stochastic models, as the seed is all it takes to reproduce
+// their runs.
+func recordSeed(m Model, opts *Options) bool {
+	if opts != nil && opts.Seed != nil {
+		return true
+	}
+	stochastic, _ := m.Attr("stochastic").(bool)
+	return stochastic
+}
+
+// a timespecer is a Sim that can report its timespec.
+type timespecer interface {
+	Timespec() Timespec
+}
+
+func restoreFile(sim Sim, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return sim.Restore(bufio.NewReader(f))
+}
+
+func checkpointFile(sim Sim, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := sim.Checkpoint(w); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// an eventer is a Sim that can report the events that happened
+// during its run.
+type eventer interface {
+	Events() []FiredEvent
+}
+
+// a stopper is a Sim that can report whether its run ended early.
+type stopper interface {
+	Stopped() *Stop
+}
+
+// a statser is a Sim that can report the steps it has taken.
+type statser interface {
+	Stats() Stats
 }
@@ -0,0 +1,308 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"math"
+	"strings"
+)
+
+// Uniflow returns v if it is positive, and 0 otherwise.  It is used
+// to compute the value of flows declared as uniflows.
+func Uniflow(v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	return v
+}
+
+// stockFlows are the slots of a stock's flows.
+type stockFlows struct {
+	Inflows  []int
+	Outflows []int
+	Capacity float64
+}
+
+// stockFlowSlots returns, indexed by slot, the flows of each stock
+// in m that has any.
+func stockFlowSlots(m *BaseModel) []*stockFlows {
+	flows := make([]*stockFlows, len(m.Slots))
+	for name, v := range m.Vars {
+		if len(v.Inflows) == 0 && len(v.Outflows) == 0 {
+			continue
+		}
+		sf := &stockFlows{Capacity: v.Capacity}
+		for _, f := range v.Inflows {
+			sf.Inflows = append(sf.Inflows, m.Slots[f])
+		}
+		for _, f := range v.Outflows {
+			sf.Outflows = append(sf.Outflows, m.Slots[f])
+		}
+		flows[m.Slots[name]] = sf
+	}
+	return flows
+}
+
+// LimitOutflows scales back the outflows of the non-negative stock
+// in slot stock so that, after this time step, the stock is exactly
+// zero rather than negative.  The scaled flow values are written
+// back to Curr, so the reported flows and any stock they are an
+// inflow to see the amount that was actually removed.
+//
+// The material available to leave the stock is its current value
+// plus everything flowing in this step.  If the outflows ask for
+// more than that, every positive outflow is multiplied by the same
+// fraction, available/requested: the shortfall is split across the
+// outflows in proportion to their size.  Negative outflows (which
+// add to the stock) are left alone and counted as inflows.
+//
+// Stocks are limited in declaration order, so when one
+// non-negative stock drains into another, the upstream stock should
+// be declared first.
+func (s *BaseSim) LimitOutflows(stock int, dt float64) {
+	v := s.flows[stock]
+	if v == nil {
+		return
+	}
+
+	available := s.Curr[stock]
+	for _, f := range v.Inflows {
+		available += s.Curr[f] * dt
+	}
+	var requested float64
+	for _, f := range v.Outflows {
+		if out := s.Curr[f]; out > 0 {
+			requested += out * dt
+		} else {
+			available -= out * dt
+		}
+	}
+	if requested <= available {
+		return
+	}
+	if available < 0 {
+		available = 0
+	}
+
+	scale := available / requested
+	for _, f := range v.Outflows {
+		if s.Curr[f] > 0 {
+			s.Curr[f] *= scale
+		}
+	}
+}
+
+// LimitInflows scales back the inflows of the stock in slot stock
+// so that it doesn't hold more than its capacity after this time
+// step.  Room is made by whatever flows out this step, and as with
+// LimitOutflows every positive inflow is scaled by the same
+// fraction.
+func (s *BaseSim) LimitInflows(stock int, dt float64) {
+	v := s.flows[stock]
+	if v == nil || v.Capacity <= 0 {
+		return
+	}
+
+	room := v.Capacity - s.Curr[stock]
+	for _, f := range v.Outflows {
+		room += s.Curr[f] * dt
+	}
+	var requested float64
+	for _, f := range v.Inflows {
+		if in := s.Curr[f]; in > 0 {
+			requested += in * dt
+		} else {
+			room -= in * dt
+		}
+	}
+	if requested <= room {
+		return
+	}
+	if room < 0 {
+		room = 0
+	}
+
+	scale := room / requested
+	for _, f := range v.Inflows {
+		if s.Curr[f] > 0 {
+			s.Curr[f] *= scale
+		}
+	}
+}
+
+// StockFlavor describes how material moves through a stock.
+type StockFlavor int
+
+const (
+	// StockReservoir stocks are well mixed: their outflows are
+	// independent of when material arrived.
+	StockReservoir StockFlavor = iota
+	// StockConveyor stocks hold material for a fixed transit
+	// time.
+	StockConveyor
+	// StockQueue stocks release material first in, first out.
+	StockQueue
+)
+
+var flavorPretty = map[StockFlavor]string{
+	StockReservoir: "StockReservoir",
+	StockConveyor:  "StockConveyor",
+	StockQueue:     "StockQueue",
+}
+
+func (f StockFlavor) String() string {
+	return flavorPretty[f]
+}
+
+// Name returns the name models use for the flavor f, like conveyor.
+func (f StockFlavor) Name() string {
+	return strings.ToLower(strings.TrimPrefix(f.String(), "Stock"))
+}
+
+// A Conveyor is the state of a conveyor stock.  Material is carried
+// on Slats, one per time step of transit; Slats[Head] is the next
+// slat to reach the end of the belt.  While it is carried, each
+// slat loses the fraction Leak of its contents every time step.
+type Conveyor struct {
+	Slats []float64
+	Head  int
+	Leak  float64
+}
+
+// NewConveyor returns a conveyor with the given transit time,
+// holding initial spread evenly along its length.  leakFraction is
+// the fraction of material put on the belt that leaks off before
+// reaching the end.
+func NewConveyor(initial, transit, dt, leakFraction float64) *Conveyor {
+	n := int(transit/dt + .5)
+	if n < 1 {
+		n = 1
+	}
+	c := &Conveyor{Slats: make([]float64, n)}
+	for i := range c.Slats {
+		c.Slats[i] = initial / float64(n)
+	}
+	// material on the belt is carried for n-1 steps before the
+	// slat it is on reaches the end, so leaking a constant
+	// fraction of each slat per step compounds to leakFraction.
+	if n > 1 && leakFraction > 0 {
+		c.Leak = 1 - math.Pow(1-leakFraction, 1/float64(n-1))
+	}
+	return c
+}
+
+// Outflow returns the rate at which material comes off the end of
+// the conveyor this time step.
+func (c *Conveyor) Outflow(dt float64) float64 {
+	return c.Slats[c.Head] / dt
+}
+
+// Leakage returns the rate at which material leaks off the conveyor
+// this time step.
+func (c *Conveyor) Leakage(dt float64) float64 {
+	var leaked float64
+	for i, v := range c.Slats {
+		if i != c.Head {
+			leaked += v * c.Leak
+		}
+	}
+	return leaked / dt
+}
+
+// Advance moves the conveyor one time step, removing the material
+// reported by Leakage and out of that reported by Outflow, and
+// putting in on the belt.  out is less than Outflow reported when
+// the conveyor's outflow was limited, as by a full stock downstream;
+// the rest waits at the end of the belt.  It returns the total
+// amount of material left on the conveyor.
+func (c *Conveyor) Advance(in, out float64) float64 {
+	var total float64
+	for i := range c.Slats {
+		if i != c.Head {
+			c.Slats[i] *= 1 - c.Leak
+			total += c.Slats[i]
+		}
+	}
+	held := c.Slats[c.Head] - out
+	if held < 0 {
+		held = 0
+	}
+	// the slat that just emptied becomes the back of the belt
+	c.Slats[c.Head] = in
+	c.Head = (c.Head + 1) % len(c.Slats)
+	c.Slats[c.Head] += held
+	return total + in + held
+}
+
+// Total returns the amount of material on the conveyor.
+func (c *Conveyor) Total() float64 {
+	var total float64
+	for _, v := range c.Slats {
+		total += v
+	}
+	return total
+}
+
+// A Batch is an amount of material that entered a queue at the
+// same time.
+type Batch struct {
+	Amount  float64
+	Arrived float64
+}
+
+// A Queue is the state of a queue stock: the batches of material it
+// holds, oldest first.
+type Queue struct {
+	Batches []Batch
+}
+
+// NewQueue returns a queue holding initial, which is treated as a
+// single batch that arrived at t.
+func NewQueue(initial, t float64) *Queue {
+	q := &Queue{}
+	if initial > 0 {
+		q.Batches = append(q.Batches, Batch{initial, t})
+	}
+	return q
+}
+
+// Advance adds in to the back of the queue as a batch arriving at
+// t, and removes out from the front, oldest batches first.  It
+// returns the total amount of material left in the queue.  Outflows
+// from queues are limited by BaseSim.LimitOutflows, so out is never
+// more than the queue holds.
+func (q *Queue) Advance(in, out, t float64) float64 {
+	if in > 0 {
+		q.Batches = append(q.Batches, Batch{in, t})
+	}
+	for out > 0 && len(q.Batches) > 0 {
+		b := &q.Batches[0]
+		if b.Amount > out {
+			b.Amount -= out
+			break
+		}
+		out -= b.Amount
+		q.Batches = q.Batches[1:]
+	}
+	return q.Total()
+}
+
+// Total returns the amount of material in the queue.
+func (q *Queue) Total() float64 {
+	var total float64
+	for _, b := range q.Batches {
+		total += b.Amount
+	}
+	return total
+}
+
+// OldestArrival returns the time the oldest material in the queue
+// arrived, and false if the queue is empty.
+func (q *Queue) OldestArrival() (float64, bool) {
+	if len(q.Batches) == 0 {
+		return 0, false
+	}
+	return q.Batches[0].Arrived, true
+}
@@ -0,0 +1,229 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+var stockTime = Timespec{Start: 0, End: 10, DT: 1, SaveStep: 1}
+
+// flowVars returns a stock with the given flows, and the flows.
+func flowVars(stock Var, inflows, outflows []string) []Var {
+	stock.Type = TyStock
+	stock.Inflows, stock.Outflows = inflows, outflows
+	vars := []Var{stock}
+	for _, f := range append(append([]string{}, inflows...), outflows...) {
+		vars = append(vars, Var{Name: f, Type: TyFlow})
+	}
+	return vars
+}
+
+func TestStockFlowSlots(t *testing.T) {
+	m := newTestModel(stockTime,
+		flowVars(Var{Name: "s"}, []string{"a", "b"}, []string{"c", "d"})...)
+	m.Vars["lone"] = Var{Name: "lone", Type: TyStock}
+	m.Slots["lone"] = len(m.Slots)
+
+	flows := stockFlowSlots(&m.BaseModel)
+	if len(flows) != len(m.Slots) {
+		t.Fatalf("%d entries for %d slots", len(flows), len(m.Slots))
+	}
+	sf := flows[m.Slots["s"]]
+	if sf == nil {
+		t.Fatal("no flows for s")
+	}
+	wantIn := []int{m.Slots["a"], m.Slots["b"]}
+	wantOut := []int{m.Slots["c"], m.Slots["d"]}
+	if !reflect.DeepEqual(sf.Inflows, wantIn) || !reflect.DeepEqual(sf.Outflows, wantOut) {
+		t.Errorf("flows of s = %v, %v; want %v, %v", sf.Inflows, sf.Outflows, wantIn, wantOut)
+	}
+	if flows[m.Slots["lone"]] != nil {
+		t.Errorf("lone has flows %v", flows[m.Slots["lone"]])
+	}
+}
+
+func TestMultipleFlows(t *testing.T) {
+	m := newTestModel(stockTime,
+		flowVars(Var{Name: "s"}, []string{"a", "b"}, []string{"c", "d"})...)
+	rates := map[string]float64{"a": 3, "b": 2, "c": 1, "d": .5}
+	m.initial = func(s *BaseSim, slot func(string) int) {
+		s.Curr[slot("s")] = 10
+	}
+	m.flows = func(s *BaseSim, slot func(string) int, dt float64) {
+		for n, v := range rates {
+			s.Curr[slot(n)] = v
+		}
+	}
+	m.stocks = func(s *BaseSim, slot func(string) int, dt float64) {
+		v := s.Parent.(*testModel).Vars["s"]
+		net := 0.
+		for _, f := range v.Inflows {
+			net += s.Curr[slot(f)]
+		}
+		for _, f := range v.Outflows {
+			net -= s.Curr[slot(f)]
+		}
+		s.Next[slot("s")] = s.Curr[slot("s")] + net*dt
+	}
+
+	s := newTestSim(t, m, nil)
+	if err := s.RunToEnd(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Value("s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// (3 + 2 - 1 - .5) for 11 steps
+	if want := 10 + 3.5*11; got != want {
+		t.Errorf("s = %g, want %g", got, want)
+	}
+}
+
+func TestUniflow(t *testing.T) {
+	for _, c := range []struct{ in, want float64 }{
+		{3, 3},
+		{0, 0},
+		{-2, 0},
+	} {
+		if got := Uniflow(c.in); got != c.want {
+			t.Errorf("Uniflow(%g) = %g, want %g", c.in, got, c.want)
+		}
+	}
+}
+
+// limitSim returns a sim of a stock s with inflows in and outflows
+// out, with the given values.
+func limitSim(t *testing.T, stock Var, s float64, in, out []float64) (*BaseSim, []string, []string) {
+	var inNames, outNames []string
+	for i := range in {
+		inNames = append(inNames, "in"+string(rune('a'+i)))
+	}
+	for i := range out {
+		outNames = append(outNames, "out"+string(rune('a'+i)))
+	}
+	stock.Name = "s"
+	sim := newTestSim(t, newTestModel(stockTime, flowVars(stock, inNames, outNames)...), nil)
+	sim.Curr[sim.Slots["s"]] = s
+	for i, n := range inNames {
+		sim.Curr[sim.Slots[n]] = in[i]
+	}
+	for i, n := range outNames {
+		sim.Curr[sim.Slots[n]] = out[i]
+	}
+	return sim, inNames, outNames
+}
+
+func TestLimitOutflows(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		stock   float64
+		in, out []float64
+		dt      float64
+		want    []float64
+	}{
+		{"enough", 10, nil, []float64{2, 3}, 1, []float64{2, 3}},
+		{"scaled in proportion", 10, nil, []float64{10, 30}, 1, []float64{2.5, 7.5}},
+		{"inflows count", 10, []float64{10}, []float64{15, 15}, 1, []float64{10, 10}},
+		{"negative outflows count as inflows", 0, nil, []float64{-5, 10}, 1, []float64{-5, 5}},
+		{"negative stock", -5, nil, []float64{5}, 1, []float64{0}},
+		{"dt", 10, nil, []float64{40}, .5, []float64{20}},
+	} {
+		s, _, out := limitSim(t, Var{NonNegative: true}, c.stock, c.in, c.out)
+		s.LimitOutflows(s.Slots["s"], c.dt)
+		for i, n := range out {
+			if got := s.Curr[s.Slots[n]]; got != c.want[i] {
+				t.Errorf("%s: %s = %g, want %g", c.name, n, got, c.want[i])
+			}
+		}
+	}
+}
+
+func TestLimitInflows(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		capacity float64
+		stock    float64
+		in, out  []float64
+		want     []float64
+	}{
+		{"room", 100, 50, []float64{20, 20}, nil, []float64{20, 20}},
+		{"scaled in proportion", 100, 90, []float64{10, 30}, nil, []float64{2.5, 7.5}},
+		{"outflows make room", 100, 90, []float64{20, 20}, []float64{5}, []float64{7.5, 7.5}},
+		{"full", 100, 110, []float64{20}, nil, []float64{0}},
+		{"no capacity", 0, 1000, []float64{20}, nil, []float64{20}},
+	} {
+		s, in, _ := limitSim(t, Var{Capacity: c.capacity}, c.stock, c.in, c.out)
+		s.LimitInflows(s.Slots["s"], 1)
+		for i, n := range in {
+			if got := s.Curr[s.Slots[n]]; got != c.want[i] {
+				t.Errorf("%s: %s = %g, want %g", c.name, n, got, c.want[i])
+			}
+		}
+	}
+}
+
+func TestConveyor(t *testing.T) {
+	c := NewConveyor(40, 4, 1, 0)
+	if got := c.Total(); got != 40 {
+		t.Errorf("initial total %g, want 40", got)
+	}
+	// the initial contents come off a quarter at a time, then
+	// what was put on four steps earlier.
+	for i, want := range []float64{10, 10, 10, 10, 5, 5} {
+		out := c.Outflow(1)
+		if out != want {
+			t.Errorf("step %d: outflow %g, want %g", i, out, want)
+		}
+		c.Advance(5, out)
+	}
+
+	c = NewConveyor(0, 4, 1, .1)
+	c.Advance(100, 0)
+	var leaked float64
+	for i := 0; i < 3; i++ {
+		leaked += c.Leakage(1)
+		c.Advance(0, c.Outflow(1))
+	}
+	if got := c.Outflow(1); math.Abs(got-90) > 1e-9 {
+		t.Errorf("outflow after leaking %g, want 90", got)
+	}
+	if math.Abs(leaked-10) > 1e-9 {
+		t.Errorf("leaked %g, want 10", leaked)
+	}
+
+	// material held back waits at the end of the belt
+	c = NewConveyor(40, 4, 1, 0)
+	if got := c.Advance(5, 4); got != 41 {
+		t.Errorf("total %g after holding back 6, want 41", got)
+	}
+	if got := c.Outflow(1); got != 16 {
+		t.Errorf("outflow %g after holding back 6, want 16", got)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	q := NewQueue(0, 0)
+	if _, ok := q.OldestArrival(); ok {
+		t.Error("empty queue has an oldest arrival")
+	}
+	q.Advance(5, 0, 1)
+	if got := q.Advance(3, 0, 2); got != 8 {
+		t.Errorf("total %g, want 8", got)
+	}
+	// first in, first out
+	if got := q.Advance(0, 6, 3); got != 2 {
+		t.Errorf("total %g, want 2", got)
+	}
+	if at, ok := q.OldestArrival(); !ok || at != 2 {
+		t.Errorf("oldest arrival %g, %v; want 2", at, ok)
+	}
+	if got := q.Advance(0, 10, 4); got != 0 {
+		t.Errorf("total %g after emptying, want 0", got)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"fmt"
+)
+
+// A Stop records a run that ended early, when its stop condition
+// became true.
+type Stop struct {
+	// Time is the time of the step the condition was true at,
+	// which is the last one saved.
+	Time   float64 `json:"time"`
+	Reason string  `json:"reason"`
+}
+
+// StopVar is the internal variable a model's stop_when equation is
+// computed into, each step: 1 once the run should end, and 0 until
+// then.  The model's stop_when attribute is the equation's source.
+const StopVar = ".stop_when"
+
+// StopCondition returns the condition that ends runs of the sim
+// instance of m early: that of opts, or else the model's stop_when
+// equation.  It returns nil if neither has one.
+func StopCondition(m Model, instance string, opts *Options) (*Condition, error) {
+	if opts != nil && opts.StopWhen != "" {
+		c, err := ParseCondition(opts.StopWhen)
+		if err == nil {
+			err = c.Resolve(m, instance)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("stop_when: %s", err)
+		}
+		return c, nil
+	}
+	src, _ := m.Attr("stop_when").(string)
+	if src == "" {
+		return nil, nil
+	}
+	if _, ok := m.Var(StopVar); !ok {
+		return nil, fmt.Errorf("stop_when %q: model has no %s", src, StopVar)
+	}
+	return &Condition{src: src, or: [][]*comparison{{{lhs: StopVar, op: "!=", num: 0}}}}, nil
+}
+
+// Stopped reports whether the condition c is true of the values
+// curr of a time step, laid out by slots, returning a *Stop if so.
+// A nil c is never true.
+func Stopped(c *Condition, slots map[string]int, curr []float64) (*Stop, error) {
+	if c == nil {
+		return nil, nil
+	}
+	ok, err := c.Eval(SlotValue(slots, curr))
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &Stop{Time: curr[0], Reason: c.String()}, nil
+}
@@ -0,0 +1,271 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// LookupMode selects what a table returns between and beyond its
+// points.
+type LookupMode int
+
+const (
+	// LookupInterpolate interpolates linearly between points,
+	// and returns the first or last y outside of the table.
+	LookupInterpolate LookupMode = iota
+	// LookupExtrapolate interpolates linearly between points,
+	// and continues the first or last segment outside of the
+	// table.
+	LookupExtrapolate
+	// LookupDiscrete returns the y of the last point at or
+	// before x, like a step function, and the first y before
+	// the table.
+	LookupDiscrete
+)
+
+var lookupPretty = map[LookupMode]string{
+	LookupInterpolate: "interpolate",
+	LookupExtrapolate: "extrapolate",
+	LookupDiscrete:    "discrete",
+}
+
+func (m LookupMode) String() string {
+	return lookupPretty[m]
+}
+
+// A Table is a graphical function, defined by points with strictly
+// increasing X values.
+type Table struct {
+	X    []float64
+	Y    []float64
+	Mode LookupMode
+}
+
+// Validate returns an error if t isn't a usable table: it must have
+// at least two points, and strictly increasing X values.
+func (t Table) Validate() error {
+	if len(t.X) != len(t.Y) {
+		return fmt.Errorf("%d x values but %d y values", len(t.X), len(t.Y))
+	}
+	if len(t.X) < 2 {
+		return fmt.Errorf("needs at least 2 points, not %d", len(t.X))
+	}
+	for i := 1; i < len(t.X); i++ {
+		if t.X[i] <= t.X[i-1] {
+			return fmt.Errorf("x values not strictly increasing at point %d (%g after %g)",
+				i, t.X[i], t.X[i-1])
+		}
+	}
+	return nil
+}
+
+// search returns the index of the first point with an x value
+// greater than or equal to index.
+func (t Table) search(index float64) int {
+	low, high := 0, len(t.X)
+	for low < high {
+		mid := low + (high-low)/2
+		if t.X[mid] < index {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+	return low
+}
+
+// interp linearly interpolates (or extrapolates) along the segment
+// between points i-1 and i.
+func (t Table) interp(i int, index float64) float64 {
+	x, y := t.X, t.Y
+	slope := (y[i] - y[i-1]) / (x[i] - x[i-1])
+	return (index-x[i-1])*slope + y[i-1]
+}
+
+func (t Table) Lookup(index float64) float64 {
+	size := len(t.X)
+	if size == 0 {
+		return 0
+	}
+
+	x := t.X
+	y := t.Y
+
+	if size == 1 {
+		return y[0]
+	}
+	if index < x[0] {
+		if t.Mode == LookupExtrapolate {
+			return t.interp(1, index)
+		}
+		return y[0]
+	} else if index > x[size-1] {
+		if t.Mode == LookupExtrapolate {
+			return t.interp(size-1, index)
+		}
+		return y[size-1]
+	}
+
+	i := t.search(index)
+	if x[i] == index {
+		return y[i]
+	}
+	if t.Mode == LookupDiscrete {
+		return y[i-1]
+	}
+	return t.interp(i, index)
+}
+
+// Inverse returns the smallest x within the table's domain at which
+// interpolating the table gives y, and false if there is none.  It
+// is most useful for monotonic tables, where that x is unique.
+func (t Table) Inverse(y float64) (float64, bool) {
+	for i := 1; i < len(t.X); i++ {
+		y0, y1 := t.Y[i-1], t.Y[i]
+		if y0 == y {
+			return t.X[i-1], true
+		}
+		if (y0 < y && y < y1) || (y1 < y && y < y0) {
+			if t.Mode == LookupDiscrete {
+				return t.X[i], y1 == y
+			}
+			frac := (y - y0) / (y1 - y0)
+			return t.X[i-1] + frac*(t.X[i]-t.X[i-1]), true
+		}
+	}
+	if n := len(t.Y); n > 0 && t.Y[n-1] == y {
+		return t.X[n-1], true
+	}
+	return 0, false
+}
+
+// Domain returns the smallest and largest x values in the table.
+func (t Table) Domain() (min, max float64) {
+	if len(t.X) == 0 {
+		return 0, 0
+	}
+	return t.X[0], t.X[len(t.X)-1]
+}
+
+// Range returns the smallest and largest y values in the table.
+func (t Table) Range() (min, max float64) {
+	if len(t.Y) == 0 {
+		return 0, 0
+	}
+	min, max = math.Inf(1), math.Inf(-1)
+	for _, y := range t.Y {
+		min = math.Min(min, y)
+		max = math.Max(max, y)
+	}
+	return
+}
+
+// Resample returns a table with n evenly spaced points across t's
+// domain, taking their values from t.Lookup.
+func (t Table) Resample(n int) Table {
+	r := Table{X: make([]float64, n), Y: make([]float64, n), Mode: t.Mode}
+	lo, hi := t.Domain()
+	for i := range r.X {
+		x := lo
+		if n > 1 {
+			x = lo + (hi-lo)*float64(i)/float64(n-1)
+		}
+		r.X[i] = x
+		r.Y[i] = t.Lookup(x)
+	}
+	return r
+}
+
+// A Table2D is a graphical function of two inputs, defined by a grid
+// of values: Z[i][j] is the table's value at (X[i], Y[j]).  Lookups
+// between grid points interpolate bilinearly, and Mode applies
+// along each axis as it does for Table.
+type Table2D struct {
+	X    []float64
+	Y    []float64
+	Z    [][]float64
+	Mode LookupMode
+}
+
+// validAxis returns an error unless axis has at least two strictly
+// increasing values.
+func validAxis(name string, axis []float64) error {
+	if len(axis) < 2 {
+		return fmt.Errorf("%s needs at least 2 values, not %d", name, len(axis))
+	}
+	for i := 1; i < len(axis); i++ {
+		if axis[i] <= axis[i-1] {
+			return fmt.Errorf("%s values not strictly increasing at %d (%g after %g)",
+				name, i, axis[i], axis[i-1])
+		}
+	}
+	return nil
+}
+
+// Validate returns an error if t isn't a usable table: both axes need
+// at least two strictly increasing values, and the grid must have a
+// row for every x value and a column for every y value.
+func (t Table2D) Validate() error {
+	if err := validAxis("x", t.X); err != nil {
+		return err
+	}
+	if err := validAxis("y", t.Y); err != nil {
+		return err
+	}
+	if len(t.Z) != len(t.X) {
+		return fmt.Errorf("%d rows for %d x values", len(t.Z), len(t.X))
+	}
+	for i, row := range t.Z {
+		if len(row) != len(t.Y) {
+			return fmt.Errorf("row %d has %d values for %d y values",
+				i, len(row), len(t.Y))
+		}
+	}
+	return nil
+}
+
+// segment returns the index i of the segment between axis[i] and
+// axis[i+1] to use for v, along with how far along it v lies.  The
+// fraction is outside [0, 1] only when extrapolating.
+func segment(axis []float64, v float64, mode LookupMode) (int, float64) {
+	n := len(axis)
+	if v <= axis[0] {
+		if mode == LookupExtrapolate {
+			return 0, (v - axis[0]) / (axis[1] - axis[0])
+		}
+		return 0, 0
+	} else if v >= axis[n-1] {
+		if mode == LookupExtrapolate {
+			return n - 2, (v - axis[n-2]) / (axis[n-1] - axis[n-2])
+		}
+		return n - 2, 1
+	}
+
+	// the first value greater than or equal to v
+	i := sort.SearchFloat64s(axis, v)
+	if axis[i] == v {
+		return i - 1, 1
+	}
+	if mode == LookupDiscrete {
+		return i - 1, 0
+	}
+	return i - 1, (v - axis[i-1]) / (axis[i] - axis[i-1])
+}
+
+func (t Table2D) Lookup(x, y float64) float64 {
+	if len(t.X) < 2 || len(t.Y) < 2 {
+		return 0
+	}
+
+	i, fx := segment(t.X, x, t.Mode)
+	j, fy := segment(t.Y, y, t.Mode)
+
+	z0 := t.Z[i][j]*(1-fy) + t.Z[i][j+1]*fy
+	z1 := t.Z[i+1][j]*(1-fy) + t.Z[i+1][j+1]*fy
+	return z0*(1-fx) + z1*fx
+}
@@ -0,0 +1,165 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"reflect"
+	"testing"
+)
+
+var rising = Table{X: []float64{0, 10, 20}, Y: []float64{0, 5, 20}}
+
+func TestTableLookup(t *testing.T) {
+	for _, c := range []struct {
+		mode LookupMode
+		x    float64
+		want float64
+	}{
+		{LookupInterpolate, -5, 0},
+		{LookupInterpolate, 0, 0},
+		{LookupInterpolate, 5, 2.5},
+		{LookupInterpolate, 10, 5},
+		{LookupInterpolate, 15, 12.5},
+		{LookupInterpolate, 30, 20},
+		{LookupExtrapolate, -5, -2.5},
+		{LookupExtrapolate, 15, 12.5},
+		{LookupExtrapolate, 30, 35},
+		{LookupDiscrete, -5, 0},
+		{LookupDiscrete, 5, 0},
+		{LookupDiscrete, 10, 5},
+		{LookupDiscrete, 19.9, 5},
+		{LookupDiscrete, 30, 20},
+	} {
+		tab := rising
+		tab.Mode = c.mode
+		if got := tab.Lookup(c.x); got != c.want {
+			t.Errorf("%s Lookup(%g) = %g, want %g", c.mode, c.x, got, c.want)
+		}
+	}
+}
+
+func TestTableValidate(t *testing.T) {
+	for _, c := range []struct {
+		tab Table
+		ok  bool
+	}{
+		{rising, true},
+		{Table{X: []float64{0, 1}, Y: []float64{3, 3}}, true},
+		{Table{X: []float64{0}, Y: []float64{1}}, false},
+		{Table{X: []float64{0, 1}, Y: []float64{1}}, false},
+		{Table{X: []float64{0, 1, 1}, Y: []float64{1, 2, 3}}, false},
+		{Table{X: []float64{0, 2, 1}, Y: []float64{1, 2, 3}}, false},
+	} {
+		if err := c.tab.Validate(); (err == nil) != c.ok {
+			t.Errorf("Validate(%v) = %v", c.tab, err)
+		}
+	}
+}
+
+func TestTableInverse(t *testing.T) {
+	falling := Table{X: []float64{0, 1, 2}, Y: []float64{10, 6, 0}}
+	peak := Table{X: []float64{0, 1, 2}, Y: []float64{0, 10, 0}}
+	steps := Table{X: []float64{0, 1, 2}, Y: []float64{0, 10, 20}, Mode: LookupDiscrete}
+	for _, c := range []struct {
+		tab  Table
+		y    float64
+		want float64
+		ok   bool
+	}{
+		{rising, 2.5, 5, true},
+		{rising, 0, 0, true},
+		{rising, 20, 20, true},
+		{rising, 12.5, 15, true},
+		{rising, 21, 0, false},
+		{falling, 8, .5, true},
+		{falling, 3, 1.5, true},
+		{peak, 5, .5, true},
+		{steps, 10, 1, true},
+		{steps, 5, 0, false},
+	} {
+		got, ok := c.tab.Inverse(c.y)
+		if ok != c.ok || (ok && got != c.want) {
+			t.Errorf("%v Inverse(%g) = %g, %v; want %g, %v", c.tab.Y, c.y, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestTableDomainRange(t *testing.T) {
+	tab := Table{X: []float64{-1, 0, 4}, Y: []float64{3, -2, 1}}
+	if lo, hi := tab.Domain(); lo != -1 || hi != 4 {
+		t.Errorf("Domain() = %g, %g; want -1, 4", lo, hi)
+	}
+	if lo, hi := tab.Range(); lo != -2 || hi != 3 {
+		t.Errorf("Range() = %g, %g; want -2, 3", lo, hi)
+	}
+}
+
+func TestTableResample(t *testing.T) {
+	got := rising.Resample(5)
+	want := Table{
+		X: []float64{0, 5, 10, 15, 20},
+		Y: []float64{0, 2.5, 5, 12.5, 20},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Resample(5) = %v, want %v", got, want)
+	}
+
+	steps := rising
+	steps.Mode = LookupDiscrete
+	if got := steps.Resample(3); got.Mode != LookupDiscrete || !reflect.DeepEqual(got.Y, rising.Y) {
+		t.Errorf("discrete Resample(3) = %v", got)
+	}
+}
+
+var grid = Table2D{
+	X: []float64{0, 1},
+	Y: []float64{0, 10},
+	Z: [][]float64{{0, 10}, {20, 40}},
+}
+
+func TestTable2DLookup(t *testing.T) {
+	for _, c := range []struct {
+		mode LookupMode
+		x, y float64
+		want float64
+	}{
+		{LookupInterpolate, 0, 0, 0},
+		{LookupInterpolate, 1, 10, 40},
+		{LookupInterpolate, 0, 10, 10},
+		{LookupInterpolate, .5, 5, 17.5},
+		{LookupInterpolate, 2, 0, 20},
+		{LookupInterpolate, -1, 20, 10},
+		{LookupExtrapolate, .5, 5, 17.5},
+		{LookupExtrapolate, 2, 0, 40},
+		{LookupExtrapolate, 1.5, 15, 67.5},
+		{LookupDiscrete, .5, 5, 0},
+		{LookupDiscrete, 1, 5, 20},
+		{LookupDiscrete, .99, 10, 10},
+	} {
+		tab := grid
+		tab.Mode = c.mode
+		if got := tab.Lookup(c.x, c.y); got != c.want {
+			t.Errorf("%s Lookup(%g, %g) = %g, want %g", c.mode, c.x, c.y, got, c.want)
+		}
+	}
+}
+
+func TestTable2DValidate(t *testing.T) {
+	for _, c := range []struct {
+		tab Table2D
+		ok  bool
+	}{
+		{grid, true},
+		{Table2D{X: []float64{0}, Y: []float64{0, 1}, Z: [][]float64{{1, 2}}}, false},
+		{Table2D{X: []float64{1, 0}, Y: []float64{0, 1}, Z: [][]float64{{1, 2}, {3, 4}}}, false},
+		{Table2D{X: []float64{0, 1}, Y: []float64{0, 0}, Z: [][]float64{{1, 2}, {3, 4}}}, false},
+		{Table2D{X: []float64{0, 1}, Y: []float64{0, 1}, Z: [][]float64{{1, 2}}}, false},
+		{Table2D{X: []float64{0, 1}, Y: []float64{0, 1}, Z: [][]float64{{1, 2}, {3}}}, false},
+	} {
+		if err := c.tab.Validate(); (err == nil) != c.ok {
+			t.Errorf("Validate(%v) = %v", c.tab, err)
+		}
+	}
+}
@@ -0,0 +1,482 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"fmt"
+	"github.com/bpowers/boosd/runtime"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type compiler struct {
+	p *Program
+
+	consts    map[float64]int
+	tables    map[string]int
+	tables2D  map[string]int
+	conveyors map[string]int
+	queues    map[string]int
+
+	code  []Instr
+	depth int
+}
+
+// Compile compiles the model described by d.
+func Compile(d *ModelDesc) (*Program, error) {
+	p := &Program{
+		BaseModel: runtime.BaseModel{
+			MName:    d.Name,
+			Vars:     d.Vars,
+			Defaults: d.Defaults,
+			Attrs:    d.Attrs,
+		},
+		Time:   d.Time,
+		Params: map[string]int{},
+	}
+	p.Slots = map[string]int{}
+	p.Tables = d.Tables
+	p.Tables2D = d.Tables2D
+	c := &compiler{
+		p:         p,
+		consts:    map[float64]int{},
+		tables:    map[string]int{},
+		tables2D:  map[string]int{},
+		conveyors: map[string]int{},
+		queues:    map[string]int{},
+	}
+
+	// give every variable a slot up front, in name order, so
+	// that slots don't depend on equation order.
+	c.slot("time")
+	names := make([]string, 0, len(d.Vars))
+	for n := range d.Vars {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		c.slot(n)
+	}
+
+	// tables are numbered in name order too.
+	names = names[:0]
+	for n := range d.Tables {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		c.tables[n] = len(p.Lookups)
+		p.Lookups = append(p.Lookups, d.Tables[n])
+	}
+	names = names[:0]
+	for n := range d.Tables2D {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		c.tables2D[n] = len(p.Lookups2D)
+		p.Lookups2D = append(p.Lookups2D, d.Tables2D[n])
+	}
+
+	var err error
+	if p.Initial, err = c.compile(d.Initial); err != nil {
+		return nil, fmt.Errorf("calcInitial: %s", err)
+	}
+	if p.Flows, err = c.compile(d.Flows); err != nil {
+		return nil, fmt.Errorf("calcFlows: %s", err)
+	}
+	if p.Stocks, err = c.compile(d.Stocks); err != nil {
+		return nil, fmt.Errorf("calcStocks: %s", err)
+	}
+
+	return p, nil
+}
+
+// slot returns the slot of the variable name, allocating one if
+// needed.
+func (c *compiler) slot(name string) int32 {
+	i, ok := c.p.Slots[name]
+	if !ok {
+		i = len(c.p.SlotNames)
+		c.p.Slots[name] = i
+		c.p.SlotNames = append(c.p.SlotNames, name)
+	}
+	return int32(i)
+}
+
+func (c *compiler) param(name string) int32 {
+	i, ok := c.p.Params[name]
+	if !ok {
+		i = len(c.p.ParamNames)
+		c.p.Params[name] = i
+		c.p.ParamNames = append(c.p.ParamNames, name)
+	}
+	return int32(i)
+}
+
+func (c *compiler) constant(v float64) int32 {
+	i, ok := c.consts[v]
+	if !ok {
+		i = len(c.p.Consts)
+		c.consts[v] = i
+		c.p.Consts = append(c.p.Consts, v)
+	}
+	return int32(i)
+}
+
+// stock returns the slot of the stock name, whose flows the sim's
+// LimitOutflows and LimitInflows scale back.
+func (c *compiler) stock(name string) (int32, error) {
+	if v, ok := c.p.Vars[name]; !ok || v.Type != runtime.TyStock {
+		return 0, fmt.Errorf("limit of unknown stock %s", name)
+	}
+	return c.slot(name), nil
+}
+
+// emit appends an instruction, tracking the depth of the stack.
+func (c *compiler) emit(op Op, a, b int32, delta int) {
+	c.code = append(c.code, Instr{op, a, b})
+	c.depth += delta
+	if c.depth > c.p.MaxStack {
+		c.p.MaxStack = c.depth
+	}
+}
+
+// compile compiles a list of generated Go statements.
+func (c *compiler) compile(code []string) ([]Instr, error) {
+	src := "package p\nfunc f() {\n" + strings.Join(code, "\n") + "\n}\n"
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	if err != nil {
+		return nil, err
+	}
+	body := f.Decls[0].(*ast.FuncDecl).Body
+
+	c.code = nil
+	c.depth = 0
+	if err := c.stmts(body.List); err != nil {
+		return nil, err
+	}
+	return c.code, nil
+}
+
+func (c *compiler) stmts(list []ast.Stmt) error {
+	for _, st := range list {
+		if err := c.stmt(st); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *compiler) stmt(st ast.Stmt) error {
+	switch st := st.(type) {
+	case *ast.AssignStmt:
+		if st.Tok != token.ASSIGN || len(st.Lhs) != 1 || len(st.Rhs) != 1 {
+			return fmt.Errorf("unsupported assignment")
+		}
+		field, key, err := SimIndex(st.Lhs[0])
+		if err != nil {
+			return err
+		}
+		switch field {
+		case "Curr", "Next":
+			if err := c.expr(st.Rhs[0]); err != nil {
+				return fmt.Errorf("%s: %s", key, err)
+			}
+			op := OpStore
+			if field == "Next" {
+				op = OpStoreNext
+			}
+			c.emit(op, c.slot(key), 0, -1)
+			return nil
+		case "Conveyors", "Queues":
+			call, ok := st.Rhs[0].(*ast.CallExpr)
+			if !ok {
+				return fmt.Errorf("%s: state not created by a call", key)
+			}
+			want, op, states := "runtime.NewConveyor", OpNewConveyor, c.conveyors
+			names := &c.p.Conveyors
+			if field == "Queues" {
+				want, op, states = "runtime.NewQueue", OpNewQueue, c.queues
+				names = &c.p.Queues
+			}
+			if Selector(call.Fun) != want {
+				return fmt.Errorf("%s: created with %s, not %s", key, Selector(call.Fun), want)
+			}
+			if err := c.args(call.Args); err != nil {
+				return fmt.Errorf("%s: %s", key, err)
+			}
+			i, ok := states[key]
+			if !ok {
+				i = len(*names)
+				states[key] = i
+				*names = append(*names, key)
+			}
+			c.emit(op, int32(i), 0, -len(call.Args))
+			return nil
+		}
+		return fmt.Errorf("assignment to unsupported s.%s", field)
+	case *ast.ExprStmt:
+		call, ok := st.X.(*ast.CallExpr)
+		if !ok || len(call.Args) != 2 {
+			return fmt.Errorf("unsupported expression statement")
+		}
+		op := OpLimitOutflows
+		switch Selector(call.Fun) {
+		case "s.LimitOutflows":
+		case "s.LimitInflows":
+			op = OpLimitInflows
+		default:
+			return fmt.Errorf("unsupported call of %s", Selector(call.Fun))
+		}
+		stock, err := StringLit(call.Args[0])
+		if err != nil {
+			return err
+		}
+		i, err := c.stock(stock)
+		if err != nil {
+			return err
+		}
+		if err := c.expr(call.Args[1]); err != nil {
+			return err
+		}
+		c.emit(op, i, 0, -1)
+		return nil
+	case *ast.IfStmt:
+		if st.Init != nil || st.Else != nil || Selector(st.Cond) != "s.Initializing" {
+			return fmt.Errorf("unsupported if statement")
+		}
+		jump := len(c.code)
+		c.emit(OpJumpUnlessInit, 0, 0, 0)
+		if err := c.stmts(st.Body.List); err != nil {
+			return err
+		}
+		c.code[jump].A = int32(len(c.code))
+		return nil
+	}
+	return fmt.Errorf("unsupported statement %T", st)
+}
+
+func (c *compiler) args(args []ast.Expr) error {
+	for _, a := range args {
+		if err := c.expr(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *compiler) expr(e ast.Expr) error {
+	switch e := e.(type) {
+	case *ast.BasicLit:
+		if e.Kind != token.INT && e.Kind != token.FLOAT {
+			return fmt.Errorf("non-numeric literal %s", e.Value)
+		}
+		v, err := strconv.ParseFloat(e.Value, 64)
+		if err != nil {
+			return err
+		}
+		c.emit(OpConst, c.constant(v), 0, 1)
+	case *ast.Ident:
+		if e.Name != "dt" {
+			return fmt.Errorf("unknown identifier %s", e.Name)
+		}
+		c.emit(OpDT, 0, 0, 1)
+	case *ast.ParenExpr:
+		return c.expr(e.X)
+	case *ast.UnaryExpr:
+		if err := c.expr(e.X); err != nil {
+			return err
+		}
+		switch e.Op {
+		case token.ADD:
+		case token.SUB:
+			c.emit(OpNeg, 0, 0, 0)
+		default:
+			return fmt.Errorf("unsupported unary operator %s", e.Op)
+		}
+	case *ast.BinaryExpr:
+		if err := c.expr(e.X); err != nil {
+			return err
+		}
+		if err := c.expr(e.Y); err != nil {
+			return err
+		}
+		var op Op
+		switch e.Op {
+		case token.ADD:
+			op = OpAdd
+		case token.SUB:
+			op = OpSub
+		case token.MUL:
+			op = OpMul
+		case token.QUO:
+			op = OpDiv
+		default:
+			return fmt.Errorf("unsupported binary operator %s", e.Op)
+		}
+		c.emit(op, 0, 0, -1)
+	case *ast.IndexExpr:
+		field, key, err := SimIndex(e)
+		if err != nil {
+			return err
+		}
+		switch field {
+		case "Curr":
+			c.emit(OpLoad, c.slot(key), 0, 1)
+		case "Next":
+			c.emit(OpLoadNext, c.slot(key), 0, 1)
+		default:
+			return fmt.Errorf("unsupported index of s.%s", field)
+		}
+	case *ast.SelectorExpr:
+		// the timespec can be overridden per sim, so isn't
+		// a constant.
+		var field int32
+		switch Selector(e) {
+		case "s.Time.Start":
+			field = TimeStart
+		case "s.Time.End":
+			field = TimeEnd
+		case "s.Time.DT":
+			field = TimeDT
+		case "s.Time.SaveStep":
+			field = TimeSaveStep
+		default:
+			return fmt.Errorf("unsupported selector %s", Selector(e))
+		}
+		c.emit(OpTime, field, 0, 1)
+	case *ast.CallExpr:
+		return c.call(e)
+	default:
+		return fmt.Errorf("unsupported expression %T", e)
+	}
+	return nil
+}
+
+func (c *compiler) call(call *ast.CallExpr) error {
+	fun, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return fmt.Errorf("unsupported call of %T", call.Fun)
+	}
+	name := Selector(fun)
+
+	switch name {
+	case "s.Now":
+		c.emit(OpLoad, c.slot("time"), 0, 1)
+		return nil
+	case "c.Data":
+		if len(call.Args) != 2 {
+			return fmt.Errorf("c.Data takes 2 arguments")
+		}
+		n, err := StringLit(call.Args[1])
+		if err != nil {
+			return err
+		}
+		c.emit(OpParam, c.param(n), 0, 1)
+		return nil
+	}
+
+	if idx, ok := fun.X.(*ast.IndexExpr); ok {
+		field, key, err := SimIndex(idx)
+		if err != nil {
+			return err
+		}
+		var states map[string]int
+		var op Op
+		nargs := 1
+		switch field + "." + fun.Sel.Name {
+		case "Tables.Lookup":
+			states, op = c.tables, OpLookup
+		case "Tables2D.Lookup":
+			states, op, nargs = c.tables2D, OpLookup2D, 2
+		case "Conveyors.Outflow":
+			states, op = c.conveyors, OpConveyorOutflow
+		case "Conveyors.Leakage":
+			states, op = c.conveyors, OpConveyorLeakage
+		case "Conveyors.Advance":
+			states, op, nargs = c.conveyors, OpConveyorAdvance, 2
+		case "Conveyors.Total":
+			states, op, nargs = c.conveyors, OpConveyorTotal, 0
+		case "Queues.Advance":
+			states, op, nargs = c.queues, OpQueueAdvance, 3
+		case "Queues.Total":
+			states, op, nargs = c.queues, OpQueueTotal, 0
+		default:
+			return fmt.Errorf("unsupported call of s.%s[...].%s", field, fun.Sel.Name)
+		}
+		i, ok := states[key]
+		if !ok {
+			return fmt.Errorf("s.%s[%q] undefined", field, key)
+		}
+		if len(call.Args) != nargs {
+			return fmt.Errorf("%s takes %d arguments, not %d", name, nargs, len(call.Args))
+		}
+		if err := c.args(call.Args); err != nil {
+			return err
+		}
+		c.emit(op, int32(i), 0, 1-nargs)
+		return nil
+	}
+
+	for i, f := range Funcs {
+		if f.Name != name {
+			continue
+		}
+		if len(call.Args) != f.NArgs {
+			return fmt.Errorf("%s takes %d arguments, not %d", name, f.NArgs, len(call.Args))
+		}
+		if err := c.args(call.Args); err != nil {
+			return err
+		}
+		c.emit(OpCall, int32(i), int32(f.NArgs), 1-f.NArgs)
+		return nil
+	}
+	return fmt.Errorf("unknown function %s", name)
+}
+
+// SimIndex returns the field and key of an expression like
+// s.Curr["name"] in the code the boosd compiler generates.
+func SimIndex(e ast.Expr) (field, key string, err error) {
+	idx, ok := e.(*ast.IndexExpr)
+	if !ok {
+		return "", "", fmt.Errorf("unsupported expression %T", e)
+	}
+	x, ok := idx.X.(*ast.SelectorExpr)
+	if !ok || Selector(x.X) != "s" {
+		return "", "", fmt.Errorf("unsupported index of %s", Selector(idx.X))
+	}
+	key, err = StringLit(idx.Index)
+	return x.Sel.Name, key, err
+}
+
+// StringLit returns the value of the string literal e.
+func StringLit(e ast.Expr) (string, error) {
+	lit, ok := e.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", fmt.Errorf("%s not a string literal", Selector(e))
+	}
+	return strconv.Unquote(lit.Value)
+}
+
+// Selector returns a selector expression like s.Time.DT as a
+// string, for matching and error messages.
+func Selector(e ast.Expr) string {
+	switch e := e.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.SelectorExpr:
+		return Selector(e.X) + "." + e.Sel.Name
+	case *ast.IndexExpr:
+		return Selector(e.X) + "[...]"
+	case *ast.CallExpr:
+		return Selector(e.Fun) + "(...)"
+	}
+	return fmt.Sprintf("%T", e)
+}
@@ -0,0 +1,103 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"github.com/bpowers/boosd/runtime"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// growth describes a model of a population growing at rate, as the
+// boosd compiler would generate it.
+func growth() *ModelDesc {
+	return &ModelDesc{
+		Name: "main",
+		Time: runtime.Timespec{Start: 0, End: 3, DT: 1, SaveStep: 1},
+		Vars: runtime.VarMap{
+			"rate":   {Name: "rate", Type: runtime.TyAux},
+			"births": {Name: "births", Type: runtime.TyFlow},
+			"pop": {Name: "pop", Type: runtime.TyStock,
+				Inflows: []string{"births"}, NonNegative: true},
+		},
+		Defaults: runtime.DefaultMap{"rate": .5},
+		Initial: []string{
+			`s.Curr["rate"] = c.Data(s, "rate")`,
+			`s.Curr["pop"] = 10`,
+		},
+		Flows: []string{
+			`s.Curr["rate"] = c.Data(s, "rate")`,
+			`s.Curr["births"] = s.Curr["pop"] * s.Curr["rate"]`,
+		},
+		Stocks: []string{
+			`s.Next["pop"] = s.Curr["pop"] + (s.Curr["births"])*dt`,
+		},
+	}
+}
+
+// runPop runs a sim of p, after setting any constants in set, and
+// returns its population.
+func runPop(t *testing.T, p *Program, set map[string]float64) []float64 {
+	s := p.NewSim("main", nil, nil)
+	for n, v := range set {
+		if err := s.SetValue(n, v); err != nil {
+			t.Fatalf("SetValue(%s): %s", n, err)
+		}
+	}
+	if err := s.RunToEnd(); err != nil {
+		t.Fatalf("RunToEnd: %s", err)
+	}
+	r, err := s.ValueSeries("pop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r[1]
+}
+
+func TestCompile(t *testing.T) {
+	p, err := Compile(growth())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"time", "births", "pop", "rate"}; !reflect.DeepEqual(p.SlotNames, want) {
+		t.Errorf("slots %v, want %v", p.SlotNames, want)
+	}
+	if want := []string{"rate"}; !reflect.DeepEqual(p.ParamNames, want) {
+		t.Errorf("params %v, want %v", p.ParamNames, want)
+	}
+
+	if got, want := runPop(t, p, nil), []float64{10, 15, 22.5, 33.75}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pop = %v, want %v", got, want)
+	}
+	// constants change per sim, without recompiling
+	if got, want := runPop(t, p, map[string]float64{"rate": 1}), []float64{10, 20, 40, 80}; !reflect.DeepEqual(got, want) {
+		t.Errorf("with rate 1, pop = %v, want %v", got, want)
+	}
+	if got, want := runPop(t, p, nil), []float64{10, 15, 22.5, 33.75}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after another sim's change, pop = %v, want %v", got, want)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, c := range []struct{ stmt, err string }{
+		{`s.Curr["pop"] = x`, "unknown identifier x"},
+		{`s.Curr["pop"] = math.Cbrt(1)`, "unknown function math.Cbrt"},
+		{`s.Curr["pop"] = "one"`, "non-numeric literal"},
+		{`s.Curr["pop"] = 1 % 2`, "unsupported binary operator"},
+		{`s.Curr["pop"] += 1`, "unsupported assignment"},
+		{`s.Tables["pop"] = nil`, "unsupported s.Tables"},
+		{`s.LimitOutflows("births", dt)`, "unknown stock births"},
+		{`s.Curr["pop"] = s.Tables["none"].Lookup(1)`, "undefined"},
+		{`for {}`, "unsupported statement"},
+		{`s.Curr["pop"] = (`, "expected"},
+	} {
+		d := growth()
+		d.Flows = append(d.Flows, c.stmt)
+		if _, err := Compile(d); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("Compile(%s): %v, want an error mentioning %q", c.stmt, err, c.err)
+		}
+	}
+}
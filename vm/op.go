@@ -0,0 +1,147 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package vm runs boosd models on a small stack-based virtual
+// machine.  A model's equations are compiled once into flat
+// instructions over float64 slots, so a Program can be run many
+// times, with different constants, far more cheaply than models
+// interpreted by the boosd package, which evaluate each equation
+// as a tree of closures and reflective calls.
+package vm
+
+import (
+	"fmt"
+	"github.com/bpowers/boosd/runtime"
+)
+
+type Op uint8
+
+const (
+	OpConst     Op = iota // push Consts[A]
+	OpParam               // push the sim's value of parameter A
+	OpLoad                // push curr[A]
+	OpLoadNext            // push next[A]
+	OpStore               // pop into curr[A]
+	OpStoreNext           // pop into next[A]
+	OpDT                  // push dt
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpNeg
+	OpCall            // pop B arguments, push Funcs[A](args)
+	OpLookup          // pop x, push Lookups[A].Lookup(x)
+	OpLookup2D        // pop y and x, push Lookups2D[A].Lookup(x, y)
+	OpJumpUnlessInit  // jump to A unless initializing
+	OpLimitOutflows   // pop dt, limit the outflows of the stock in slot A
+	OpLimitInflows    // pop dt, limit the inflows of the stock in slot A
+	OpNewConveyor     // pop 4 arguments, create conveyor A
+	OpConveyorOutflow // pop dt, push conveyor A's outflow
+	OpConveyorLeakage // pop dt, push conveyor A's leakage
+	OpConveyorAdvance // pop inflow and outflow, push conveyor A's new total
+	OpConveyorTotal   // push conveyor A's total
+	OpNewQueue        // pop 2 arguments, create queue A
+	OpQueueAdvance    // pop 3 arguments, push queue A's new total
+	OpQueueTotal      // push queue A's total
+	OpTime            // push field A of the sim's timespec
+)
+
+// The fields of the timespec OpTime can push.
+const (
+	TimeStart = iota
+	TimeEnd
+	TimeDT
+	TimeSaveStep
+)
+
+var opNames = [...]string{
+	OpConst:           "const",
+	OpParam:           "param",
+	OpLoad:            "load",
+	OpLoadNext:        "loadnext",
+	OpStore:           "store",
+	OpStoreNext:       "storenext",
+	OpDT:              "dt",
+	OpAdd:             "add",
+	OpSub:             "sub",
+	OpMul:             "mul",
+	OpDiv:             "div",
+	OpNeg:             "neg",
+	OpCall:            "call",
+	OpLookup:          "lookup",
+	OpLookup2D:        "lookup2d",
+	OpJumpUnlessInit:  "jumpunlessinit",
+	OpLimitOutflows:   "limitoutflows",
+	OpLimitInflows:    "limitinflows",
+	OpNewConveyor:     "newconveyor",
+	OpConveyorOutflow: "conveyoroutflow",
+	OpConveyorLeakage: "conveyorleakage",
+	OpConveyorAdvance: "conveyoradvance",
+	OpConveyorTotal:   "conveyortotal",
+	OpNewQueue:        "newqueue",
+	OpQueueAdvance:    "queueadvance",
+	OpQueueTotal:      "queuetotal",
+	OpTime:            "time",
+}
+
+func (op Op) String() string {
+	if int(op) < len(opNames) {
+		return opNames[op]
+	}
+	return fmt.Sprintf("op(%d)", op)
+}
+
+// An Instr is a single VM instruction.  The meaning of its operands
+// depends on the Op.
+type Instr struct {
+	Op Op
+	A  int32
+	B  int32
+}
+
+func (in Instr) String() string {
+	return fmt.Sprintf("%s %d %d", in.Op, in.A, in.B)
+}
+
+// A Func is a function callable with OpCall.
+type Func struct {
+	Name  string
+	NArgs int
+	Fn    func(s *Sim, args []float64) float64
+}
+
+// Funcs are the functions available to OpCall, named as the
+// generated Go code calls them.
+var Funcs = []Func{
+	{"runtime.Abs", 1, func(_ *Sim, a []float64) float64 { return runtime.Abs(a[0]) }},
+	{"runtime.Exp", 1, func(_ *Sim, a []float64) float64 { return runtime.Exp(a[0]) }},
+	{"runtime.Ln", 1, func(_ *Sim, a []float64) float64 { return runtime.Ln(a[0]) }},
+	{"runtime.Sqrt", 1, func(_ *Sim, a []float64) float64 { return runtime.Sqrt(a[0]) }},
+	{"runtime.Min", 2, func(_ *Sim, a []float64) float64 { return runtime.Min(a[0], a[1]) }},
+	{"runtime.Max", 2, func(_ *Sim, a []float64) float64 { return runtime.Max(a[0], a[1]) }},
+	{"runtime.Uniflow", 1, func(_ *Sim, a []float64) float64 { return runtime.Uniflow(a[0]) }},
+	{"runtime.Less", 2, func(_ *Sim, a []float64) float64 { return runtime.Less(a[0], a[1]) }},
+	{"runtime.LessEq", 2, func(_ *Sim, a []float64) float64 { return runtime.LessEq(a[0], a[1]) }},
+	{"runtime.Greater", 2, func(_ *Sim, a []float64) float64 { return runtime.Greater(a[0], a[1]) }},
+	{"runtime.GreaterEq", 2, func(_ *Sim, a []float64) float64 { return runtime.GreaterEq(a[0], a[1]) }},
+	{"runtime.Equal", 2, func(_ *Sim, a []float64) float64 { return runtime.Equal(a[0], a[1]) }},
+	{"runtime.NotEqual", 2, func(_ *Sim, a []float64) float64 { return runtime.NotEqual(a[0], a[1]) }},
+	{"runtime.And", 2, func(_ *Sim, a []float64) float64 { return runtime.And(a[0], a[1]) }},
+	{"runtime.Or", 2, func(_ *Sim, a []float64) float64 { return runtime.Or(a[0], a[1]) }},
+	{"s.RandomUniform", 2, func(s *Sim, a []float64) float64 {
+		return s.Rand.Uniform(a[0], a[1])
+	}},
+	{"s.RandomNormal", 4, func(s *Sim, a []float64) float64 {
+		return s.Rand.TruncNormal(a[0], a[1], a[2], a[3])
+	}},
+	{"s.RandomPoisson", 1, func(s *Sim, a []float64) float64 {
+		return s.Rand.Poisson(a[0])
+	}},
+	{"s.WhiteNoise", 4, func(s *Sim, a []float64) float64 {
+		return s.Rand.WhiteNoise(a[0], a[1], a[2], a[3])
+	}},
+	{"s.Pulse", 3, func(s *Sim, a []float64) float64 {
+		return s.Pulse(a[0], a[1], a[2])
+	}},
+}
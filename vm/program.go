@@ -0,0 +1,63 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"github.com/bpowers/boosd/runtime"
+)
+
+// A ModelDesc describes a model by the Go statements the boosd
+// compiler generates for its calcInitial, calcFlows and calcStocks
+// functions, along with the model's metadata.
+type ModelDesc struct {
+	Name     string
+	Time     runtime.Timespec
+	Vars     runtime.VarMap
+	Defaults runtime.DefaultMap
+	Tables   map[string]runtime.Table
+	Tables2D map[string]runtime.Table2D
+	Attrs    map[string]interface{}
+
+	Initial []string
+	Flows   []string
+	Stocks  []string
+}
+
+// A Program is a compiled model.  It is immutable once compiled, so
+// any number of sims can run it concurrently.  The Slots of its
+// BaseModel give the index of each variable in a sim's state.
+type Program struct {
+	runtime.BaseModel
+	Time runtime.Timespec
+
+	// SlotNames are the variables' names, by slot.
+	SlotNames []string
+
+	// Params are the model's constants, which sims read with
+	// OpParam and can be changed with SetValue before a run.
+	Params     map[string]int
+	ParamNames []string
+
+	Consts    []float64
+	Lookups   []runtime.Table
+	Lookups2D []runtime.Table2D
+
+	// Conveyors and Queues name the state of conveyor and queue
+	// stocks, by the index their ops use.
+	Conveyors []string
+	Queues    []string
+
+	Initial []Instr
+	Flows   []Instr
+	Stocks  []Instr
+
+	// MaxStack is the deepest the stack gets running any of
+	// the code.
+	MaxStack int
+}
+
+func (p *Program) NewSim(name string, c runtime.Coordinator, opts *runtime.Options) runtime.Sim {
+	return newSim(p, name, c, opts)
+}
@@ -0,0 +1,161 @@
+// Copyright 2013 Bobby Powers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"fmt"
+	"github.com/bpowers/boosd/runtime"
+)
+
+// A Sim runs a Program.  Stepping, integration, saving results,
+// checkpoints and everything else a sim does is left to the
+// embedded runtime.BaseSim, as for compiled and interpreted models;
+// the VM only evaluates the model's equations.
+type Sim struct {
+	runtime.BaseSim
+
+	prog *Program
+
+	// params are the sim's values for the model's constants,
+	// indexed as OpParam's operand.
+	params []float64
+	stack  []float64
+}
+
+func newSim(p *Program, name string, c runtime.Coordinator, opts *runtime.Options) *Sim {
+	s := &Sim{
+		prog:   p,
+		params: make([]float64, len(p.ParamNames)),
+		stack:  make([]float64, p.MaxStack),
+	}
+	s.InstanceName = name
+	s.Coord = c
+
+	s.Init(p, &p.BaseModel, p.Time, opts)
+
+	s.CalcInitial = func(dt float64) { s.exec(p.Initial, dt) }
+	s.CalcFlows = func(dt float64) { s.exec(p.Flows, dt) }
+	s.CalcStocks = func(dt float64) { s.exec(p.Stocks, dt) }
+	s.ConstsChanged = s.loadParams
+	s.loadParams()
+
+	return s
+}
+
+// loadParams sets the sim's constants to its overrides, or else the
+// model's defaults.
+func (s *Sim) loadParams() {
+	for i, n := range s.prog.ParamNames {
+		v, ok := s.Override(n)
+		if !ok {
+			v, _ = s.prog.Default(n)
+		}
+		s.params[i] = v
+	}
+}
+
+func (s *Sim) exec(code []Instr, dt float64) {
+	p := s.prog
+	stack := s.stack
+	sp := 0
+
+	for pc := 0; pc < len(code); pc++ {
+		in := code[pc]
+		switch in.Op {
+		case OpConst:
+			stack[sp] = p.Consts[in.A]
+			sp++
+		case OpParam:
+			stack[sp] = s.params[in.A]
+			sp++
+		case OpLoad:
+			stack[sp] = s.Curr[in.A]
+			sp++
+		case OpLoadNext:
+			stack[sp] = s.Next[in.A]
+			sp++
+		case OpStore:
+			sp--
+			s.Curr[in.A] = stack[sp]
+		case OpStoreNext:
+			sp--
+			s.Next[in.A] = stack[sp]
+		case OpDT:
+			stack[sp] = dt
+			sp++
+		case OpAdd:
+			sp--
+			stack[sp-1] += stack[sp]
+		case OpSub:
+			sp--
+			stack[sp-1] -= stack[sp]
+		case OpMul:
+			sp--
+			stack[sp-1] *= stack[sp]
+		case OpDiv:
+			sp--
+			stack[sp-1] /= stack[sp]
+		case OpNeg:
+			stack[sp-1] = -stack[sp-1]
+		case OpCall:
+			sp -= int(in.B)
+			stack[sp] = Funcs[in.A].Fn(s, stack[sp:sp+int(in.B)])
+			sp++
+		case OpLookup:
+			stack[sp-1] = p.Lookups[in.A].Lookup(stack[sp-1])
+		case OpLookup2D:
+			sp--
+			stack[sp-1] = p.Lookups2D[in.A].Lookup(stack[sp-1], stack[sp])
+		case OpJumpUnlessInit:
+			if !s.Initializing {
+				pc = int(in.A) - 1
+			}
+		case OpLimitOutflows:
+			sp--
+			s.LimitOutflows(int(in.A), stack[sp])
+		case OpLimitInflows:
+			sp--
+			s.LimitInflows(int(in.A), stack[sp])
+		case OpNewConveyor:
+			sp -= 4
+			a := stack[sp:]
+			s.Conveyors[p.Conveyors[in.A]] = runtime.NewConveyor(a[0], a[1], a[2], a[3])
+		case OpConveyorOutflow:
+			stack[sp-1] = s.Conveyors[p.Conveyors[in.A]].Outflow(stack[sp-1])
+		case OpConveyorLeakage:
+			stack[sp-1] = s.Conveyors[p.Conveyors[in.A]].Leakage(stack[sp-1])
+		case OpConveyorAdvance:
+			sp--
+			stack[sp-1] = s.Conveyors[p.Conveyors[in.A]].Advance(stack[sp-1], stack[sp])
+		case OpConveyorTotal:
+			stack[sp] = s.Conveyors[p.Conveyors[in.A]].Total()
+			sp++
+		case OpNewQueue:
+			sp -= 2
+			s.Queues[p.Queues[in.A]] = runtime.NewQueue(stack[sp], stack[sp+1])
+		case OpQueueAdvance:
+			sp -= 2
+			a := stack[sp-1:]
+			stack[sp-1] = s.Queues[p.Queues[in.A]].Advance(a[0], a[1], a[2])
+		case OpQueueTotal:
+			stack[sp] = s.Queues[p.Queues[in.A]].Total()
+			sp++
+		case OpTime:
+			switch in.A {
+			case TimeStart:
+				stack[sp] = s.Time.Start
+			case TimeEnd:
+				stack[sp] = s.Time.End
+			case TimeDT:
+				stack[sp] = s.Time.DT
+			case TimeSaveStep:
+				stack[sp] = s.Time.SaveStep
+			}
+			sp++
+		default:
+			panic(fmt.Sprintf("unknown op %s", in.Op))
+		}
+	}
+}